@@ -5,15 +5,18 @@
 package common
 
 import (
+	"context"
+	"crypto/x509"
 	"encoding/pem"
 	"intel/isecl/go-trust-agent/v4/constants"
 	"io/ioutil"
+	"net/http"
 	"os"
 
 	"github.com/pkg/errors"
 )
 
-func (handler *requestHandlerImpl) GetAikDerBytes() ([]byte, error) {
+func (handler *requestHandlerImpl) GetAikDerBytes(ctx context.Context) ([]byte, error) {
 	aikBytes, err := getAikPem()
 	if err != nil {
 		return nil, err
@@ -29,7 +32,8 @@ func (handler *requestHandlerImpl) GetAikDerBytes() ([]byte, error) {
 
 func getAikPem() ([]byte, error) {
 	if _, err := os.Stat(constants.AikCert); os.IsNotExist(err) {
-		return nil, errors.Wrapf(err, "AIK %s does not exist", constants.AikCert)
+		log.WithError(err).Errorf("common/aik:getAikPem() %s does not exist", constants.AikCert)
+		return nil, &EndpointError{Message: "The AIK certificate has not been provisioned", StatusCode: http.StatusNotFound, Code: ErrorCodeAikMissing}
 	}
 
 	aikPem, err := ioutil.ReadFile(constants.AikCert)
@@ -39,3 +43,24 @@ func getAikPem() ([]byte, error) {
 
 	return aikPem, nil
 }
+
+// GetAikCaDerBytes returns the privacy-ca certificate (downloaded from HVS during
+// 'download-privacy-ca') that issued the AIK certificates.
+func (handler *requestHandlerImpl) GetAikCaDerBytes(ctx context.Context) ([]byte, error) {
+	if _, err := os.Stat(constants.PrivacyCA); os.IsNotExist(err) {
+		return nil, &EndpointError{Message: "The privacy-ca certificate has not been provisioned", StatusCode: http.StatusNotFound, Code: ErrorCodePrivacyCaMissing}
+	}
+
+	privacyCaBytes, err := ioutil.ReadFile(constants.PrivacyCA)
+	if err != nil {
+		return nil, errors.Wrapf(err, "common/aik:GetAikCaDerBytes() Error reading %s", constants.PrivacyCA)
+	}
+
+	// make sure a valid (der encoded) certificate is returned
+	_, err = x509.ParseCertificate(privacyCaBytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "common/aik:GetAikCaDerBytes() Error parsing %s", constants.PrivacyCA)
+	}
+
+	return privacyCaBytes, nil
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package common
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"intel/isecl/go-trust-agent/v4/constants"
+	"intel/isecl/lib/tpmprovider/v4"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/intel-secl/intel-secl/v4/pkg/lib/common/log/message"
+	"github.com/pkg/errors"
+)
+
+// checkAikCertificate returns an error (503) when the public key of the AIK in the TPM does not
+// match the AIK certificate (base64 encoded pem) that was read before the quote was signed.
+// 'renew-aik' replaces the AIK before it installs the new certificate: checking the key after
+// the quote guarantees that a quote is never returned with the certificate of another key.
+func checkAikCertificate(tpm tpmprovider.TpmProvider, aik string) error {
+	aikModulus, err := tpm.GetAikBytes()
+	if err != nil {
+		return errors.Wrap(err, "common/aik_renewal:checkAikCertificate() Error while reading the AIK's public key")
+	}
+
+	aikPem, err := base64.StdEncoding.DecodeString(aik)
+	if err != nil {
+		return errors.Wrap(err, "common/aik_renewal:checkAikCertificate() Error decoding the AIK certificate")
+	}
+
+	block, _ := pem.Decode(aikPem)
+	if block == nil {
+		return errors.Errorf("common/aik_renewal:checkAikCertificate() %s does not contain a pem encoded certificate", constants.AikCert)
+	}
+
+	aikCert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return errors.Wrapf(err, "common/aik_renewal:checkAikCertificate() Error parsing %s", constants.AikCert)
+	}
+
+	publicKey, ok := aikCert.PublicKey.(*rsa.PublicKey)
+	if !ok || !bytes.Equal(publicKey.N.Bytes(), new(big.Int).SetBytes(aikModulus).Bytes()) {
+		secLog.Warnf("common/aik_renewal:checkAikCertificate() %s - The AIK does not match %s, the AIK is being renewed", message.AppRuntimeErr, constants.AikCert)
+		return &EndpointError{Message: "The AIK is being renewed, please retry the request", StatusCode: http.StatusServiceUnavailable, Code: ErrorCodeAikRenewalInProgress}
+	}
+
+	return nil
+}
+
+// StartAikExpiryCheck logs a warning (every 'interval') when the AIK certificate expires
+// within 'warningPeriod'.  The returned function stops the check.
+func StartAikExpiryCheck(interval time.Duration, warningPeriod time.Duration) func() {
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			CheckAikExpiry(warningPeriod)
+
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+// CheckAikExpiry logs a warning when the AIK certificate expires within 'warningPeriod' (and
+// an error when the certificate has expired).
+func CheckAikExpiry(warningPeriod time.Duration) {
+	log.Trace("common/aik_renewal:CheckAikExpiry() Entering")
+	defer log.Trace("common/aik_renewal:CheckAikExpiry() Leaving")
+
+	notAfter, err := readCertificateExpiry(constants.AikCert)
+	if err != nil {
+		log.WithError(err).Debug("common/aik_renewal:CheckAikExpiry() Could not read the AIK certificate")
+		return
+	}
+
+	remaining := time.Until(notAfter)
+	if remaining <= 0 {
+		log.Errorf("common/aik_renewal:CheckAikExpiry() The AIK certificate expired on %s, run 'tagent setup renew-aik'", notAfter.Format(time.RFC3339))
+	} else if remaining < warningPeriod {
+		log.Warnf("common/aik_renewal:CheckAikExpiry() The AIK certificate expires on %s, run 'tagent setup renew-aik'", notAfter.Format(time.RFC3339))
+	}
+}
+
+func readCertificateExpiry(certPath string) (time.Time, error) {
+	certPem, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	block, _ := pem.Decode(certPem)
+	if block == nil {
+		return time.Time{}, errors.Errorf("%s does not contain a pem encoded certificate", certPath)
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "Error parsing %s", certPath)
+	}
+
+	return cert.NotAfter, nil
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package common
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"intel/isecl/lib/tpmprovider/v4"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAikCertificate(t *testing.T) (*rsa.PrivateKey, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "HVS Privacy Certificate"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes}))
+}
+
+func TestCheckAikCertificate(t *testing.T) {
+	key, aik := newTestAikCertificate(t)
+
+	mockedTpmProvider := new(tpmprovider.MockedTpmProvider)
+	mockedTpmProvider.On("GetAikBytes").Return(key.PublicKey.N.Bytes(), nil)
+
+	err := checkAikCertificate(mockedTpmProvider, aik)
+	assert.NoError(t, err)
+}
+
+func TestCheckAikCertificateRenewed(t *testing.T) {
+	_, aik := newTestAikCertificate(t)
+	renewedKey, _ := newTestAikCertificate(t)
+
+	// 'renew-aik' replaced the AIK but has not yet installed its certificate
+	mockedTpmProvider := new(tpmprovider.MockedTpmProvider)
+	mockedTpmProvider.On("GetAikBytes").Return(renewedKey.PublicKey.N.Bytes(), nil)
+
+	err := checkAikCertificate(mockedTpmProvider, aik)
+	endpointError, ok := err.(*EndpointError)
+	if !ok {
+		t.Fatalf("Expected an EndpointError, got %+v", err)
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, endpointError.StatusCode)
+	assert.Equal(t, ErrorCodeAikRenewalInProgress, endpointError.Code)
+}
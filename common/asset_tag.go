@@ -5,53 +5,296 @@
 package common
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"intel/isecl/go-trust-agent/v4/constants"
+	"intel/isecl/go-trust-agent/v4/util"
 	"intel/isecl/lib/tpmprovider/v4"
 	"net/http"
+	"strings"
 
 	"github.com/intel-secl/intel-secl/v4/pkg/lib/common/log/message"
 	"github.com/intel-secl/intel-secl/v4/pkg/lib/common/validation"
 	taModel "github.com/intel-secl/intel-secl/v4/pkg/model/ta"
+	"github.com/pkg/errors"
 )
 
-func (handler *requestHandlerImpl) DeployAssetTag(tagWriteRequest *taModel.TagWriteRequest) error {
+// DeployAssetTag writes the tag to the TPM's asset tag nv index.  'caller' identifies the
+// requester (ex. the JWT subject) in the asset tag audit trail.
+func (handler *requestHandlerImpl) DeployAssetTag(ctx context.Context, tagWriteRequest *taModel.TagWriteRequest, caller string) error {
+	log := GetLogger(ctx)
+
+	historyRecord := AssetTagHistoryRecord{
+		Caller:       caller,
+		Operation:    AssetTagOperationDeploy,
+		HardwareUUID: tagWriteRequest.HardwareUUID,
+		NewTag:       base64.StdEncoding.EncodeToString(tagWriteRequest.Tag),
+	}
 
 	err := validation.ValidateHardwareUUID(tagWriteRequest.HardwareUUID)
 	if err != nil {
 		log.WithError(err).Errorf("common/asset_tag:DeployAssetTag( %s - Invalid hardware_uuid '%s'", message.InvalidInputBadParam, tagWriteRequest.HardwareUUID)
-		return &EndpointError{Message: "Invalid hardware_uuid", StatusCode: http.StatusBadRequest}
+		err = &EndpointError{Message: "Invalid hardware_uuid", StatusCode: http.StatusBadRequest, Code: ErrorCodeInvalidTag}
+		recordAssetTagHistory(&historyRecord, err)
+		return err
 	}
 
-	tpmFactory, err := tpmprovider.NewTpmFactory()
+	tagIndex, err := util.NewAssetTagIndex(tagWriteRequest.Tag)
 	if err != nil {
+		log.WithError(err).Errorf("common/asset_tag:DeployAssetTag() %s - Invalid tag", message.InvalidInputBadParam)
+		err = &EndpointError{Message: "Invalid tag: " + err.Error(), StatusCode: http.StatusBadRequest, Code: ErrorCodeInvalidTag}
+		recordAssetTagHistory(&historyRecord, err)
 		return err
 	}
 
-	tpm, err := tpmFactory.NewTpmProvider()
+	if handler.cfg.AssetTag.SkipHardwareUUIDCheck {
+		log.Warnf("common/asset_tag:DeployAssetTag() Not comparing hardware_uuid '%s' to the host's hardware uuid (%s is enabled)", tagWriteRequest.HardwareUUID, constants.EnvSkipTagHardwareUUIDCheck)
+	} else {
+		err = validateTagHardwareUUID(tagWriteRequest.HardwareUUID)
+		if err != nil {
+			recordAssetTagHistory(&historyRecord, err)
+			return err
+		}
+	}
+
+	err = handler.tpmBroker.Execute(ctx, TpmOperationDeployAssetTag, func(tpm tpmprovider.TpmProvider) error {
+		historyRecord.PreviousTag = readPreviousAssetTag(handler.cfg.Tpm.TagSecretKey, tpm)
+		return deployAssetTag(handler.cfg.Tpm.TagSecretKey, tpm, tagIndex)
+	})
+
+	recordAssetTagHistory(&historyRecord, err)
+	return err
+}
+
+// readHostInfo reads the host's platform-info (replaced in unit tests).
+var readHostInfo = util.ReadHostInfo
+
+// validateTagHardwareUUID makes sure that a tag is only written to the host it was
+// created for (i.e. a misrouted request cannot write another host's tag).
+func validateTagHardwareUUID(hardwareUUID string) error {
+	hostInfo, err := readHostInfo()
 	if err != nil {
-		log.WithError(err).Errorf("common/asset_tag:DeployAssetTag() %s - Error creating tpm provider", message.AppRuntimeErr)
+		log.WithError(err).Errorf("common/asset_tag:validateTagHardwareUUID() %s - Error reading host info", message.AppRuntimeErr)
 		return &EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError}
 	}
 
-	defer tpm.Close()
+	if !strings.EqualFold(strings.TrimSpace(hostInfo.HardwareUUID), hardwareUUID) {
+		secLog.Errorf("common/asset_tag:validateTagHardwareUUID() %s - The tag's hardware_uuid '%s' does not match the host's hardware uuid '%s'",
+			message.InvalidInputBadParam, hardwareUUID, hostInfo.HardwareUUID)
+		return &EndpointError{
+			Message:    fmt.Sprintf("The hardware_uuid '%s' does not match the host's hardware uuid '%s'", hardwareUUID, hostInfo.HardwareUUID),
+			StatusCode: http.StatusConflict,
+			Code:       ErrorCodeHardwareUUIDMismatch,
+		}
+	}
+
+	return nil
+}
+
+func deployAssetTag(tagSecretKey string, tpm tpmprovider.TpmProvider, tagIndex *util.AssetTagIndex) error {
 
 	// check if an asset tag does not exist, it should have been created during provisioning
 	nvExists, err := tpm.NvIndexExists(tpmprovider.NV_IDX_ASSET_TAG)
 	if err != nil {
 		log.WithError(err).Errorf("common/asset_tag:DeployAssetTag() %s - Error checking if asset tag exists", message.AppRuntimeErr)
-		return &EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError}
+		return &EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError, Code: ErrorCodeTpmError}
 	}
 
 	if !nvExists {
 		log.WithError(err).Errorf("resource/asset_tag:setAssetTag() %s - The asset tag index does not exist", message.AppRuntimeErr)
-		return &EndpointError{Message: "The asset tag index does not exist", StatusCode: http.StatusInternalServerError}
+		return &EndpointError{Message: "The asset tag index does not exist", StatusCode: http.StatusInternalServerError, Code: ErrorCodeTagIndexMissing}
+	}
+
+	indexSize, err := getTagIndexSize(tagSecretKey, tpm)
+	if err != nil {
+		log.WithError(err).Errorf("common/asset_tag:DeployAssetTag() %s - Error reading asset tag index", message.AppRuntimeErr)
+		return &EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError, Code: ErrorCodeTpmError}
+	}
+
+	// indexes that have not been migrated by 'define-tag-index' can only store sha384 tags
+	var indexBytes []byte
+	if indexSize == constants.LegacyTagIndexSize {
+		if tagIndex.Algorithm != constants.SHA384 {
+			log.Errorf("common/asset_tag:DeployAssetTag() %s - A %s tag cannot be written to the legacy asset tag index", message.InvalidInputBadParam, tagIndex.Algorithm)
+			return &EndpointError{
+				Message:    "The asset tag index only supports SHA384 tags, run 'tagent setup define-tag-index' to migrate the index",
+				StatusCode: http.StatusConflict,
+				Code:       ErrorCodeTagIndexUnsupported,
+			}
+		}
+		indexBytes = tagIndex.Digest
+	} else {
+		indexBytes, err = tagIndex.Marshal()
+		if err != nil {
+			log.WithError(err).Errorf("common/asset_tag:DeployAssetTag() %s - Error creating asset tag index", message.AppRuntimeErr)
+			return &EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError}
+		}
 	}
 
 	// write the tag
-	err = tpm.NvWrite(handler.cfg.Tpm.TagSecretKey, tpmprovider.NV_IDX_ASSET_TAG, tpmprovider.NV_IDX_ASSET_TAG, tagWriteRequest.Tag)
+	err = tpm.NvWrite(tagSecretKey, tpmprovider.NV_IDX_ASSET_TAG, tpmprovider.NV_IDX_ASSET_TAG, indexBytes)
 	if err != nil {
 		log.WithError(err).Errorf("common/asset_tag:DeployAssetTag() %s - Error writing asset tag", message.AppRuntimeErr)
-		return &EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError}
+		return &EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError, Code: ErrorCodeTpmError}
+	}
+
+	return nil
+}
+
+// getTagIndexSize returns the size of the asset tag nv index, which is either
+// constants.TagIndexSize or constants.LegacyTagIndexSize (before 'define-tag-index'
+// has migrated the index).
+func getTagIndexSize(tagSecretKey string, tpm tpmprovider.TpmProvider) (int, error) {
+	indexBytes, err := tpm.NvRead(tagSecretKey, tpmprovider.NV_IDX_ASSET_TAG, tpmprovider.NV_IDX_ASSET_TAG)
+	if err != nil {
+		return 0, errors.Wrap(err, "common/asset_tag:getTagIndexSize() Error while performing tpm nv read operation")
+	}
+
+	if len(indexBytes) != constants.TagIndexSize && len(indexBytes) != constants.LegacyTagIndexSize {
+		return 0, errors.Errorf("common/asset_tag:getTagIndexSize() Invalid tag index length %d", len(indexBytes))
+	}
+
+	return len(indexBytes), nil
+}
+
+// NvIndexInfo describes the nv index used to store the asset tag.  'Size' is the length
+// of the index data (constants.TagIndexSize or constants.LegacyTagIndexSize).  'Attributes'
+// (TPMA_NV), 'AttributeNames' and the hex encoded 'AuthPolicy' come from the index's public
+// area and are omitted when it cannot be read.
+type NvIndexInfo struct {
+	Index          string   `json:"index"`
+	Exists         bool     `json:"exists"`
+	Size           int      `json:"size,omitempty"`
+	Attributes     string   `json:"attributes,omitempty"`
+	AttributeNames []string `json:"attribute_names,omitempty"`
+	AuthPolicy     string   `json:"auth_policy,omitempty"`
+}
+
+// AssetTag is returned by GET /v2/tag and 'tagent tag show'.  'Tag' is the base64
+// encoded tag hash written by HVS and 'Algorithm' its hash algorithm (both are empty
+// when the tag is not provisioned).
+type AssetTag struct {
+	Tag           string      `json:"tag,omitempty"`
+	Algorithm     string      `json:"algorithm,omitempty"`
+	IsProvisioned bool        `json:"is_provisioned"`
+	NvIndex       NvIndexInfo `json:"nv_index"`
+}
+
+func (handler *requestHandlerImpl) GetAssetTag(ctx context.Context) (*AssetTag, error) {
+	var assetTag *AssetTag
+
+	err := handler.tpmBroker.Execute(ctx, TpmOperationReadAssetTag, func(tpm tpmprovider.TpmProvider) error {
+		var err error
+		assetTag, err = ReadAssetTag(handler.cfg.Tpm.TagSecretKey, tpm)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return assetTag, nil
+}
+
+// ReadAssetTag reads the current asset tag (using the same logic that includes the tag
+// in quotes) and the size, attributes and auth policy of its nv index.
+func ReadAssetTag(tagSecretKey string, tpm tpmprovider.TpmProvider) (*AssetTag, error) {
+	log.Trace("common/asset_tag:ReadAssetTag() Entering")
+	defer log.Trace("common/asset_tag:ReadAssetTag() Leaving")
+
+	assetTag := AssetTag{
+		NvIndex: NvIndexInfo{
+			Index: fmt.Sprintf("0x%x", tpmprovider.NV_IDX_ASSET_TAG),
+		},
+	}
+
+	nvExists, err := tpm.NvIndexExists(tpmprovider.NV_IDX_ASSET_TAG)
+	if err != nil {
+		return nil, errors.Wrap(err, "common/asset_tag:ReadAssetTag() Error checking if asset tag exists")
+	}
+
+	if !nvExists {
+		return &assetTag, nil
+	}
+
+	assetTag.NvIndex.Exists = true
+	assetTag.NvIndex.Size, err = getTagIndexSize(tagSecretKey, tpm)
+	if err != nil {
+		return nil, errors.Wrap(err, "common/asset_tag:ReadAssetTag() Error reading the asset tag index")
+	}
+
+	public, err := readNvPublic(tpmprovider.NV_IDX_ASSET_TAG)
+	if err != nil {
+		log.WithError(err).Warn("common/asset_tag:ReadAssetTag() Could not read the asset tag index's attributes")
+	} else {
+		assetTag.NvIndex.Attributes = fmt.Sprintf("0x%08x", public.attributes)
+		assetTag.NvIndex.AttributeNames = getNvAttributeNames(public.attributes)
+		assetTag.NvIndex.AuthPolicy = hex.EncodeToString(public.authPolicy)
+	}
+
+	tagIndex, err := readAssetTagIndex(tagSecretKey, tpm)
+	if err != nil {
+		return nil, errors.Wrap(err, "common/asset_tag:ReadAssetTag() Error reading asset tag")
+	}
+
+	if tagIndex != nil {
+		assetTag.Tag = base64.StdEncoding.EncodeToString(tagIndex.Digest)
+		assetTag.Algorithm = string(tagIndex.Algorithm)
+		assetTag.IsProvisioned = true
+	}
+	return &assetTag, nil
+}
+
+func (handler *requestHandlerImpl) ClearAssetTag(ctx context.Context, caller string) error {
+	return handler.tpmBroker.Execute(ctx, TpmOperationClearAssetTag, func(tpm tpmprovider.TpmProvider) error {
+		return ClearAssetTag(handler.cfg.Tpm.TagSecretKey, tpm, caller)
+	})
+}
+
+// ClearAssetTag zeros the asset tag nv index (using the tag secret) so that the host is
+// reported as 'not tag provisioned' in subsequent quotes.  The index itself is not
+// released, so a new tag can be deployed without re-running 'define-tag-index'.  The
+// operation is recorded in the asset tag audit trail with 'caller'.
+func ClearAssetTag(tagSecretKey string, tpm tpmprovider.TpmProvider, caller string) error {
+	log.Trace("common/asset_tag:ClearAssetTag() Entering")
+	defer log.Trace("common/asset_tag:ClearAssetTag() Leaving")
+
+	historyRecord := AssetTagHistoryRecord{
+		Caller:      caller,
+		Operation:   AssetTagOperationClear,
+		PreviousTag: readPreviousAssetTag(tagSecretKey, tpm),
+	}
+
+	err := clearAssetTag(tagSecretKey, tpm)
+	recordAssetTagHistory(&historyRecord, err)
+	return err
+}
+
+func clearAssetTag(tagSecretKey string, tpm tpmprovider.TpmProvider) error {
+	nvExists, err := tpm.NvIndexExists(tpmprovider.NV_IDX_ASSET_TAG)
+	if err != nil {
+		log.WithError(err).Errorf("common/asset_tag:clearAssetTag() %s - Error checking if asset tag exists", message.AppRuntimeErr)
+		return &EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError, Code: ErrorCodeTpmError}
+	}
+
+	if !nvExists {
+		log.Errorf("common/asset_tag:clearAssetTag() %s - The asset tag index does not exist", message.AppRuntimeErr)
+		return &EndpointError{Message: "The asset tag index does not exist", StatusCode: http.StatusInternalServerError, Code: ErrorCodeTagIndexMissing}
+	}
+
+	indexSize, err := getTagIndexSize(tagSecretKey, tpm)
+	if err != nil {
+		log.WithError(err).Errorf("common/asset_tag:clearAssetTag() %s - Error reading asset tag index", message.AppRuntimeErr)
+		return &EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError, Code: ErrorCodeTpmError}
+	}
+
+	err = tpm.NvWrite(tagSecretKey, tpmprovider.NV_IDX_ASSET_TAG, tpmprovider.NV_IDX_ASSET_TAG, make([]byte, indexSize))
+	if err != nil {
+		log.WithError(err).Errorf("common/asset_tag:clearAssetTag() %s - Error clearing asset tag", message.AppRuntimeErr)
+		return &EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError, Code: ErrorCodeTpmError}
 	}
 
+	secLog.Infof("common/asset_tag:clearAssetTag() The asset tag in nv index 0x%x was cleared", tpmprovider.NV_IDX_ASSET_TAG)
 	return nil
 }
@@ -0,0 +1,150 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package common
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"intel/isecl/go-trust-agent/v4/constants"
+	"intel/isecl/go-trust-agent/v4/util"
+	"intel/isecl/lib/tpmprovider/v4"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/intel-secl/intel-secl/v4/pkg/lib/common/log/message"
+	"github.com/pkg/errors"
+)
+
+const (
+	AssetTagOperationDeploy = "deploy"
+	AssetTagOperationClear  = "clear"
+
+	AssetTagResultSuccess = "success"
+)
+
+// AssetTagHistoryRecord is an entry in the asset tag audit trail.  Tags are the base64
+// encoded tag hashes (as reported in quotes) and are empty when no tag was provisioned.
+type AssetTagHistoryRecord struct {
+	Timestamp    time.Time `json:"timestamp"`
+	Caller       string    `json:"caller"`
+	Operation    string    `json:"operation"`
+	HardwareUUID string    `json:"hardware_uuid,omitempty"`
+	PreviousTag  string    `json:"previous_tag,omitempty"`
+	NewTag       string    `json:"new_tag,omitempty"`
+	Result       string    `json:"result"`
+}
+
+var tagHistoryMutex sync.Mutex
+
+// assetTagHistoryFilePath is the asset tag audit trail (constants.AssetTagHistoryFilePath).
+var assetTagHistoryFilePath = constants.AssetTagHistoryFilePath
+
+// recordAssetTagHistory appends the record (with the result of 'err') to the asset tag
+// audit trail.  The file contains one json record per line and is only appended to.
+func recordAssetTagHistory(record *AssetTagHistoryRecord, err error) {
+	record.Timestamp = time.Now()
+	record.Result = AssetTagResultSuccess
+	if err != nil {
+		record.Result = err.Error()
+	}
+
+	recordJSON, jsonErr := json.Marshal(record)
+	if jsonErr != nil {
+		log.WithError(jsonErr).Errorf("common/asset_tag_history:recordAssetTagHistory() %s - Error marshaling asset tag history", message.AppRuntimeErr)
+		return
+	}
+
+	tagHistoryMutex.Lock()
+	defer tagHistoryMutex.Unlock()
+
+	_, fileErr := os.Stat(assetTagHistoryFilePath)
+	created := os.IsNotExist(fileErr)
+
+	historyFile, fileErr := os.OpenFile(assetTagHistoryFilePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if fileErr != nil {
+		log.WithError(fileErr).Errorf("common/asset_tag_history:recordAssetTagHistory() %s - Error opening %s", message.AppRuntimeErr, assetTagHistoryFilePath)
+		return
+	}
+	defer func() {
+		derr := historyFile.Close()
+		if derr != nil {
+			log.WithError(derr).Warn("Error closing file")
+		}
+	}()
+
+	if created {
+		// the file is created by root when 'tagent tag clear' is the first operation, the
+		// service (tagent user) must be able to read and append to it
+		fileErr = util.ChownToServiceUser(assetTagHistoryFilePath)
+		if fileErr != nil {
+			log.WithError(fileErr).Errorf("common/asset_tag_history:recordAssetTagHistory() %s - Error changing the owner of %s", message.AppRuntimeErr, assetTagHistoryFilePath)
+		}
+	}
+
+	_, fileErr = historyFile.Write(append(recordJSON, '\n'))
+	if fileErr != nil {
+		log.WithError(fileErr).Errorf("common/asset_tag_history:recordAssetTagHistory() %s - Error writing to %s", message.AppRuntimeErr, assetTagHistoryFilePath)
+		return
+	}
+
+	secLog.Infof("common/asset_tag_history:recordAssetTagHistory() Asset tag %s by '%s': %s", record.Operation, record.Caller, record.Result)
+}
+
+// readPreviousAssetTag returns the current tag before it is changed (for the audit trail).
+// Errors are logged (and an empty tag is returned) so that they do not prevent the change.
+func readPreviousAssetTag(tagSecretKey string, tpm tpmprovider.TpmProvider) string {
+	previousTag, err := getAssetTags(tagSecretKey, tpm)
+	if err != nil {
+		log.WithError(err).Warn("common/asset_tag_history:readPreviousAssetTag() Could not read the current asset tag")
+		return ""
+	}
+
+	return previousTag
+}
+
+func (handler *requestHandlerImpl) GetAssetTagHistory(ctx context.Context) ([]AssetTagHistoryRecord, error) {
+	return ReadAssetTagHistory()
+}
+
+// ReadAssetTagHistory returns the records in the asset tag audit trail (oldest first).
+func ReadAssetTagHistory() ([]AssetTagHistoryRecord, error) {
+	records := []AssetTagHistoryRecord{}
+
+	tagHistoryMutex.Lock()
+	defer tagHistoryMutex.Unlock()
+
+	historyFile, err := os.Open(assetTagHistoryFilePath)
+	if os.IsNotExist(err) {
+		return records, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "common/asset_tag_history:ReadAssetTagHistory() Error opening %s", assetTagHistoryFilePath)
+	}
+	defer func() {
+		derr := historyFile.Close()
+		if derr != nil {
+			log.WithError(derr).Warn("Error closing file")
+		}
+	}()
+
+	scanner := bufio.NewScanner(historyFile)
+	for scanner.Scan() {
+		var record AssetTagHistoryRecord
+		err = json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			log.WithError(err).Warnf("common/asset_tag_history:ReadAssetTagHistory() Skipping invalid record in %s", assetTagHistoryFilePath)
+			continue
+		}
+
+		records = append(records, record)
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "common/asset_tag_history:ReadAssetTagHistory() Error reading %s", assetTagHistoryFilePath)
+	}
+
+	return records, nil
+}
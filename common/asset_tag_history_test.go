@@ -0,0 +1,108 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package common
+
+import (
+	"context"
+	"intel/isecl/go-trust-agent/v4/config"
+	"intel/isecl/lib/tpmprovider/v4"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	taModel "github.com/intel-secl/intel-secl/v4/pkg/model/ta"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// useTestAssetTagHistory writes the asset tag audit trail to a temporary directory until the
+// returned function is called.
+func useTestAssetTagHistory(t *testing.T) func() {
+	tmpDir, err := ioutil.TempDir("", "asset-tag-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defaultFilePath := assetTagHistoryFilePath
+	assetTagHistoryFilePath = filepath.Join(tmpDir, "asset-tag-history.json")
+	return func() {
+		assetTagHistoryFilePath = defaultFilePath
+		os.RemoveAll(tmpDir)
+	}
+}
+
+func TestRecordAssetTagHistory(t *testing.T) {
+	assert := assert.New(t)
+	defer useTestAssetTagHistory(t)()
+
+	history, err := ReadAssetTagHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(history)
+
+	recordAssetTagHistory(&AssetTagHistoryRecord{Caller: "admin", Operation: AssetTagOperationDeploy, NewTag: "dGFn"}, nil)
+	recordAssetTagHistory(&AssetTagHistoryRecord{Caller: "root", Operation: AssetTagOperationClear, PreviousTag: "dGFn"}, errors.New("clear failed"))
+
+	fileInfo, err := os.Stat(assetTagHistoryFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(os.FileMode(0640), fileInfo.Mode().Perm())
+
+	history, err = ReadAssetTagHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(history, 2)
+	assert.Equal("admin", history[0].Caller)
+	assert.Equal(AssetTagOperationDeploy, history[0].Operation)
+	assert.Equal(AssetTagResultSuccess, history[0].Result)
+	assert.False(history[0].Timestamp.IsZero())
+	assert.Equal("root", history[1].Caller)
+	assert.Equal(AssetTagOperationClear, history[1].Operation)
+	assert.Equal("clear failed", history[1].Result)
+}
+
+func TestDeployAssetTagInvalidHardwareUUIDRecorded(t *testing.T) {
+	assert := assert.New(t)
+	defer useTestAssetTagHistory(t)()
+
+	mockedTpmProvider := new(tpmprovider.MockedTpmProvider)
+	mockedTpmProvider.On("Close").Return(nil)
+
+	handler := requestHandlerImpl{
+		cfg:       &config.TrustAgentConfiguration{},
+		tpmBroker: NewTpmBroker(tpmprovider.MockedTpmFactory{TpmProvider: mockedTpmProvider}, 1, time.Second),
+	}
+	defer handler.tpmBroker.Close()
+
+	tagWriteRequest := taModel.TagWriteRequest{Tag: make([]byte, 64), HardwareUUID: "not-a-uuid"}
+	err := handler.DeployAssetTag(context.Background(), &tagWriteRequest, "nats")
+
+	endpointError, ok := err.(*EndpointError)
+	if !ok {
+		t.Fatalf("Expected an EndpointError, got %v", err)
+	}
+	assert.Equal(http.StatusBadRequest, endpointError.StatusCode)
+
+	history, err := ReadAssetTagHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(history, 1)
+	assert.Equal("nats", history[0].Caller)
+	assert.Equal("not-a-uuid", history[0].HardwareUUID)
+	assert.Equal(endpointError.Error(), history[0].Result)
+
+	// the tpm was not used
+	mockedTpmProvider.AssertNotCalled(t, "NvWrite", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,301 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"encoding/base64"
+	"intel/isecl/go-trust-agent/v4/config"
+	"intel/isecl/go-trust-agent/v4/constants"
+	"intel/isecl/go-trust-agent/v4/util"
+	"intel/isecl/lib/tpmprovider/v4"
+	"net/http"
+	"testing"
+	"time"
+
+	taModel "github.com/intel-secl/intel-secl/v4/pkg/model/ta"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestReadAssetTag(t *testing.T) {
+	assert := assert.New(t)
+
+	tag := sha512.Sum512([]byte("asset tag certificate"))
+	tagIndex, err := util.NewAssetTagIndex(tag[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indexBytes, err := tagIndex.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer useTestNvPublic(&nvPublic{
+		nvIndex:    tpmprovider.NV_IDX_ASSET_TAG,
+		attributes: 0x20040004,
+		authPolicy: []byte{0xab, 0xcd},
+	}, nil)()
+
+	mockedTpmProvider := new(tpmprovider.MockedTpmProvider)
+	mockedTpmProvider.On("NvIndexExists", mock.Anything).Return(true, nil)
+	mockedTpmProvider.On("NvRead", mock.Anything, mock.Anything, mock.Anything).Return(indexBytes, nil)
+
+	assetTag, err := ReadAssetTag("", mockedTpmProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(assetTag.IsProvisioned)
+	assert.Equal(base64.StdEncoding.EncodeToString(tag[:]), assetTag.Tag)
+	assert.Equal(string(constants.SHA512), assetTag.Algorithm)
+	assert.True(assetTag.NvIndex.Exists)
+	assert.Equal(constants.TagIndexSize, assetTag.NvIndex.Size)
+	assert.Equal("0x20040004", assetTag.NvIndex.Attributes)
+	assert.Equal([]string{"TYPE_ORDINARY", "AUTHWRITE", "AUTHREAD", "WRITTEN"}, assetTag.NvIndex.AttributeNames)
+	assert.Equal("abcd", assetTag.NvIndex.AuthPolicy)
+}
+
+func TestReadAssetTagNvPublicUnavailable(t *testing.T) {
+	assert := assert.New(t)
+
+	defer useTestNvPublic(nil, errors.New("no resource manager"))()
+
+	mockedTpmProvider := new(tpmprovider.MockedTpmProvider)
+	mockedTpmProvider.On("NvIndexExists", mock.Anything).Return(true, nil)
+	mockedTpmProvider.On("NvRead", mock.Anything, mock.Anything, mock.Anything).Return(make([]byte, constants.LegacyTagIndexSize), nil)
+
+	assetTag, err := ReadAssetTag("", mockedTpmProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(assetTag.NvIndex.Exists)
+	assert.Equal(constants.LegacyTagIndexSize, assetTag.NvIndex.Size)
+	assert.Empty(assetTag.NvIndex.Attributes)
+	assert.Empty(assetTag.NvIndex.AttributeNames)
+	assert.Empty(assetTag.NvIndex.AuthPolicy)
+}
+
+func TestReadAssetTagLegacyIndexNotProvisioned(t *testing.T) {
+	assert := assert.New(t)
+
+	mockedTpmProvider := new(tpmprovider.MockedTpmProvider)
+	mockedTpmProvider.On("NvIndexExists", mock.Anything).Return(true, nil)
+	mockedTpmProvider.On("NvRead", mock.Anything, mock.Anything, mock.Anything).Return(make([]byte, constants.LegacyTagIndexSize), nil)
+
+	assetTag, err := ReadAssetTag("", mockedTpmProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.False(assetTag.IsProvisioned)
+	assert.Empty(assetTag.Tag)
+	assert.Equal(constants.LegacyTagIndexSize, assetTag.NvIndex.Size)
+}
+
+func TestReadAssetTagIndexMissing(t *testing.T) {
+	assert := assert.New(t)
+
+	mockedTpmProvider := new(tpmprovider.MockedTpmProvider)
+	mockedTpmProvider.On("NvIndexExists", mock.Anything).Return(false, nil)
+
+	assetTag, err := ReadAssetTag("", mockedTpmProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.False(assetTag.IsProvisioned)
+	assert.False(assetTag.NvIndex.Exists)
+	assert.Zero(assetTag.NvIndex.Size)
+	mockedTpmProvider.AssertNotCalled(t, "NvRead", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestReadAssetTagInvalidIndex(t *testing.T) {
+	mockedTpmProvider := new(tpmprovider.MockedTpmProvider)
+	mockedTpmProvider.On("NvIndexExists", mock.Anything).Return(true, nil)
+	mockedTpmProvider.On("NvRead", mock.Anything, mock.Anything, mock.Anything).Return(bytes.Repeat([]byte{1}, 10), nil)
+
+	_, err := ReadAssetTag("", mockedTpmProvider)
+	assert.Error(t, err)
+}
+
+// useTestHostInfo returns 'hardwareUUID' as the host's hardware uuid until the returned
+// function is called.
+func useTestHostInfo(hardwareUUID string) func() {
+	defaultReadHostInfo := readHostInfo
+	readHostInfo = func() (*taModel.HostInfo, error) {
+		return &taModel.HostInfo{HardwareUUID: hardwareUUID}, nil
+	}
+
+	return func() {
+		readHostInfo = defaultReadHostInfo
+	}
+}
+
+func newTestAssetTagHandler(cfg *config.TrustAgentConfiguration, mockedTpmProvider *tpmprovider.MockedTpmProvider) *requestHandlerImpl {
+	mockedTpmProvider.On("Close").Return(nil)
+	return &requestHandlerImpl{
+		cfg:       cfg,
+		tpmBroker: NewTpmBroker(tpmprovider.MockedTpmFactory{TpmProvider: mockedTpmProvider}, 1, time.Second),
+	}
+}
+
+func TestClearAssetTag(t *testing.T) {
+	assert := assert.New(t)
+	defer useTestAssetTagHistory(t)()
+
+	tag := sha512.Sum512([]byte("asset tag certificate"))
+	tagIndex, err := util.NewAssetTagIndex(tag[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indexBytes, err := tagIndex.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockedTpmProvider := new(tpmprovider.MockedTpmProvider)
+	mockedTpmProvider.On("NvIndexExists", mock.Anything).Return(true, nil)
+	mockedTpmProvider.On("NvRead", mock.Anything, mock.Anything, mock.Anything).Return(indexBytes, nil)
+	mockedTpmProvider.On("NvWrite", mock.Anything, mock.Anything, mock.Anything, make([]byte, constants.TagIndexSize)).Return(nil)
+
+	handler := newTestAssetTagHandler(&config.TrustAgentConfiguration{}, mockedTpmProvider)
+	defer handler.tpmBroker.Close()
+
+	err = handler.ClearAssetTag(context.Background(), "jwt:admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the index is zeroed (not released)
+	mockedTpmProvider.AssertCalled(t, "NvWrite", mock.Anything, uint32(tpmprovider.NV_IDX_ASSET_TAG), uint32(tpmprovider.NV_IDX_ASSET_TAG), make([]byte, constants.TagIndexSize))
+
+	history, err := ReadAssetTagHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(history, 1)
+	assert.Equal("jwt:admin", history[0].Caller)
+	assert.Equal(AssetTagOperationClear, history[0].Operation)
+	assert.NotEmpty(history[0].PreviousTag)
+	assert.Equal(AssetTagResultSuccess, history[0].Result)
+}
+
+// TestClearAssetTagIndexMissing uses ClearAssetTag like 'tagent tag clear'.
+func TestClearAssetTagIndexMissing(t *testing.T) {
+	assert := assert.New(t)
+	defer useTestAssetTagHistory(t)()
+
+	mockedTpmProvider := new(tpmprovider.MockedTpmProvider)
+	mockedTpmProvider.On("NvIndexExists", mock.Anything).Return(false, nil)
+
+	err := ClearAssetTag("", mockedTpmProvider, "root")
+
+	endpointError, ok := err.(*EndpointError)
+	if !ok {
+		t.Fatalf("Expected an EndpointError, got %v", err)
+	}
+	assert.Equal(ErrorCodeTagIndexMissing, endpointError.Code)
+	mockedTpmProvider.AssertNotCalled(t, "NvWrite", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	history, err := ReadAssetTagHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(history, 1)
+	assert.Equal("root", history[0].Caller)
+	assert.Equal(AssetTagOperationClear, history[0].Operation)
+	assert.Equal(endpointError.Error(), history[0].Result)
+}
+
+func TestDeployAssetTagHardwareUUIDMismatch(t *testing.T) {
+	assert := assert.New(t)
+	defer useTestAssetTagHistory(t)()
+	defer useTestHostInfo("00e4d709-8d72-e811-906e-00163566263e")()
+
+	mockedTpmProvider := new(tpmprovider.MockedTpmProvider)
+	handler := newTestAssetTagHandler(&config.TrustAgentConfiguration{}, mockedTpmProvider)
+	defer handler.tpmBroker.Close()
+
+	tagWriteRequest := taModel.TagWriteRequest{Tag: make([]byte, 64), HardwareUUID: "7a569dad-2d82-49e4-9156-069b0065b262"}
+	err := handler.DeployAssetTag(context.Background(), &tagWriteRequest, "jwt:admin")
+
+	endpointError, ok := err.(*EndpointError)
+	if !ok {
+		t.Fatalf("Expected an EndpointError, got %v", err)
+	}
+	assert.Equal(http.StatusConflict, endpointError.StatusCode)
+	assert.Equal(ErrorCodeHardwareUUIDMismatch, endpointError.Code)
+
+	// the tag was not written
+	mockedTpmProvider.AssertNotCalled(t, "NvWrite", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	history, err := ReadAssetTagHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(history, 1)
+	assert.Equal("7a569dad-2d82-49e4-9156-069b0065b262", history[0].HardwareUUID)
+	assert.Equal(endpointError.Error(), history[0].Result)
+}
+
+func TestDeployAssetTagHardwareUUID(t *testing.T) {
+	defer useTestAssetTagHistory(t)()
+
+	tests := []struct {
+		name                  string
+		hostHardwareUUID      string
+		skipHardwareUUIDCheck bool
+	}{
+		{"same hardware uuid (case insensitive)", "7A569DAD-2D82-49E4-9156-069B0065B262", false},
+		{"skip hardware uuid check", "00e4d709-8d72-e811-906e-00163566263e", true},
+	}
+
+	for _, test := range tests {
+		resetHostInfo := useTestHostInfo(test.hostHardwareUUID)
+
+		mockedTpmProvider := new(tpmprovider.MockedTpmProvider)
+		mockedTpmProvider.On("NvIndexExists", mock.Anything).Return(true, nil)
+		mockedTpmProvider.On("NvRead", mock.Anything, mock.Anything, mock.Anything).Return(make([]byte, constants.TagIndexSize), nil)
+		mockedTpmProvider.On("NvWrite", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+		cfg := &config.TrustAgentConfiguration{}
+		cfg.AssetTag.SkipHardwareUUIDCheck = test.skipHardwareUUIDCheck
+		handler := newTestAssetTagHandler(cfg, mockedTpmProvider)
+
+		tagWriteRequest := taModel.TagWriteRequest{Tag: make([]byte, 64), HardwareUUID: "7a569dad-2d82-49e4-9156-069b0065b262"}
+		err := handler.DeployAssetTag(context.Background(), &tagWriteRequest, "jwt:admin")
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+
+		mockedTpmProvider.AssertCalled(t, "NvWrite", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+		handler.tpmBroker.Close()
+		resetHostInfo()
+	}
+
+	// the host's platform-info is required to compare the hardware uuids
+	defaultReadHostInfo := readHostInfo
+	defer func() { readHostInfo = defaultReadHostInfo }()
+	readHostInfo = func() (*taModel.HostInfo, error) {
+		return nil, errors.New("platform-info does not exist")
+	}
+
+	err := validateTagHardwareUUID("7a569dad-2d82-49e4-9156-069b0065b262")
+	endpointError, ok := err.(*EndpointError)
+	if !ok || endpointError.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected a 500 EndpointError, got %v", err)
+	}
+}
@@ -5,6 +5,7 @@
 package common
 
 import (
+	"context"
 	"github.com/intel-secl/intel-secl/v4/pkg/lib/common/log/message"
 	"intel/isecl/go-trust-agent/v4/constants"
 	"io/ioutil"
@@ -12,10 +13,12 @@ import (
 	"os"
 )
 
-func (handler *requestHandlerImpl) GetBindingCertificateDerBytes() ([]byte, error) {
+func (handler *requestHandlerImpl) GetBindingCertificateDerBytes(ctx context.Context) ([]byte, error) {
+	log := GetLogger(ctx)
+
 	if _, err := os.Stat(constants.BindingKeyCertificatePath); os.IsNotExist(err) {
 		log.WithError(err).Errorf("common/binding_key_certificate:getBindingKeyCertificate() %s - %s does not exist", message.AppRuntimeErr, constants.BindingKeyCertificatePath)
-		return nil, &EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError}
+		return nil, &EndpointError{Message: "The binding key certificate does not exist", StatusCode: http.StatusNotFound, Code: ErrorCodeBindingKeyMissing}
 	}
 
 	bindingKeyBytes, err := ioutil.ReadFile(constants.BindingKeyCertificatePath)
@@ -5,39 +5,75 @@
 package common
 
 import (
+	"context"
 	"fmt"
 	"intel/isecl/go-trust-agent/v4/config"
+	"intel/isecl/lib/tpmprovider/v4"
+	"sync"
 
 	commLog "github.com/intel-secl/intel-secl/v4/pkg/lib/common/log"
 	taModel "github.com/intel-secl/intel-secl/v4/pkg/model/ta"
+	"github.com/pkg/errors"
 )
 
 var log = commLog.GetDefaultLogger()
 var secLog = commLog.GetSecurityLogger()
 
+// RequestHandler implements the requests received by the web and outbound (NATS) services.
+// 'ctx' is the context of the request (see WithRequestID).
 type RequestHandler interface {
-	GetTpmQuote(quoteRequest *taModel.TpmQuoteRequest) (*taModel.TpmQuoteResponse, error)
-	GetHostInfo() (*taModel.HostInfo, error)
-	GetAikDerBytes() ([]byte, error)
-	DeployAssetTag(*taModel.TagWriteRequest) error
-	GetBindingCertificateDerBytes() ([]byte, error)
-	DeploySoftwareManifest(*taModel.Manifest) error
-	GetApplicationMeasurement(*taModel.Manifest) (*taModel.Measurement, error)
+	GetTpmQuote(ctx context.Context, quoteRequest *taModel.TpmQuoteRequest) (*TpmQuoteResponse, error)
+	GetHostInfo(ctx context.Context) (*taModel.HostInfo, error)
+	GetAikDerBytes(ctx context.Context) ([]byte, error)
+	GetAikCaDerBytes(ctx context.Context) ([]byte, error)
+	GetEndorsementKeyCertificates(ctx context.Context) ([]EndorsementKeyCertificate, error)
+	DeployAssetTag(ctx context.Context, tagWriteRequest *taModel.TagWriteRequest, caller string) error
+	GetBindingCertificateDerBytes(ctx context.Context) ([]byte, error)
+	DeploySoftwareManifest(ctx context.Context, manifest *taModel.Manifest) error
+	GetApplicationMeasurement(ctx context.Context, manifest *taModel.Manifest) (*taModel.Measurement, error)
+	GetTpmMetrics() TpmBrokerMetrics
+	GetTpmPcrs(ctx context.Context) (*TpmPcrs, error)
+	GetAssetTag(ctx context.Context) (*AssetTag, error)
+	ClearAssetTag(ctx context.Context, caller string) error
+	GetAssetTagHistory(ctx context.Context) ([]AssetTagHistoryRecord, error)
+	GetReadiness(ctx context.Context) *HealthStatus
 }
 
-func NewRequestHandler(cfg *config.TrustAgentConfiguration) RequestHandler {
-	return &requestHandlerImpl{
-		cfg: cfg,
+// NewRequestHandler creates a RequestHandler whose TPM operations are serialized
+// by a TpmBroker (see Tpm.QueueSize and Tpm.OperationTimeout in the configuration).
+func NewRequestHandler(cfg *config.TrustAgentConfiguration) (RequestHandler, error) {
+	tpmFactory, err := tpmprovider.NewTpmFactory()
+	if err != nil {
+		return nil, errors.Wrap(err, "common/common:NewRequestHandler() Could not create tpm factory")
 	}
+
+	return &requestHandlerImpl{
+		cfg:       cfg,
+		tpmBroker: NewTpmBroker(tpmFactory, cfg.Tpm.QueueSize, cfg.Tpm.OperationTimeout),
+	}, nil
 }
 
 type requestHandlerImpl struct {
-	cfg *config.TrustAgentConfiguration
+	cfg       *config.TrustAgentConfiguration
+	tpmBroker *TpmBroker
+
+	pcrCacheMutex sync.Mutex
+	pcrCache      *TpmPcrs
+	pcrRead       singleFlight
+
+	readinessMutex sync.Mutex
+	readiness      *HealthStatus
+	readinessCheck singleFlight
+}
+
+func (handler *requestHandlerImpl) GetTpmMetrics() TpmBrokerMetrics {
+	return handler.tpmBroker.Metrics()
 }
 
 type EndpointError struct {
 	Message    string
 	StatusCode int
+	Code       ErrorCode // see ErrorCode()
 }
 
 func (e EndpointError) Error() string {
@@ -5,6 +5,7 @@
 package common
 
 import (
+	"context"
 	"encoding/xml"
 	taModel "github.com/intel-secl/intel-secl/v4/pkg/model/ta"
 	"intel/isecl/go-trust-agent/v4/constants"
@@ -17,23 +18,25 @@ import (
 	flavorConsts "github.com/intel-secl/intel-secl/v4/pkg/lib/flavor/constants"
 )
 
-func (handler *requestHandlerImpl) DeploySoftwareManifest(manifest *taModel.Manifest) error {
+func (handler *requestHandlerImpl) DeploySoftwareManifest(ctx context.Context, manifest *taModel.Manifest) error {
+	log := GetLogger(ctx)
+	secLog := GetSecurityLogger(ctx)
 
 	manifestXml, err := xml.Marshal(manifest)
 	if err != nil {
 		secLog.Errorf("%s common/deploy_manifest:DeploySoftwareManifest() Failed to marshal manifest %s", message.InvalidInputBadParam, err.Error())
-		return &EndpointError{Message: "Error: Failed to marshal manifest", StatusCode: http.StatusBadRequest}
+		return &EndpointError{Message: "Error: Failed to marshal manifest", StatusCode: http.StatusBadRequest, Code: ErrorCodeInvalidManifest}
 	}
 
 	err = validation.ValidateUUIDv4(manifest.Uuid)
 	if err != nil {
 		secLog.Errorf("%s common/deploy_manifest:DeploySoftwareManifest() Invalid uuid %s", message.InvalidInputBadParam, err.Error())
-		return &EndpointError{Message: "Error: Invalid uuid", StatusCode: http.StatusBadRequest}
+		return &EndpointError{Message: "Error: Invalid uuid", StatusCode: http.StatusBadRequest, Code: ErrorCodeInvalidManifest}
 	}
 
 	if len(manifest.Label) == 0 {
 		log.Error("The manifest did not contain a label")
-		return &EndpointError{Message: "Error: The manifest did not contain a label", StatusCode: http.StatusBadRequest}
+		return &EndpointError{Message: "Error: The manifest did not contain a label", StatusCode: http.StatusBadRequest, Code: ErrorCodeInvalidManifest}
 	}
 
 	var manifestlabels []string
@@ -41,13 +44,13 @@ func (handler *requestHandlerImpl) DeploySoftwareManifest(manifest *taModel.Mani
 	err = validation.ValidateStrings(manifestlabels)
 	if err != nil {
 		secLog.Errorf("%s common/deploy_manifest:DeploySoftwareManifest() Invalid manifest labels %s", message.InvalidInputBadParam, err.Error())
-		return &EndpointError{Message: "Error: Invalid manifest labels", StatusCode: http.StatusBadRequest}
+		return &EndpointError{Message: "Error: Invalid manifest labels", StatusCode: http.StatusBadRequest, Code: ErrorCodeInvalidManifest}
 	}
 
 	if strings.Contains(manifest.Label, flavorConsts.DefaultSoftwareFlavorPrefix) ||
 		strings.Contains(manifest.Label, flavorConsts.DefaultWorkloadFlavorPrefix) {
 		log.Errorf("common/deploy_manifest:DeploySoftwareManifest() Default flavor's manifest (%s) is part of installation, no need to deploy default flavor's manifest", manifest.Label)
-		return &EndpointError{Message: " Default flavor's manifest (%s) is part of installation", StatusCode: http.StatusBadRequest}
+		return &EndpointError{Message: " Default flavor's manifest (%s) is part of installation", StatusCode: http.StatusBadRequest, Code: ErrorCodeInvalidManifest}
 	}
 
 	// establish the name of the manifest file and write the file
@@ -0,0 +1,98 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package common
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"intel/isecl/go-trust-agent/v4/util"
+	"intel/isecl/lib/tpmprovider/v4"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CertificateInfo describes a certificate in an EK certificate chain.  'Certificate' is
+// the base64 encoded der certificate.
+type CertificateInfo struct {
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	SerialNumber string    `json:"serial_number"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+	Certificate  string    `json:"certificate"`
+}
+
+// EndorsementKeyCertificate is the certificate chain (EK certificate first) of the RSA or
+// ECC EK, returned by GET /v2/ek-certificate and 'tagent ekcert'.
+type EndorsementKeyCertificate struct {
+	KeyType      string              `json:"key_type"`
+	NvIndex      string              `json:"nv_index"`
+	Chain        []CertificateInfo   `json:"chain"`
+	Certificates []*x509.Certificate `json:"-"`
+}
+
+func (handler *requestHandlerImpl) GetEndorsementKeyCertificates(ctx context.Context) ([]EndorsementKeyCertificate, error) {
+	var ekCertificates []EndorsementKeyCertificate
+
+	err := handler.tpmBroker.Execute(ctx, TpmOperationReadEkCertificates, func(tpm tpmprovider.TpmProvider) error {
+		var err error
+		ekCertificates, err = ReadEndorsementKeyCertificates(handler.cfg.Tpm.TagSecretKey, tpm)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ekCertificates, nil
+}
+
+// ReadEndorsementKeyCertificates returns the certificate chains of the RSA and ECC EKs that
+// are provisioned in the TPM (see util.GetEndorsementKeyCertificateChain).
+func ReadEndorsementKeyCertificates(ownerSecretKey string, tpm tpmprovider.TpmProvider) ([]EndorsementKeyCertificate, error) {
+	log.Trace("common/ek_certificate:ReadEndorsementKeyCertificates() Entering")
+	defer log.Trace("common/ek_certificate:ReadEndorsementKeyCertificates() Leaving")
+
+	ekCertificates := []EndorsementKeyCertificate{}
+
+	for _, ekCertIndex := range util.EndorsementKeyCertificateIndexes {
+		chain, err := util.GetEndorsementKeyCertificateChain(tpm, ownerSecretKey, ekCertIndex.NvIndex)
+		if err != nil {
+			return nil, errors.Wrapf(err, "common/ek_certificate:ReadEndorsementKeyCertificates() Error reading the %s EK certificate", ekCertIndex.KeyType)
+		}
+
+		if chain == nil {
+			log.Debugf("common/ek_certificate:ReadEndorsementKeyCertificates() The TPM does not have an %s EK certificate at 0x%x", ekCertIndex.KeyType, ekCertIndex.NvIndex)
+			continue
+		}
+
+		ekCertificate := EndorsementKeyCertificate{
+			KeyType:      ekCertIndex.KeyType,
+			NvIndex:      fmt.Sprintf("0x%x", ekCertIndex.NvIndex),
+			Certificates: chain,
+		}
+
+		for _, cert := range chain {
+			ekCertificate.Chain = append(ekCertificate.Chain, CertificateInfo{
+				Subject:      cert.Subject.String(),
+				Issuer:       cert.Issuer.String(),
+				SerialNumber: cert.SerialNumber.String(),
+				NotBefore:    cert.NotBefore,
+				NotAfter:     cert.NotAfter,
+				Certificate:  base64.StdEncoding.EncodeToString(cert.Raw),
+			})
+		}
+
+		ekCertificates = append(ekCertificates, ekCertificate)
+	}
+
+	if len(ekCertificates) == 0 {
+		return nil, errors.New("common/ek_certificate:ReadEndorsementKeyCertificates() The TPM does not have an EK certificate")
+	}
+
+	return ekCertificates, nil
+}
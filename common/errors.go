@@ -0,0 +1,99 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package common
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrorCode identifies the cause of an error in REST/NATS error responses.  Codes are part
+// of the API and must not be changed once they are released.
+type ErrorCode string
+
+const (
+	ErrorCodeInternal             ErrorCode = "INTERNAL_ERROR"
+	ErrorCodeInvalidRequest       ErrorCode = "INVALID_REQUEST"
+	ErrorCodeUnauthorized         ErrorCode = "UNAUTHORIZED"
+	ErrorCodeNotFound             ErrorCode = "NOT_FOUND"
+	ErrorCodeTpmUnavailable       ErrorCode = "TPM_UNAVAILABLE"
+	ErrorCodeTpmError             ErrorCode = "TPM_ERROR"
+	ErrorCodeAikMissing           ErrorCode = "AIK_MISSING"
+	ErrorCodeAikRenewalInProgress ErrorCode = "AIK_RENEWAL_IN_PROGRESS"
+	ErrorCodePrivacyCaMissing     ErrorCode = "PRIVACY_CA_MISSING"
+	ErrorCodeBindingKeyMissing    ErrorCode = "BINDING_KEY_MISSING"
+	ErrorCodeTagIndexMissing      ErrorCode = "TAG_INDEX_MISSING"
+	ErrorCodeTagIndexUnsupported  ErrorCode = "TAG_INDEX_UNSUPPORTED"
+	ErrorCodeInvalidTag           ErrorCode = "INVALID_TAG"
+	ErrorCodeHardwareUUIDMismatch ErrorCode = "HARDWARE_UUID_MISMATCH"
+	ErrorCodeInvalidManifest      ErrorCode = "INVALID_MANIFEST"
+	ErrorCodeMeasurementFailed    ErrorCode = "MEASUREMENT_FAILED"
+	ErrorCodeTimeout              ErrorCode = "TIMEOUT"
+	ErrorCodeRateLimited          ErrorCode = "RATE_LIMITED"
+)
+
+// ErrorResponse is the (json) body of REST and NATS error responses.
+type ErrorResponse struct {
+	Code      ErrorCode `json:"code"`
+	Message   string    `json:"message"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// ErrorCode returns the EndpointError's code, or a generic code for its status when a
+// code was not provided.
+func (e EndpointError) ErrorCode() ErrorCode {
+	if e.Code != "" {
+		return e.Code
+	}
+
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		return ErrorCodeInvalidRequest
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrorCodeUnauthorized
+	case http.StatusNotFound:
+		return ErrorCodeNotFound
+	case http.StatusServiceUnavailable:
+		return ErrorCodeTpmUnavailable
+	case http.StatusTooManyRequests:
+		return ErrorCodeRateLimited
+	default:
+		return ErrorCodeInternal
+	}
+}
+
+// NewErrorResponse returns the status code and ErrorResponse for an error returned by the
+// RequestHandler.  The messages of errors other than EndpointErrors are not returned to
+// the client, since they can contain internal details.
+func NewErrorResponse(err error, requestID string) (int, *ErrorResponse) {
+	var endpointError *EndpointError
+	switch t := errors.Cause(err).(type) {
+	case *EndpointError:
+		endpointError = t
+	case EndpointError:
+		endpointError = &t
+	}
+
+	if endpointError != nil {
+		return endpointError.StatusCode, &ErrorResponse{
+			Code:      endpointError.ErrorCode(),
+			Message:   endpointError.Message,
+			RequestID: requestID,
+		}
+	}
+
+	if errors.Cause(err) == context.DeadlineExceeded {
+		return http.StatusServiceUnavailable, &ErrorResponse{Code: ErrorCodeTimeout, Message: "The request timed out", RequestID: requestID}
+	}
+
+	if err != nil && strings.TrimSpace(strings.ToLower(err.Error())) == "record not found" {
+		return http.StatusNotFound, &ErrorResponse{Code: ErrorCodeNotFound, Message: err.Error(), RequestID: requestID}
+	}
+
+	return http.StatusInternalServerError, &ErrorResponse{Code: ErrorCodeInternal, Message: "Error processing request", RequestID: requestID}
+}
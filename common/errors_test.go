@@ -0,0 +1,63 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package common
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewErrorResponse(t *testing.T) {
+	tests := []struct {
+		name            string
+		err             error
+		expectedStatus  int
+		expectedCode    ErrorCode
+		expectedMessage string
+	}{
+		{
+			"endpoint error with code",
+			&EndpointError{Message: "The AIK certificate has not been provisioned", StatusCode: http.StatusNotFound, Code: ErrorCodeAikMissing},
+			http.StatusNotFound, ErrorCodeAikMissing, "The AIK certificate has not been provisioned",
+		},
+		{
+			"wrapped endpoint error",
+			errors.Wrap(&EndpointError{Message: "Invalid nonce", StatusCode: http.StatusBadRequest}, "Error creating quote"),
+			http.StatusBadRequest, ErrorCodeInvalidRequest, "Invalid nonce",
+		},
+		{
+			"endpoint error value",
+			EndpointError{Message: "TPM busy", StatusCode: http.StatusServiceUnavailable},
+			http.StatusServiceUnavailable, ErrorCodeTpmUnavailable, "TPM busy",
+		},
+		{
+			"request deadline",
+			errors.Wrap(context.DeadlineExceeded, "Error waiting for measure"),
+			http.StatusServiceUnavailable, ErrorCodeTimeout, "The request timed out",
+		},
+		{
+			"record not found",
+			errors.New("record not found"),
+			http.StatusNotFound, ErrorCodeNotFound, "record not found",
+		},
+		{
+			"internal error",
+			errors.New("open /opt/trustagent/var/system-info/platform-info: permission denied"),
+			http.StatusInternalServerError, ErrorCodeInternal, "Error processing request",
+		},
+	}
+
+	for _, test := range tests {
+		statusCode, errorResponse := NewErrorResponse(test.err, "1234")
+		assert.Equal(t, test.expectedStatus, statusCode, test.name)
+		assert.Equal(t, test.expectedCode, errorResponse.Code, test.name)
+		assert.Equal(t, test.expectedMessage, errorResponse.Message, test.name)
+		assert.Equal(t, "1234", errorResponse.RequestID, test.name)
+	}
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package common
+
+import (
+	"context"
+	"intel/isecl/go-trust-agent/v4/constants"
+	"intel/isecl/lib/tpmprovider/v4"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Names of the readiness checks (see GetReadiness)
+const (
+	HealthCheckTpm            = "tpm"
+	HealthCheckAik            = "aik"
+	HealthCheckAssetTagIndex  = "asset_tag_index"
+	HealthCheckPlatformInfo   = "platform_info"
+	HealthCheckMeasureLog     = "measure_log"
+	HealthCheckTLSCertificate = "tls_certificate"
+)
+
+const (
+	HealthStatusOK     = "ok"
+	HealthStatusFailed = "failed"
+)
+
+// HealthCheck is the result of a single readiness check.  The readiness endpoint is not
+// authenticated, so the errors of failed checks are logged and not returned.
+type HealthCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// HealthStatus is returned by GET /v2/health/ready (and the 'ready-request' NATS subject).
+// 'Status' is 'ok' when all of the checks passed.  'CheckTime' is the time the checks
+// were run (the results may be cached for up to constants.HealthCheckCacheTTL).
+type HealthStatus struct {
+	Status    string        `json:"status"`
+	CheckTime time.Time     `json:"check_time"`
+	Checks    []HealthCheck `json:"checks,omitempty"`
+}
+
+// AddCheck adds the result of a check ('err' is nil when the check passed).
+func (status *HealthStatus) AddCheck(name string, err error) {
+	check := HealthCheck{
+		Name:   name,
+		Status: HealthStatusOK,
+	}
+
+	if err != nil {
+		log.WithError(err).Warnf("common/health:AddCheck() The '%s' readiness check failed", name)
+		check.Status = HealthStatusFailed
+		status.Status = HealthStatusFailed
+	} else if status.Status == "" {
+		status.Status = HealthStatusOK
+	}
+
+	status.Checks = append(status.Checks, check)
+}
+
+// IsReady returns true when all of the checks passed.
+func (status *HealthStatus) IsReady() bool {
+	return status.Status == HealthStatusOK
+}
+
+// GetReadiness checks that the Trust-Agent has been provisioned and can attest the host:
+// the TPM can be opened, the AIK certificate exists and the asset tag index, platform-info
+// and the measure-log are present.  The results are cached for a short period since the
+// readiness endpoint is not authenticated and the TPM check uses the TpmBroker.  Concurrent
+// requests share a single check (see singleFlight) and the TPM check is limited to
+// constants.HealthCheckTpmTimeout, so that probes are answered before they time out.
+func (handler *requestHandlerImpl) GetReadiness(ctx context.Context) *HealthStatus {
+	log := GetLogger(ctx)
+
+	handler.readinessMutex.Lock()
+	readiness := handler.readiness
+	handler.readinessMutex.Unlock()
+
+	if readiness != nil && time.Since(readiness.CheckTime) < constants.HealthCheckCacheTTL {
+		log.Debugf("common/health:GetReadiness() Returning readiness checked at %s", readiness.CheckTime)
+		return copyHealthStatus(readiness)
+	}
+
+	// the check is shared with concurrent requests, so it does not use the request's context
+	checkCtx := WithRequestID(context.Background(), GetRequestID(ctx))
+	result, err := handler.readinessCheck.do(ctx, func() (interface{}, error) {
+		status := handler.checkReadiness(checkCtx)
+
+		handler.readinessMutex.Lock()
+		handler.readiness = status
+		handler.readinessMutex.Unlock()
+		return status, nil
+	})
+	if err != nil {
+		// the request's deadline expired waiting for the shared check
+		status := HealthStatus{
+			CheckTime: time.Now(),
+		}
+		status.AddCheck(HealthCheckTpm, err)
+		return &status
+	}
+
+	return copyHealthStatus(result.(*HealthStatus))
+}
+
+func (handler *requestHandlerImpl) checkReadiness(ctx context.Context) *HealthStatus {
+	log := GetLogger(ctx)
+
+	status := HealthStatus{
+		CheckTime: time.Now(),
+	}
+
+	tpmCtx, cancel := context.WithTimeout(ctx, constants.HealthCheckTpmTimeout)
+	defer cancel()
+
+	var tagIndexErr error
+	err := handler.tpmBroker.Execute(tpmCtx, TpmOperationHealthCheck, func(tpm tpmprovider.TpmProvider) error {
+		nvExists, err := tpm.NvIndexExists(tpmprovider.NV_IDX_ASSET_TAG)
+		if err != nil {
+			tagIndexErr = errors.Wrap(err, "Error checking if the asset tag index exists")
+		} else if !nvExists {
+			tagIndexErr = errors.New("The asset tag index does not exist")
+		}
+		return nil
+	})
+	if err != nil {
+		tagIndexErr = errors.New("The asset tag index could not be checked")
+	}
+
+	status.AddCheck(HealthCheckTpm, err)
+
+	_, aikErr := readAikExpiry()
+	status.AddCheck(HealthCheckAik, aikErr)
+	status.AddCheck(HealthCheckAssetTagIndex, tagIndexErr)
+	status.AddCheck(HealthCheckPlatformInfo, checkFileExists(constants.PlatformInfoFilePath))
+	status.AddCheck(HealthCheckMeasureLog, checkFileExists(constants.MeasureLogFilePath))
+
+	if !status.IsReady() {
+		log.Warnf("common/health:checkReadiness() The Trust-Agent is not ready: %+v", status.Checks)
+	}
+
+	return &status
+}
+
+// copyHealthStatus returns a copy of a (cached) status so that the caller can add
+// checks to it.
+func copyHealthStatus(status *HealthStatus) *HealthStatus {
+	statusCopy := *status
+	statusCopy.Checks = append([]HealthCheck(nil), status.Checks...)
+	return &statusCopy
+}
+
+func checkFileExists(filePath string) error {
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return errors.Errorf("%s does not exist", filePath)
+	}
+
+	if fileInfo.IsDir() || fileInfo.Size() == 0 {
+		return errors.Errorf("%s is not a valid file", filePath)
+	}
+
+	return nil
+}
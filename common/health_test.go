@@ -0,0 +1,94 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"intel/isecl/lib/tpmprovider/v4"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetReadiness(t *testing.T) {
+	mockedTpmProvider := new(tpmprovider.MockedTpmProvider)
+	mockedTpmProvider.On("Close").Return(nil)
+	mockedTpmProvider.On("NvIndexExists", uint32(tpmprovider.NV_IDX_ASSET_TAG)).Return(false, nil)
+
+	handler := requestHandlerImpl{
+		tpmBroker: NewTpmBroker(tpmprovider.MockedTpmFactory{TpmProvider: mockedTpmProvider}, 1, time.Second),
+	}
+	defer handler.tpmBroker.Close()
+
+	healthStatus := handler.GetReadiness(context.Background())
+	assert.False(t, healthStatus.IsReady())
+
+	checks := make(map[string]HealthCheck)
+	for _, check := range healthStatus.Checks {
+		checks[check.Name] = check
+	}
+
+	// the tpm can be opened but the asset tag index (and the files provisioned by setup)
+	// do not exist
+	assert.Equal(t, HealthStatusOK, checks[HealthCheckTpm].Status)
+	assert.Equal(t, HealthStatusFailed, checks[HealthCheckAssetTagIndex].Status)
+	assert.Equal(t, HealthStatusFailed, checks[HealthCheckAik].Status)
+	assert.Equal(t, HealthStatusFailed, checks[HealthCheckPlatformInfo].Status)
+
+	// the cached results are not modified by the caller's checks
+	healthStatus.AddCheck(HealthCheckTLSCertificate, nil)
+	cachedStatus := handler.GetReadiness(context.Background())
+	assert.Equal(t, healthStatus.CheckTime, cachedStatus.CheckTime)
+	assert.Len(t, cachedStatus.Checks, len(healthStatus.Checks)-1)
+	assert.Equal(t, uint64(1), handler.tpmBroker.Metrics().Completed)
+}
+
+// TestGetReadinessConcurrent makes sure that concurrent requests share a single TPM check.
+func TestGetReadinessConcurrent(t *testing.T) {
+	mockedTpmProvider := new(tpmprovider.MockedTpmProvider)
+	mockedTpmProvider.On("Close").Return(nil)
+	mockedTpmProvider.On("NvIndexExists", uint32(tpmprovider.NV_IDX_ASSET_TAG)).Return(true, nil).After(100 * time.Millisecond)
+
+	handler := requestHandlerImpl{
+		tpmBroker: NewTpmBroker(tpmprovider.MockedTpmFactory{TpmProvider: mockedTpmProvider}, 16, time.Second),
+	}
+	defer handler.tpmBroker.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			healthStatus := handler.GetReadiness(context.Background())
+			assert.Len(t, healthStatus.Checks, 5)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, uint64(1), handler.tpmBroker.Metrics().Completed)
+}
+
+func TestHealthStatusAddCheck(t *testing.T) {
+	var healthStatus HealthStatus
+	healthStatus.AddCheck(HealthCheckTpm, nil)
+	assert.True(t, healthStatus.IsReady())
+
+	healthStatus.AddCheck(HealthCheckMeasureLog, checkFileExists("/does/not/exist/measure-log.json"))
+	assert.False(t, healthStatus.IsReady())
+
+	healthStatus.AddCheck(HealthCheckAik, nil)
+	assert.False(t, healthStatus.IsReady())
+
+	// the reasons of failed checks are not returned by the unauthenticated endpoint
+	healthJSON, err := json.Marshal(&healthStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, strings.Contains(string(healthJSON), "measure-log.json"), string(healthJSON))
+}
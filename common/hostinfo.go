@@ -5,6 +5,7 @@
 package common
 
 import (
+	"context"
 	taModel "github.com/intel-secl/intel-secl/v4/pkg/model/ta"
 	"github.com/pkg/errors"
 	"intel/isecl/go-trust-agent/v4/constants"
@@ -14,7 +15,7 @@ import (
 // GetHostInfo Assuming that the /opt/trustagent/var/system-info/platform-info file has been created
 // during startup, this function reads the contents of the json file and returns the corresponding
 // HostInfo structure.
-func (handler *requestHandlerImpl) GetHostInfo() (*taModel.HostInfo, error) {
+func (handler *requestHandlerImpl) GetHostInfo(ctx context.Context) (*taModel.HostInfo, error) {
 	var hostInfo *taModel.HostInfo
 
 	hostInfo, err := util.ReadHostInfo()
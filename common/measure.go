@@ -5,6 +5,7 @@
 package common
 
 import (
+	"context"
 	"encoding/xml"
 	"github.com/intel-secl/intel-secl/v4/pkg/lib/common/log/message"
 	taModel "github.com/intel-secl/intel-secl/v4/pkg/model/ta"
@@ -17,12 +18,14 @@ import (
 
 const WML_LOG_FILE = constants.LogDir + "wml.log"
 
-func (handler *requestHandlerImpl) GetApplicationMeasurement(manifest *taModel.Manifest) (*taModel.Measurement, error) {
+func (handler *requestHandlerImpl) GetApplicationMeasurement(ctx context.Context, manifest *taModel.Manifest) (*taModel.Measurement, error) {
+	log := GetLogger(ctx)
+	secLog := GetSecurityLogger(ctx)
 
 	manifestXml, err := xml.Marshal(manifest)
 	if err != nil {
 		secLog.Errorf("%s common/measure:GetApplicationMeasurement()  Failed to marshal manifest %s", message.InvalidInputBadParam, err.Error())
-		return nil, &EndpointError{Message: "Error: Failed to marshal manifest", StatusCode: http.StatusBadRequest}
+		return nil, &EndpointError{Message: "Error: Failed to marshal manifest", StatusCode: http.StatusBadRequest, Code: ErrorCodeInvalidManifest}
 	}
 
 	// this should probably be done in wml --> if the wml log file is not yet created,
@@ -48,27 +51,31 @@ func (handler *requestHandlerImpl) GetApplicationMeasurement(manifest *taModel.M
 
 	// call /opt/tbootxml/bin/measure and return the xml from stdout
 	// 'measure <manifestxml> /'
-	cmd := exec.Command(constants.TBootXmMeasurePath, string(manifestXml), "/")
+	// (the process is killed if the request is cancelled or its deadline expires)
+	cmd := exec.CommandContext(ctx, constants.TBootXmMeasurePath, string(manifestXml), "/")
 	cmd.Env = append(os.Environ(), "WML_LOG_FILE="+WML_LOG_FILE)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		log.WithError(err).Errorf("common/measure:GetApplicationMeasurement() %s - Error getting measure output", message.AppRuntimeErr)
-		return nil, &EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError}
+		return nil, &EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError, Code: ErrorCodeMeasurementFailed}
 	}
 
 	err = cmd.Start()
 	if err != nil {
 		log.WithError(err).Errorf("common/measure:GetApplicationMeasurement() %s - Failed to run: %s", message.AppRuntimeErr, constants.TBootXmMeasurePath)
-		return nil, &EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError}
+		return nil, &EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError, Code: ErrorCodeMeasurementFailed}
 
 	}
 
 	measureBytes, _ := ioutil.ReadAll(stdout)
 	err = cmd.Wait()
-	if err != nil {
+	if ctx.Err() != nil {
+		log.WithError(ctx.Err()).Errorf("common/measure:GetApplicationMeasurement() %s - %s was stopped", message.AppRuntimeErr, constants.TBootXmMeasurePath)
+		return nil, &EndpointError{Message: "The measurement did not complete before the request's deadline", StatusCode: http.StatusServiceUnavailable, Code: ErrorCodeTimeout}
+	} else if err != nil {
 		log.WithError(err).Errorf("common/measure:GetApplicationMeasurement() %s - %s returned '%s'", message.AppRuntimeErr, constants.TBootXmMeasurePath, string(measureBytes))
-		return nil, &EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError}
+		return nil, &EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError, Code: ErrorCodeMeasurementFailed}
 	}
 
 	var measurement taModel.Measurement
@@ -76,7 +83,7 @@ func (handler *requestHandlerImpl) GetApplicationMeasurement(manifest *taModel.M
 	err = xml.Unmarshal(measureBytes, &measurement)
 	if err != nil {
 		secLog.WithError(err).Errorf("common/measure:GetApplicationMeasurement() %s - Invalid measurement xml : %s", message.AppRuntimeErr, string(measureBytes))
-		return nil, &EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError}
+		return nil, &EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError, Code: ErrorCodeMeasurementFailed}
 	}
 
 	return &measurement, nil
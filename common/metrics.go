@@ -0,0 +1,102 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package common
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"intel/isecl/go-trust-agent/v4/constants"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Names of TpmBroker operations (the 'operation' label of the tpm metrics)
+const (
+	TpmOperationQuote              = "quote"
+	TpmOperationReadPcrs           = "read_pcrs"
+	TpmOperationDeployAssetTag     = "deploy_asset_tag"
+	TpmOperationReadAssetTag       = "read_asset_tag"
+	TpmOperationClearAssetTag      = "clear_asset_tag"
+	TpmOperationReadEkCertificates = "read_ek_certificates"
+	TpmOperationHealthCheck        = "health_check"
+)
+
+var (
+	tpmOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "tpm_operation_duration_seconds",
+		Help:      "The time spent running tpm operations (excluding the time waiting in the queue).",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"operation"})
+
+	tpmOperationErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "tpm_operation_errors_total",
+		Help:      "The number of tpm operations that failed.",
+	}, []string{"operation"})
+
+	quotesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "quotes_total",
+		Help:      "The number of quotes returned by the Trust-Agent by pcr bank.",
+	}, []string{"pcr_bank"})
+
+	eventLogSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "event_log_size_bytes",
+		Help:      "The size of the event log (measure-log.json) included in the last quote.",
+	})
+)
+
+// MetricsCollectors returns the prometheus collectors of the RequestHandler's metrics
+// (tpm operations, quotes, the event log and the AIK's expiry) that are registered by
+// the web/outbound services.
+func MetricsCollectors() []prometheus.Collector {
+	aikExpiry := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   constants.MetricsNamespace,
+		Name:        constants.MetricsCertificateExpiry,
+		Help:        "The time (unix seconds) when the certificate expires.",
+		ConstLabels: prometheus.Labels{"certificate": "aik"},
+	}, func() float64 {
+		notAfter, err := readAikExpiry()
+		if err != nil {
+			log.WithError(err).Debug("common/metrics:MetricsCollectors() Could not read the AIK's expiry")
+			return 0
+		}
+		return float64(notAfter.Unix())
+	})
+
+	return []prometheus.Collector{tpmOperationDuration, tpmOperationErrors, quotesTotal, eventLogSize, aikExpiry}
+}
+
+// observeTpmOperation records the duration (and failure) of a TpmBroker operation.
+func observeTpmOperation(operationName string, start time.Time, err error) {
+	tpmOperationDuration.WithLabelValues(operationName).Observe(time.Since(start).Seconds())
+	if err != nil {
+		tpmOperationErrors.WithLabelValues(operationName).Inc()
+	}
+}
+
+func readAikExpiry() (time.Time, error) {
+	aikPem, err := ioutil.ReadFile(constants.AikCert)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	block, _ := pem.Decode(aikPem)
+	if block == nil {
+		return time.Time{}, errors.Errorf("common/metrics:readAikExpiry() Error decoding %s", constants.AikCert)
+	}
+
+	aik, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "common/metrics:readAikExpiry() Error parsing %s", constants.AikCert)
+	}
+
+	return aik.NotAfter, nil
+}
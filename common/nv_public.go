@@ -0,0 +1,182 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"intel/isecl/go-trust-agent/v4/constants"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// The TpmProvider does not read the public area of nv indexes, so TPM2_NV_ReadPublic (which
+// does not require an authorization) is sent to the TPM's resource manager directly.  See TPM
+// 2.0 Part 3 (Commands), 31.6 and Part 2 (Structures), 13.5 for TPMS_NV_PUBLIC.
+const (
+	tpmStNoSessions   = 0x8001     // TPM_ST_NO_SESSIONS
+	tpmCcNvReadPublic = 0x00000169 // TPM_CC_NV_ReadPublic
+	tpmRcSuccess      = 0x00000000 // TPM_RC_SUCCESS
+
+	nvReadPublicCommandSize = 14
+	maxTpmResponseSize      = 4096
+)
+
+// nvPublic is the public area (TPMS_NV_PUBLIC) of an nv index.
+type nvPublic struct {
+	nvIndex    uint32
+	nameAlg    uint16
+	attributes uint32 // TPMA_NV
+	authPolicy []byte
+	dataSize   uint16
+}
+
+// TPMA_NV attribute bits (see TPM 2.0 Part 2, 13.4).  Bits 4-7 (TPM_NT) are the index
+// type and are reported separately.
+var nvAttributeNames = []struct {
+	mask uint32
+	name string
+}{
+	{0x00000001, "PPWRITE"},
+	{0x00000002, "OWNERWRITE"},
+	{0x00000004, "AUTHWRITE"},
+	{0x00000008, "POLICYWRITE"},
+	{0x00000400, "POLICY_DELETE"},
+	{0x00000800, "WRITELOCKED"},
+	{0x00001000, "WRITEALL"},
+	{0x00002000, "WRITEDEFINE"},
+	{0x00004000, "WRITE_STCLEAR"},
+	{0x00008000, "GLOBALLOCK"},
+	{0x00010000, "PPREAD"},
+	{0x00020000, "OWNERREAD"},
+	{0x00040000, "AUTHREAD"},
+	{0x00080000, "POLICYREAD"},
+	{0x02000000, "NO_DA"},
+	{0x04000000, "ORDERLY"},
+	{0x08000000, "CLEAR_STCLEAR"},
+	{0x10000000, "READLOCKED"},
+	{0x20000000, "WRITTEN"},
+	{0x40000000, "PLATFORMCREATE"},
+	{0x80000000, "READ_STCLEAR"},
+}
+
+var nvIndexTypeNames = map[uint32]string{
+	0x0: "ORDINARY",
+	0x1: "COUNTER",
+	0x2: "BITS",
+	0x4: "EXTEND",
+	0x8: "PIN_FAIL",
+	0x9: "PIN_PASS",
+}
+
+// getNvAttributeNames converts TPMA_NV attributes to a list of names (ex. "TYPE_ORDINARY",
+// "AUTHWRITE", "AUTHREAD").
+func getNvAttributeNames(attributes uint32) []string {
+	names := []string{}
+
+	nvType, ok := nvIndexTypeNames[(attributes>>4)&0xf]
+	if !ok {
+		nvType = "UNKNOWN"
+	}
+	names = append(names, "TYPE_"+nvType)
+
+	for _, attribute := range nvAttributeNames {
+		if attributes&attribute.mask != 0 {
+			names = append(names, attribute.name)
+		}
+	}
+
+	return names
+}
+
+// readNvPublic reads the public area of 'nvIndex' from constants.TpmResourceManagerFilePath
+// (replaced in unit tests).
+var readNvPublic = func(nvIndex uint32) (*nvPublic, error) {
+	device, err := os.OpenFile(constants.TpmResourceManagerFilePath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "common/nv_public:readNvPublic() Error opening %s", constants.TpmResourceManagerFilePath)
+	}
+	defer device.Close()
+
+	return nvReadPublic(device, nvIndex)
+}
+
+// nvReadPublic sends TPM2_NV_ReadPublic for 'nvIndex' to 'device' and parses the response.
+func nvReadPublic(device io.ReadWriter, nvIndex uint32) (*nvPublic, error) {
+	command := new(bytes.Buffer)
+	err := binary.Write(command, binary.BigEndian, struct {
+		Tag         uint16
+		Size        uint32
+		CommandCode uint32
+		NvIndex     uint32
+	}{tpmStNoSessions, nvReadPublicCommandSize, tpmCcNvReadPublic, nvIndex})
+	if err != nil {
+		return nil, errors.Wrap(err, "common/nv_public:nvReadPublic() Error creating the TPM2_NV_ReadPublic command")
+	}
+
+	_, err = device.Write(command.Bytes())
+	if err != nil {
+		return nil, errors.Wrap(err, "common/nv_public:nvReadPublic() Error sending TPM2_NV_ReadPublic")
+	}
+
+	response := make([]byte, maxTpmResponseSize)
+	n, err := device.Read(response)
+	if err != nil {
+		return nil, errors.Wrap(err, "common/nv_public:nvReadPublic() Error reading the TPM2_NV_ReadPublic response")
+	}
+
+	reader := bytes.NewReader(response[:n])
+
+	var header struct {
+		Tag          uint16
+		Size         uint32
+		ResponseCode uint32
+	}
+	err = binary.Read(reader, binary.BigEndian, &header)
+	if err != nil {
+		return nil, errors.Wrap(err, "common/nv_public:nvReadPublic() Invalid TPM2_NV_ReadPublic response")
+	}
+
+	if header.ResponseCode != tpmRcSuccess {
+		return nil, errors.Errorf("common/nv_public:nvReadPublic() TPM2_NV_ReadPublic failed for nv index 0x%x (response code 0x%x)", nvIndex, header.ResponseCode)
+	}
+
+	if int(header.Size) != n {
+		return nil, errors.Errorf("common/nv_public:nvReadPublic() The TPM2_NV_ReadPublic response has %d bytes, expected %d", n, header.Size)
+	}
+
+	// TPM2B_NV_PUBLIC (the TPM2B_NAME that follows is not used)
+	publicArea, err := readTpm2b(reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "common/nv_public:nvReadPublic() Error reading TPM2B_NV_PUBLIC")
+	}
+
+	publicReader := bytes.NewReader(publicArea)
+	public := nvPublic{}
+	for _, field := range []interface{}{&public.nvIndex, &public.nameAlg, &public.attributes} {
+		err = binary.Read(publicReader, binary.BigEndian, field)
+		if err != nil {
+			return nil, errors.Wrap(err, "common/nv_public:nvReadPublic() Error reading TPMS_NV_PUBLIC")
+		}
+	}
+
+	public.authPolicy, err = readTpm2b(publicReader)
+	if err != nil {
+		return nil, errors.Wrap(err, "common/nv_public:nvReadPublic() Error reading the nv index's auth policy")
+	}
+
+	err = binary.Read(publicReader, binary.BigEndian, &public.dataSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "common/nv_public:nvReadPublic() Error reading the nv index's data size")
+	}
+
+	if public.nvIndex != nvIndex {
+		return nil, errors.Errorf("common/nv_public:nvReadPublic() The TPM returned the public area of nv index 0x%x, expected 0x%x", public.nvIndex, nvIndex)
+	}
+
+	return &public, nil
+}
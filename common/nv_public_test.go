@@ -0,0 +1,118 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testTpmDevice records the command written to it and returns 'response' when read.
+type testTpmDevice struct {
+	command  bytes.Buffer
+	response []byte
+}
+
+func (device *testTpmDevice) Write(p []byte) (int, error) {
+	return device.command.Write(p)
+}
+
+func (device *testTpmDevice) Read(p []byte) (int, error) {
+	return copy(p, device.response), nil
+}
+
+// useTestNvPublic makes readNvPublic return 'public' and 'err' until the returned function
+// is called.
+func useTestNvPublic(public *nvPublic, err error) func() {
+	defaultReadNvPublic := readNvPublic
+	readNvPublic = func(nvIndex uint32) (*nvPublic, error) {
+		return public, err
+	}
+
+	return func() {
+		readNvPublic = defaultReadNvPublic
+	}
+}
+
+// newNvReadPublicResponse builds a TPM2_NV_ReadPublic response for an sha256 index.
+func newNvReadPublicResponse(nvIndex uint32, attributes uint32, authPolicy []byte, dataSize uint16) []byte {
+	publicArea := new(bytes.Buffer)
+	binary.Write(publicArea, binary.BigEndian, nvIndex)
+	binary.Write(publicArea, binary.BigEndian, uint16(0x000b))
+	binary.Write(publicArea, binary.BigEndian, attributes)
+	binary.Write(publicArea, binary.BigEndian, uint16(len(authPolicy)))
+	publicArea.Write(authPolicy)
+	binary.Write(publicArea, binary.BigEndian, dataSize)
+
+	name := []byte{0x00, 0x0b, 0x01, 0x02, 0x03}
+
+	parameters := new(bytes.Buffer)
+	binary.Write(parameters, binary.BigEndian, uint16(publicArea.Len()))
+	parameters.Write(publicArea.Bytes())
+	binary.Write(parameters, binary.BigEndian, uint16(len(name)))
+	parameters.Write(name)
+
+	response := new(bytes.Buffer)
+	binary.Write(response, binary.BigEndian, uint16(tpmStNoSessions))
+	binary.Write(response, binary.BigEndian, uint32(10+parameters.Len()))
+	binary.Write(response, binary.BigEndian, uint32(tpmRcSuccess))
+	response.Write(parameters.Bytes())
+
+	return response.Bytes()
+}
+
+func TestNvReadPublic(t *testing.T) {
+	assert := assert.New(t)
+
+	authPolicy := bytes.Repeat([]byte{0x5a}, 32)
+	device := &testTpmDevice{response: newNvReadPublicResponse(0x1c10110, 0x2004000c, authPolicy, 67)}
+
+	public, err := nvReadPublic(device, 0x1c10110)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal([]byte{0x80, 0x01, 0x00, 0x00, 0x00, 0x0e, 0x00, 0x00, 0x01, 0x69, 0x01, 0xc1, 0x01, 0x10}, device.command.Bytes())
+	assert.Equal(uint32(0x1c10110), public.nvIndex)
+	assert.Equal(uint16(0x000b), public.nameAlg)
+	assert.Equal(uint32(0x2004000c), public.attributes)
+	assert.Equal(authPolicy, public.authPolicy)
+	assert.Equal(uint16(67), public.dataSize)
+}
+
+func TestNvReadPublicErrors(t *testing.T) {
+	valid := newNvReadPublicResponse(0x1c10110, 0x20040004, nil, 67)
+
+	handleError := []byte{0x80, 0x01, 0x00, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x01, 0x8b}
+
+	wrongSize := append([]byte{}, valid...)
+	wrongSize[5]++
+
+	otherIndex := newNvReadPublicResponse(0x1c00002, 0x20040004, nil, 67)
+
+	tests := map[string][]byte{
+		"empty":       {},
+		"tpm error":   handleError,
+		"wrong size":  wrongSize,
+		"truncated":   valid[:20],
+		"other index": otherIndex,
+	}
+
+	for name, response := range tests {
+		_, err := nvReadPublic(&testTpmDevice{response: response}, 0x1c10110)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestGetNvAttributeNames(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]string{"TYPE_ORDINARY", "AUTHWRITE", "AUTHREAD", "WRITTEN"}, getNvAttributeNames(0x20040004))
+	assert.Equal([]string{"TYPE_COUNTER", "OWNERWRITE", "OWNERREAD", "NO_DA"}, getNvAttributeNames(0x02020012))
+	assert.Equal([]string{"TYPE_UNKNOWN"}, getNvAttributeNames(0x000000f0))
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"intel/isecl/go-trust-agent/v4/constants"
+	"intel/isecl/lib/tpmprovider/v4"
+	"net/http"
+	"time"
+
+	taModel "github.com/intel-secl/intel-secl/v4/pkg/model/ta"
+	"github.com/pkg/errors"
+)
+
+// TpmPcr is the value of a single PCR (hex encoded)
+type TpmPcr struct {
+	Index int    `json:"index"`
+	Value string `json:"value"`
+}
+
+// TpmPcrBank contains the PCR values of an active PCR bank
+type TpmPcrBank struct {
+	Bank string   `json:"bank"`
+	Pcrs []TpmPcr `json:"pcrs"`
+}
+
+// TpmPcrs is returned by GET /v2/tpm/pcrs.  'ReadTime' is the time the values were
+// read from the TPM (the values may be cached for up to constants.PcrCacheTTL).
+type TpmPcrs struct {
+	ReadTime time.Time    `json:"read_time"`
+	PcrBanks []TpmPcrBank `json:"pcr_banks"`
+}
+
+// GetTpmPcrs returns the current PCR values of the active PCR banks.  The values are
+// cached for a short period so that frequent health probes don't require a TPM
+// quote for each request.  Concurrent requests share a single quote (see singleFlight)
+// and the cache is not locked while the TPM is used.
+func (handler *requestHandlerImpl) GetTpmPcrs(ctx context.Context) (*TpmPcrs, error) {
+	log := GetLogger(ctx)
+
+	handler.pcrCacheMutex.Lock()
+	pcrCache := handler.pcrCache
+	handler.pcrCacheMutex.Unlock()
+
+	if pcrCache != nil && time.Since(pcrCache.ReadTime) < constants.PcrCacheTTL {
+		log.Debugf("common/pcrs:GetTpmPcrs() Returning pcrs cached at %s", pcrCache.ReadTime)
+		return pcrCache, nil
+	}
+
+	// the quote is shared with concurrent requests, so it does not use the request's context
+	readCtx := WithRequestID(context.Background(), GetRequestID(ctx))
+	result, err := handler.pcrRead.do(ctx, func() (interface{}, error) {
+		var tpmPcrs *TpmPcrs
+		err := handler.tpmBroker.Execute(readCtx, TpmOperationReadPcrs, func(tpm tpmprovider.TpmProvider) error {
+			var err error
+			tpmPcrs, err = readTpmPcrs(tpm)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		handler.pcrCacheMutex.Lock()
+		handler.pcrCache = tpmPcrs
+		handler.pcrCacheMutex.Unlock()
+		return tpmPcrs, nil
+	})
+	if err == context.DeadlineExceeded {
+		return nil, &EndpointError{Message: "The request timed out waiting for the TPM", StatusCode: http.StatusServiceUnavailable, Code: ErrorCodeTimeout}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return result.(*TpmPcrs), nil
+}
+
+// readTpmPcrs reads PCRs 0-23 from the active PCR banks.  The tpmprovider does not
+// support reading PCRs directly, so the values are taken from a quote (signed by the
+// default AIK) that uses a random nonce.
+func readTpmPcrs(tpm tpmprovider.TpmProvider) (*TpmPcrs, error) {
+	log.Trace("common/pcrs:readTpmPcrs() Entering")
+	defer log.Trace("common/pcrs:readTpmPcrs() Leaving")
+
+	tpmQuoteRequest := taModel.TpmQuoteRequest{
+		Nonce: make([]byte, verificationNonceSize),
+	}
+
+	_, err := rand.Read(tpmQuoteRequest.Nonce)
+	if err != nil {
+		return nil, errors.Wrap(err, "common/pcrs:readTpmPcrs() Error generating nonce")
+	}
+
+	for _, pcrBank := range []constants.SHAAlgorithm{constants.SHA384, constants.SHA256, constants.SHA1} {
+		isActive, err := tpm.IsPcrBankActive(string(pcrBank))
+		if err != nil {
+			log.WithError(err).Debugf("common/pcrs:readTpmPcrs() Error while determining PCR bank %s state", pcrBank)
+		}
+
+		if isActive {
+			tpmQuoteRequest.PcrBanks = append(tpmQuoteRequest.PcrBanks, string(pcrBank))
+		}
+	}
+
+	if len(tpmQuoteRequest.PcrBanks) == 0 {
+		return nil, errors.New("common/pcrs:readTpmPcrs() The TPM does not have any active PCR banks")
+	}
+
+	for i := 0; i < 24; i++ {
+		tpmQuoteRequest.Pcrs = append(tpmQuoteRequest.Pcrs, i)
+	}
+
+	quoteBase64, err := getQuote(tpm, &tpmQuoteRequest, tpmQuoteRequest.Nonce)
+	if err != nil {
+		return nil, errors.Wrap(err, "common/pcrs:readTpmPcrs() Error while retrieving tpm quote")
+	}
+
+	quoteBytes, err := base64.StdEncoding.DecodeString(quoteBase64)
+	if err != nil {
+		return nil, errors.Wrap(err, "common/pcrs:readTpmPcrs() Error decoding tpm quote")
+	}
+
+	quote, err := parseTpmQuote(quoteBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "common/pcrs:readTpmPcrs() Error parsing tpm quote")
+	}
+
+	tpmPcrs := TpmPcrs{
+		ReadTime: time.Now(),
+	}
+
+	for _, pcrValue := range quote.pcrValues {
+		if len(tpmPcrs.PcrBanks) == 0 || tpmPcrs.PcrBanks[len(tpmPcrs.PcrBanks)-1].Bank != pcrValue.bank {
+			tpmPcrs.PcrBanks = append(tpmPcrs.PcrBanks, TpmPcrBank{Bank: pcrValue.bank})
+		}
+
+		pcrBank := &tpmPcrs.PcrBanks[len(tpmPcrs.PcrBanks)-1]
+		pcrBank.Pcrs = append(pcrBank.Pcrs, TpmPcr{
+			Index: pcrValue.index,
+			Value: hex.EncodeToString(pcrValue.value),
+		})
+	}
+
+	return &tpmPcrs, nil
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"intel/isecl/go-trust-agent/v4/constants"
+	"intel/isecl/lib/tpmprovider/v4"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// newPcrsTestHandler returns a handler whose tpm has an active sha256 bank and returns a
+// quote of pcr 0 (after 'quoteDelay').
+func newPcrsTestHandler(t *testing.T, quoteDelay time.Duration) *requestHandlerImpl {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quote := createTestQuote(t, key, make([]byte, verificationNonceSize), map[int][]byte{0: bytes.Repeat([]byte{0x11}, sha256.Size)})
+
+	mockedTpmProvider := new(tpmprovider.MockedTpmProvider)
+	mockedTpmProvider.On("Close").Return(nil)
+	mockedTpmProvider.On("IsPcrBankActive", string(constants.SHA256)).Return(true, nil)
+	mockedTpmProvider.On("IsPcrBankActive", mock.Anything).Return(false, nil)
+	mockedTpmProvider.On("GetTpmQuote", mock.Anything, mock.Anything, mock.Anything).Return(quote, nil).After(quoteDelay)
+
+	return &requestHandlerImpl{
+		tpmBroker: NewTpmBroker(tpmprovider.MockedTpmFactory{TpmProvider: mockedTpmProvider}, 16, time.Second),
+	}
+}
+
+func TestGetTpmPcrsCache(t *testing.T) {
+	assert := assert.New(t)
+	handler := newPcrsTestHandler(t, 0)
+	defer handler.tpmBroker.Close()
+
+	tpmPcrs, err := handler.GetTpmPcrs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(tpmPcrs.PcrBanks, 1)
+	assert.Equal(string(constants.SHA256), tpmPcrs.PcrBanks[0].Bank)
+	assert.Equal(0, tpmPcrs.PcrBanks[0].Pcrs[0].Index)
+	assert.Equal(hex.EncodeToString(bytes.Repeat([]byte{0x11}, sha256.Size)), tpmPcrs.PcrBanks[0].Pcrs[0].Value)
+
+	// the cached values are returned within constants.PcrCacheTTL
+	cachedPcrs, err := handler.GetTpmPcrs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(tpmPcrs, cachedPcrs)
+	assert.Equal(uint64(1), handler.tpmBroker.Metrics().Completed)
+
+	// the pcrs are read again when the cache expired
+	handler.pcrCache.ReadTime = time.Now().Add(-constants.PcrCacheTTL)
+	_, err = handler.GetTpmPcrs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(uint64(2), handler.tpmBroker.Metrics().Completed)
+}
+
+// TestGetTpmPcrsConcurrent makes sure that concurrent requests share a single quote.
+func TestGetTpmPcrsConcurrent(t *testing.T) {
+	handler := newPcrsTestHandler(t, 100*time.Millisecond)
+	defer handler.tpmBroker.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tpmPcrs, err := handler.GetTpmPcrs(context.Background())
+			assert.NoError(t, err)
+			assert.NotNil(t, tpmPcrs)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, uint64(1), handler.tpmBroker.Metrics().Completed)
+}
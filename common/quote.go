@@ -5,14 +5,18 @@
 package common
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"intel/isecl/go-trust-agent/v4/config"
 	"intel/isecl/go-trust-agent/v4/constants"
+	"intel/isecl/go-trust-agent/v4/util"
 	"intel/isecl/lib/tpmprovider/v4"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
 	"time"
@@ -22,26 +26,48 @@ import (
 	"github.com/pkg/errors"
 )
 
-func (handler *requestHandlerImpl) GetTpmQuote(quoteRequest *taModel.TpmQuoteRequest) (*taModel.TpmQuoteResponse, error) {
+// TpmClockInfo corresponds to the TPMS_CLOCK_INFO from the quote's TPMS_ATTEST structure.
+// Verifiers can use 'ResetCount' to detect reboots between reports.
+type TpmClockInfo struct {
+	Clock        uint64 `xml:"clock"` // milliseconds that the TPM has been powered
+	ResetCount   uint32 `xml:"resetCount"`
+	RestartCount uint32 `xml:"restartCount"`
+	Safe         bool   `xml:"safe"`
+}
 
-	tpmFactory, err := tpmprovider.NewTpmFactory()
-	if err != nil {
-		return nil, err
+// TpmQuoteResponse extends taModel.TpmQuoteResponse with the clock information and
+// firmware version (hex encoded) parsed from the quote, so that verifiers do not need
+// to parse the binary quote to detect reboots or TPM firmware changes.
+type TpmQuoteResponse struct {
+	taModel.TpmQuoteResponse
+	ClockInfo       *TpmClockInfo `xml:"clockInfo,omitempty"`
+	FirmwareVersion string        `xml:"firmwareVersion,omitempty"`
+}
+
+func (handler *requestHandlerImpl) GetTpmQuote(ctx context.Context, quoteRequest *taModel.TpmQuoteRequest) (*TpmQuoteResponse, error) {
+
+	if quoteRequest == nil {
+		return nil, errors.New("common/quote:getTpmQuote() - TPM quote request cannot be nil")
 	}
 
-	tpm, err := tpmFactory.NewTpmProvider()
+	var tpmQuoteResponse *TpmQuoteResponse
+	err := handler.tpmBroker.Execute(ctx, TpmOperationQuote, func(tpm tpmprovider.TpmProvider) error {
+		var err error
+		tpmQuoteResponse, err = CreateTpmQuoteResponse(handler.cfg, tpm, quoteRequest)
+		return err
+	})
 	if err != nil {
-		return nil, errors.Wrapf(err, "common/quote:getTpmQuote() %s - Error creating tpm provider", message.AppRuntimeErr)
+		return nil, err
 	}
-	defer tpm.Close()
 
-	if quoteRequest == nil {
-		return nil, errors.New("common/quote:getTpmQuote() - TPM quote request cannot be nil")
+	for _, pcrBank := range tpmQuoteResponse.SelectedPcrBanks.SelectedPcrBanks {
+		quotesTotal.WithLabelValues(pcrBank).Inc()
 	}
-	return CreateTpmQuoteResponse(handler.cfg, tpm, quoteRequest)
+
+	return tpmQuoteResponse, nil
 }
 
-func CreateTpmQuoteResponse(cfg *config.TrustAgentConfiguration, tpm tpmprovider.TpmProvider, tpmQuoteRequest *taModel.TpmQuoteRequest) (*taModel.TpmQuoteResponse, error) {
+func CreateTpmQuoteResponse(cfg *config.TrustAgentConfiguration, tpm tpmprovider.TpmProvider, tpmQuoteRequest *taModel.TpmQuoteRequest) (*TpmQuoteResponse, error) {
 
 	var err error
 
@@ -49,7 +75,7 @@ func CreateTpmQuoteResponse(cfg *config.TrustAgentConfiguration, tpm tpmprovider
 
 	if len(tpmQuoteRequest.Nonce) == 0 {
 		secLog.Errorf("common/quote:CreateTpmQuoteResponse() %s - The TpmQuoteRequest does not contain a nonce", message.InvalidInputProtocolViolation)
-		return nil, errors.New("The TpmQuoteRequest does not contain a nonce")
+		return nil, &EndpointError{Message: "The quote request does not contain a nonce", StatusCode: http.StatusBadRequest}
 	}
 
 	// ISECL-12121: strip inactive PCR Banks from the request
@@ -84,6 +110,8 @@ func CreateTpmQuoteResponse(cfg *config.TrustAgentConfiguration, tpm tpmprovider
 //
 // Also, HVS takes into account the asset tag in the nonce -- it takes the ip hashed nonce
 // and 'extends' it with value of asset tag (i.e. when tags have been set on the trust agent).
+// 'assetTag' is the base64 encoded tag digest (without the tag index header/padding), so
+// the nonce is extended with the SHA256, SHA384 or SHA512 digest written by HVS.
 func getNonce(tpmQuoteRequest *taModel.TpmQuoteRequest, assetTag string) ([]byte, error) {
 	log.Trace("common/quote:getNonce() Entering")
 	defer log.Trace("common/quote:getNonce() Leaving")
@@ -128,7 +156,7 @@ func readAikAsBase64() (string, error) {
 	defer log.Trace("common/quote:readAikAsBase64() Leaving")
 
 	if _, err := os.Stat(constants.AikCert); os.IsNotExist(err) {
-		return "", err
+		return "", &EndpointError{Message: "The AIK certificate has not been provisioned", StatusCode: http.StatusNotFound, Code: ErrorCodeAikMissing}
 	}
 
 	aikBytes, err := ioutil.ReadFile(constants.AikCert)
@@ -145,6 +173,7 @@ func readEventLog() (string, error) {
 
 	if _, err := os.Stat(constants.MeasureLogFilePath); os.IsNotExist(err) {
 		log.Debugf("esource/quote:readEventLog() Event log file '%s' was not present", constants.MeasureLogFilePath)
+		eventLogSize.Set(0)
 		return "", nil // If the file does not exist, do not include in the quote
 	}
 
@@ -159,6 +188,7 @@ func readEventLog() (string, error) {
 		return "", errors.Wrap(err, "common/quote:readEventLog() Error while unmarshalling event log")
 	}
 
+	eventLogSize.Set(float64(len(eventLogBytes)))
 	return string(eventLogBytes), nil
 }
 
@@ -173,6 +203,23 @@ func getQuote(tpm tpmprovider.TpmProvider, tpmQuoteRequest *taModel.TpmQuoteRequ
 	return base64.StdEncoding.EncodeToString(quoteBytes), nil
 }
 
+func addClockInfo(tpmQuoteResponse *TpmQuoteResponse) error {
+	quoteBytes, err := base64.StdEncoding.DecodeString(tpmQuoteResponse.Quote)
+	if err != nil {
+		return err
+	}
+
+	quote, err := parseTpmQuote(quoteBytes)
+	if err != nil {
+		return err
+	}
+
+	tpmQuoteResponse.ClockInfo = &quote.clockInfo
+	tpmQuoteResponse.FirmwareVersion = fmt.Sprintf("%016x", quote.firmwareVersion)
+
+	return nil
+}
+
 // create an array of "tcbMeasurments", each from the  xml escaped string
 // of the files located in /opt/trustagent/var/ramfs
 func getTcbMeasurements() ([]string, error) {
@@ -205,55 +252,63 @@ func getAssetTags(tagSecretKey string, tpm tpmprovider.TpmProvider) (string, err
 	log.Trace("common/quote:getAssetTags() Entering")
 	defer log.Trace("common/quote:getAssetTags() Leaving")
 
+	tagIndex, err := readAssetTagIndex(tagSecretKey, tpm)
+	if err != nil {
+		return "", err
+	}
+
+	if tagIndex == nil {
+		return "", nil
+	}
+
+	return base64.StdEncoding.EncodeToString(tagIndex.Digest), nil // this data will be evaluated in 'getNonce'
+}
+
+// readAssetTagIndex returns the tag stored in the asset tag nv index (see util.AssetTagIndex),
+// or nil when the index does not exist or a tag has not been deployed.
+func readAssetTagIndex(tagSecretKey string, tpm tpmprovider.TpmProvider) (*util.AssetTagIndex, error) {
 	tagExists, err := tpm.NvIndexExists(tpmprovider.NV_IDX_ASSET_TAG)
 	if err != nil {
-		return "", errors.Wrap(err, "common/quote:getAssetTags() Error while checking existence of Nv Index")
+		return nil, errors.Wrap(err, "common/quote:readAssetTagIndex() Error while checking existence of Nv Index")
 	}
 
 	if !tagExists {
 		log.Warn("The asset tag nvram is not present")
-		return "", nil
+		return nil, nil
 	}
 
 	indexBytes, err := tpm.NvRead(tagSecretKey, tpmprovider.NV_IDX_ASSET_TAG, tpmprovider.NV_IDX_ASSET_TAG)
 	if err != nil {
-		return "", errors.Wrap(err, "resource/quote:getAssetTags() Error while performing tpm nv read operation")
+		return nil, errors.Wrap(err, "common/quote:readAssetTagIndex() Error while performing tpm nv read operation")
 	}
 
 	if indexBytes == nil {
-		return "", errors.New("The tag data was nil")
-	} else if len(indexBytes) != constants.TagIndexSize {
-		return "", errors.Errorf("Invalid tag index length %d", len(indexBytes))
+		return nil, errors.New("The tag data was nil")
 	}
 
 	//
-	// The v4.0 Trust-Agent allocates the tag index during 'tagent setup'.  At that
-	// time, the index is filled with zeros.  If the all of the bytes are zero, then
-	// a tag has not been deployed so return "" to indicate 'no tag present'.
+	// The Trust-Agent allocates the tag index during 'tagent setup'.  At that time,
+	// the index is filled with zeros, in which case ParseAssetTagIndex returns nil
+	// to indicate 'no tag present'.
 	//
-	allZeros := true
-	for _, v := range indexBytes {
-		if v != 0 {
-			allZeros = false
-			break
-		}
-	}
-
-	if allZeros {
-		return "", nil
+	tagIndex, err := util.ParseAssetTagIndex(indexBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "common/quote:readAssetTagIndex() Error parsing the asset tag index")
 	}
 
-	return base64.StdEncoding.EncodeToString(indexBytes), nil // this data will be evaluated in 'getNonce'
+	return tagIndex, nil
 }
 
-func createTpmQuote(tagSecretKey string, tpm tpmprovider.TpmProvider, tpmQuoteRequest *taModel.TpmQuoteRequest) (*taModel.TpmQuoteResponse, error) {
+func createTpmQuote(tagSecretKey string, tpm tpmprovider.TpmProvider, tpmQuoteRequest *taModel.TpmQuoteRequest) (*TpmQuoteResponse, error) {
 	log.Trace("common/quote:createTpmQuote() Entering")
 	defer log.Trace("common/quote:createTpmQuote() Leaving")
 
 	var err error
 
-	tpmQuoteResponse := &taModel.TpmQuoteResponse{
-		TimeStamp: time.Now().Unix(),
+	tpmQuoteResponse := &TpmQuoteResponse{
+		TpmQuoteResponse: taModel.TpmQuoteResponse{
+			TimeStamp: time.Now().Unix(),
+		},
 	}
 
 	// getAssetTags must be called before getQuote so that the nonce is created correctly - see comments for getNonce()
@@ -273,16 +328,28 @@ func createTpmQuote(tagSecretKey string, tpm tpmprovider.TpmProvider, tpmQuoteRe
 
 	log.Debugf("NONCE: %+v", nonce)
 
+	// aik --> read from disk and convert to PEM string (before the quote, see checkAikCertificate)
+	tpmQuoteResponse.Aik, err = readAikAsBase64()
+	if err != nil {
+		return nil, errors.Wrap(err, "common/quote:createTpmQuote() Error while reading Aik as Base64")
+	}
+
 	// get the quote from tpmprovider
 	tpmQuoteResponse.Quote, err = getQuote(tpm, tpmQuoteRequest, nonce)
 	if err != nil {
 		return nil, errors.Wrap(err, "common/quote:createTpmQuote() Error while retrieving tpm quote request")
 	}
 
-	// aik --> read from disk and convert to PEM string
-	tpmQuoteResponse.Aik, err = readAikAsBase64()
+	err = checkAikCertificate(tpm, tpmQuoteResponse.Aik)
 	if err != nil {
-		return nil, errors.Wrap(err, "common/quote:createTpmQuote() Error while reading Aik as Base64")
+		return nil, err
+	}
+
+	// clock info/firmware version are informational: HVS verifies the quote itself, so
+	// don't fail the request if they cannot be parsed
+	err = addClockInfo(tpmQuoteResponse)
+	if err != nil {
+		log.WithError(err).Warn("common/quote:createTpmQuote() Could not parse clock info from the tpm quote")
 	}
 
 	// eventlog: read /opt/trustagent/var/measure-log.json
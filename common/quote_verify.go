@@ -0,0 +1,307 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package common
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"intel/isecl/go-trust-agent/v4/config"
+	"intel/isecl/go-trust-agent/v4/eventlog"
+	"intel/isecl/lib/tpmprovider/v4"
+	"sort"
+	"strings"
+
+	"github.com/intel-secl/intel-secl/v4/pkg/lib/common/log/message"
+	taModel "github.com/intel-secl/intel-secl/v4/pkg/model/ta"
+	"github.com/pkg/errors"
+)
+
+const (
+	QuoteCheckAikCertificate = "aik-certificate"
+	QuoteCheckQuoteFormat    = "quote-format"
+	QuoteCheckSignature      = "signature"
+	QuoteCheckNonce          = "nonce"
+	QuoteCheckPcrDigest      = "pcr-digest"
+	QuoteCheckEventLog       = "event-log-replay"
+
+	verificationNonceSize = 20
+	eventNoAction         = "EV_NO_ACTION"
+	startupLocalityTag    = "StartupLocality"
+)
+
+// QuoteVerificationCheck is the pass/fail result of one of the checks performed
+// by VerifyTpmQuote.
+type QuoteVerificationCheck struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+// QuoteVerificationReport contains the results of verifying a quote generated by the
+// Trust-Agent the same way HVS would (signature, nonce, pcr digest and event log replay).
+type QuoteVerificationReport struct {
+	Checks []QuoteVerificationCheck `json:"checks"`
+}
+
+// Passed returns true when all of the checks in the report have passed.
+func (report *QuoteVerificationReport) Passed() bool {
+	for _, check := range report.Checks {
+		if !check.Passed {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (report *QuoteVerificationReport) addCheck(name string, err error, successMessage string) bool {
+	check := QuoteVerificationCheck{
+		Name:    name,
+		Passed:  err == nil,
+		Message: successMessage,
+	}
+
+	if err != nil {
+		check.Message = err.Error()
+	}
+
+	report.Checks = append(report.Checks, check)
+	return check.Passed
+}
+
+// VerifyTpmQuote generates a quote (using a random nonce, all of the default PCR banks
+// and PCRs 0-23) with the AIK and verifies it locally.
+// This allows administrators to diagnose quote problems (ex. a stale aik.pem or a
+// corrupt event log) without a round trip through HVS.
+func VerifyTpmQuote(cfg *config.TrustAgentConfiguration, tpm tpmprovider.TpmProvider) (*QuoteVerificationReport, error) {
+	log.Trace("common/quote_verify:VerifyTpmQuote() Entering")
+	defer log.Trace("common/quote_verify:VerifyTpmQuote() Leaving")
+
+	nonce := make([]byte, verificationNonceSize)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, errors.Wrap(err, "common/quote_verify:VerifyTpmQuote() Error generating nonce")
+	}
+
+	tpmQuoteRequest := taModel.TpmQuoteRequest{
+		Nonce: nonce,
+	}
+
+	for i := 0; i < 24; i++ {
+		tpmQuoteRequest.Pcrs = append(tpmQuoteRequest.Pcrs, i)
+	}
+
+	tpmQuoteResponse, err := CreateTpmQuoteResponse(cfg, tpm, &tpmQuoteRequest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "common/quote_verify:VerifyTpmQuote() %s - Error creating tpm quote", message.AppRuntimeErr)
+	}
+
+	report := QuoteVerificationReport{}
+
+	aikCertificate, err := parseAikCertificate(tpmQuoteResponse.Aik)
+	report.addCheck(QuoteCheckAikCertificate, err, "The AIK certificate was parsed successfully")
+
+	quoteBytes, err := base64.StdEncoding.DecodeString(tpmQuoteResponse.Quote)
+	if err != nil {
+		report.addCheck(QuoteCheckQuoteFormat, errors.Wrap(err, "Error decoding quote"), "")
+		return &report, nil
+	}
+
+	quote, err := parseTpmQuote(quoteBytes)
+	if err != nil {
+		report.addCheck(QuoteCheckQuoteFormat, err, "")
+		return &report, nil
+	}
+	report.addCheck(QuoteCheckQuoteFormat, nil, fmt.Sprintf("The quote contains %d pcr values", len(quote.pcrValues)))
+
+	if aikCertificate != nil {
+		report.addCheck(QuoteCheckSignature, quote.verifySignature(aikCertificate.PublicKey), "The quote was signed by the AIK")
+	} else {
+		report.addCheck(QuoteCheckSignature, errors.New("The signature could not be verified without the AIK certificate"), "")
+	}
+
+	report.addCheck(QuoteCheckNonce, verifyQuoteNonce(quote, &tpmQuoteRequest, tpmQuoteResponse.AssetTag),
+		"The quote contains the expected nonce")
+
+	report.addCheck(QuoteCheckPcrDigest, verifyQuotePcrDigest(quote), "The pcr digest matches the quoted pcr values")
+
+	msg, err := verifyEventLogReplay(quote, tpmQuoteResponse.EventLog)
+	report.addCheck(QuoteCheckEventLog, err, msg)
+
+	return &report, nil
+}
+
+func parseAikCertificate(aikBase64 string) (*x509.Certificate, error) {
+	if aikBase64 == "" {
+		return nil, errors.New("The quote response did not contain an AIK certificate")
+	}
+
+	aikPem, err := base64.StdEncoding.DecodeString(aikBase64)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error decoding the AIK certificate")
+	}
+
+	block, _ := pem.Decode(aikPem)
+	if block == nil {
+		return nil, errors.New("The AIK certificate is not in pem format")
+	}
+
+	aikCertificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error parsing the AIK certificate")
+	}
+
+	return aikCertificate, nil
+}
+
+// verifyQuoteNonce checks that the quote's extra data matches the nonce that HVS would
+// expect (see getNonce), including the asset tag extension when a tag is provisioned.
+func verifyQuoteNonce(quote *tpmQuote, tpmQuoteRequest *taModel.TpmQuoteRequest, assetTag string) error {
+	expectedNonce, err := getNonce(tpmQuoteRequest, assetTag)
+	if err != nil {
+		return errors.Wrap(err, "Error calculating the expected nonce")
+	}
+
+	if !bytes.Equal(expectedNonce, quote.extraData) {
+		return errors.Errorf("The quote's nonce '%s' does not match the expected nonce '%s' (tag provisioned: %t)",
+			hex.EncodeToString(quote.extraData), hex.EncodeToString(expectedNonce), assetTag != "")
+	}
+
+	return nil
+}
+
+func verifyQuotePcrDigest(quote *tpmQuote) error {
+	pcrDigest, err := quote.calculatePcrDigest()
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(pcrDigest, quote.pcrDigest) {
+		return errors.Errorf("The pcr digest '%s' calculated from the quoted pcr values does not match the quote's pcr digest '%s'",
+			hex.EncodeToString(pcrDigest), hex.EncodeToString(quote.pcrDigest))
+	}
+
+	return nil
+}
+
+// verifyEventLogReplay replays the measurements in measure-log.json (starting from
+// zeros) and compares the results to the quoted pcr values.  Only the PCRs that have
+// events in the log are compared.
+func verifyEventLogReplay(quote *tpmQuote, eventLogJson string) (string, error) {
+	if eventLogJson == "" {
+		return "", errors.New("The event log (measure-log.json) was not present")
+	}
+
+	var pcrEventLogs []eventlog.PcrEventLog
+	err := json.Unmarshal([]byte(eventLogJson), &pcrEventLogs)
+	if err != nil {
+		return "", errors.Wrap(err, "Error parsing the event log")
+	}
+
+	replayedValues, err := replayEventLog(pcrEventLogs)
+	if err != nil {
+		return "", err
+	}
+
+	compared := 0
+	mismatches := []string{}
+	for _, pcrValue := range quote.pcrValues {
+		replayedValue, ok := replayedValues[pcrKey(pcrValue.bank, pcrValue.index)]
+		if !ok {
+			continue
+		}
+
+		compared++
+		if !bytes.Equal(replayedValue, pcrValue.value) {
+			mismatches = append(mismatches, pcrKey(pcrValue.bank, pcrValue.index))
+		}
+	}
+
+	if compared == 0 {
+		return "", errors.New("None of the quoted pcrs had events in the event log")
+	}
+
+	if len(mismatches) > 0 {
+		sort.Strings(mismatches)
+		return "", errors.Errorf("%d of %d pcrs replayed from the event log do not match the quote: %v", len(mismatches), compared, mismatches)
+	}
+
+	return fmt.Sprintf("%d pcrs replayed from the event log match the quote", compared), nil
+}
+
+func pcrKey(bank string, index int) string {
+	return fmt.Sprintf("%s:%d", bank, index)
+}
+
+func replayEventLog(pcrEventLogs []eventlog.PcrEventLog) (map[string][]byte, error) {
+	replayedValues := make(map[string][]byte)
+
+	for _, pcrEventLog := range pcrEventLogs {
+		var hashAlg uint16
+		switch pcrEventLog.Pcr.Bank {
+		case eventlog.SHA1:
+			hashAlg = tpmAlgSHA1
+		case eventlog.SHA256:
+			hashAlg = tpmAlgSHA256
+		case eventlog.SHA384:
+			hashAlg = tpmAlgSHA384
+		default:
+			log.Warnf("common/quote_verify:replayEventLog() Skipping unsupported pcr bank '%s'", pcrEventLog.Pcr.Bank)
+			continue
+		}
+
+		hash, err := tpmAlgToHash(hashAlg)
+		if err != nil {
+			return nil, err
+		}
+
+		key := pcrKey(pcrEventLog.Pcr.Bank, int(pcrEventLog.Pcr.Index))
+		pcrValue, ok := replayedValues[key]
+		if !ok {
+			pcrValue = make([]byte, hash.Size())
+		}
+
+		for _, event := range pcrEventLog.TpmEvents {
+			// EV_NO_ACTION events are informational and are not extended into the PCR.  However,
+			// the 'StartupLocality' event indicates that PCR0 was initialized with the locality
+			// in its last byte (ex. 'StartupLocality3').
+			if event.TypeName == eventNoAction {
+				for _, tag := range event.Tags {
+					if strings.HasPrefix(tag, startupLocalityTag) && len(tag) == len(startupLocalityTag)+1 {
+						pcrValue = make([]byte, hash.Size())
+						pcrValue[len(pcrValue)-1] = tag[len(startupLocalityTag)] - '0'
+					}
+				}
+				continue
+			}
+
+			measurement, err := hex.DecodeString(event.Measurement)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid measurement '%s' in pcr %s", event.Measurement, key)
+			}
+
+			h := hash.New()
+			_, err = h.Write(pcrValue)
+			if err != nil {
+				return nil, err
+			}
+			_, err = h.Write(measurement)
+			if err != nil {
+				return nil, err
+			}
+			pcrValue = h.Sum(nil)
+		}
+
+		replayedValues[key] = pcrValue
+	}
+
+	return replayedValues, nil
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package common
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// RequestIDField is the name of the field that contains the request's id in log entries.
+const RequestIDField = "request_id"
+
+// request ids provided by clients are logged and returned in responses, so only
+// allow 'safe' characters and a reasonable length
+var requestIDPattern = regexp.MustCompile(`^[a-zA-Z0-9._:\-]{1,128}$`)
+
+type requestIDKey struct{}
+
+// NewRequestID returns a (uuid) id for a request that was not provided one by the client.
+func NewRequestID() string {
+	return uuid.New().String()
+}
+
+// IsValidRequestID returns true when a request id provided by a client (ex. in the
+// X-Request-ID header) can be used.
+func IsValidRequestID(requestID string) bool {
+	return requestIDPattern.MatchString(requestID)
+}
+
+// WithRequestID returns a copy of 'ctx' that contains the request's id.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// GetRequestID returns the request id in 'ctx' (or an empty string when 'ctx' does not
+// have one).
+func GetRequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// GetLogger returns the default logger with the request id field from 'ctx', so that
+// the log entries of a request can be correlated with the caller's logs.
+func GetLogger(ctx context.Context) *logrus.Entry {
+	return withRequestID(log, ctx)
+}
+
+// GetSecurityLogger returns the security logger with the request id field from 'ctx'.
+func GetSecurityLogger(ctx context.Context) *logrus.Entry {
+	return withRequestID(secLog, ctx)
+}
+
+func withRequestID(logger *logrus.Entry, ctx context.Context) *logrus.Entry {
+	requestID := GetRequestID(ctx)
+	if requestID == "" {
+		return logger
+	}
+
+	return logger.WithField(RequestIDField, requestID)
+}
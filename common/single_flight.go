@@ -0,0 +1,61 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package common
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// singleFlight runs one call at a time and shares its result with the callers that requested
+// it while it was running (like golang.org/x/sync/singleflight for a single key).  It is used
+// by the cached, unauthenticated or frequently polled requests (ex. readiness checks) so
+// that a burst of requests results in a single TPM operation, without holding a lock while
+// waiting for the TPM.
+type singleFlight struct {
+	mutex sync.Mutex
+	call  *singleFlightCall
+}
+
+type singleFlightCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// do runs 'fn' or, when a call is already running, waits for its result (or until 'ctx' is
+// done).  'fn' should not depend on the caller's 'ctx' since its result is shared.
+func (group *singleFlight) do(ctx context.Context, fn func() (interface{}, error)) (interface{}, error) {
+	group.mutex.Lock()
+	call := group.call
+	if call == nil {
+		// the error is returned to the waiting callers if 'fn' panics
+		call = &singleFlightCall{done: make(chan struct{}), err: errors.New("The call did not complete")}
+		group.call = call
+		group.mutex.Unlock()
+
+		func() {
+			defer func() {
+				group.mutex.Lock()
+				group.call = nil
+				group.mutex.Unlock()
+				close(call.done)
+			}()
+			call.value, call.err = fn()
+		}()
+
+		return call.value, call.err
+	}
+	group.mutex.Unlock()
+
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package common
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSingleFlightSharesCall(t *testing.T) {
+	var group singleFlight
+	var calls int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	fn := func() (interface{}, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+		}
+		<-release
+		return "result", nil
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan interface{}, 4)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		value, _ := group.do(context.Background(), fn)
+		results <- value
+	}()
+	<-started
+
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, _ := group.do(context.Background(), fn)
+			results <- value
+		}()
+	}
+
+	// give the other callers time to wait for the running call
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	for value := range results {
+		assert.Equal(t, "result", value)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// the next call runs 'fn' again
+	value, err := group.do(context.Background(), func() (interface{}, error) { return "next", nil })
+	assert.NoError(t, err)
+	assert.Equal(t, "next", value)
+}
+
+func TestSingleFlightWaitDeadline(t *testing.T) {
+	var group singleFlight
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	go func() {
+		_, _ = group.do(context.Background(), func() (interface{}, error) {
+			close(started)
+			<-release
+			return nil, nil
+		})
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := group.do(ctx, func() (interface{}, error) {
+		t.Error("The call should not run while another call is running")
+		return nil, nil
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
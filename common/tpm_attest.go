@@ -0,0 +1,363 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package common
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/binary"
+	"io"
+	"math/big"
+
+	"intel/isecl/go-trust-agent/v4/constants"
+
+	"github.com/pkg/errors"
+)
+
+// The quote bytes returned by tpmprovider.GetTpmQuote() are the concatenation of...
+// - TPM2B_ATTEST: 2 byte size followed by a TPMS_ATTEST structure (the data signed by the AIK)
+// - TPMT_SIGNATURE: the AIK's signature over the TPMS_ATTEST
+// - The values of the selected PCRs (in the order of the TPMS_ATTEST's pcr selection)
+//
+// See TPM 2.0 Part 2 (Structures) for details of TPMS_ATTEST/TPMS_QUOTE_INFO.
+const (
+	tpmGeneratedValue = 0xff544347 // TPM_GENERATED_VALUE
+	tpmStAttestQuote  = 0x8018     // TPM_ST_ATTEST_QUOTE
+
+	tpmAlgSHA1   = 0x0004
+	tpmAlgSHA256 = 0x000B
+	tpmAlgSHA384 = 0x000C
+	tpmAlgSHA512 = 0x000D
+	tpmAlgRSASSA = 0x0014
+	tpmAlgRSAPSS = 0x0016
+	tpmAlgECDSA  = 0x0018
+
+	maxPcrBanks = 5
+)
+
+type tpmsPcrSelection struct {
+	hashAlg uint16
+	pcrs    []int
+}
+
+type tpmPcrValue struct {
+	bank  string
+	index int
+	value []byte
+}
+
+// tpmQuote is the parsed form of the bytes returned by tpmprovider.GetTpmQuote()
+type tpmQuote struct {
+	attest          []byte // the raw TPMS_ATTEST that was signed by the AIK
+	qualifiedSigner []byte
+	extraData       []byte // the nonce
+	clockInfo       TpmClockInfo
+	firmwareVersion uint64
+	pcrSelections   []tpmsPcrSelection
+	pcrDigest       []byte
+	sigAlg          uint16
+	sigHashAlg      uint16
+	signature       []byte   // RSASSA/RSAPSS signature
+	signatureR      *big.Int // ECDSA signature
+	signatureS      *big.Int // ECDSA signature
+	pcrValues       []tpmPcrValue
+}
+
+func tpmAlgToHash(alg uint16) (crypto.Hash, error) {
+	switch alg {
+	case tpmAlgSHA1:
+		return crypto.SHA1, nil
+	case tpmAlgSHA256:
+		return crypto.SHA256, nil
+	case tpmAlgSHA384:
+		return crypto.SHA384, nil
+	case tpmAlgSHA512:
+		return crypto.SHA512, nil
+	}
+
+	return 0, errors.Errorf("Unsupported TPM hash algorithm 0x%x", alg)
+}
+
+func tpmAlgToBank(alg uint16) string {
+	switch alg {
+	case tpmAlgSHA1:
+		return string(constants.SHA1)
+	case tpmAlgSHA256:
+		return string(constants.SHA256)
+	case tpmAlgSHA384:
+		return string(constants.SHA384)
+	case tpmAlgSHA512:
+		return string(constants.SHA512)
+	}
+
+	return string(constants.UNKNOWN)
+}
+
+func readTpm2b(reader *bytes.Reader) ([]byte, error) {
+	var size uint16
+	err := binary.Read(reader, binary.BigEndian, &size)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, size)
+	_, err = io.ReadFull(reader, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// parseTpmQuote parses the bytes returned by tpmprovider.GetTpmQuote(), checking the
+// bounds of each field (the quote may be provided by an untrusted caller).
+func parseTpmQuote(quoteBytes []byte) (*tpmQuote, error) {
+	var err error
+	quote := tpmQuote{}
+	reader := bytes.NewReader(quoteBytes)
+
+	quote.attest, err = readTpm2b(reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading TPM2B_ATTEST from quote")
+	}
+
+	err = quote.parseAttest()
+	if err != nil {
+		return nil, err
+	}
+
+	err = quote.parseSignature(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	err = quote.parsePcrValues(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &quote, nil
+}
+
+func (quote *tpmQuote) parseAttest() error {
+	var err error
+	var magic uint32
+	var attestType uint16
+
+	reader := bytes.NewReader(quote.attest)
+
+	err = binary.Read(reader, binary.BigEndian, &magic)
+	if err != nil {
+		return errors.Wrap(err, "Error reading TPMS_ATTEST magic")
+	}
+
+	if magic != tpmGeneratedValue {
+		return errors.Errorf("Invalid TPMS_ATTEST magic 0x%x", magic)
+	}
+
+	err = binary.Read(reader, binary.BigEndian, &attestType)
+	if err != nil {
+		return errors.Wrap(err, "Error reading TPMS_ATTEST type")
+	}
+
+	if attestType != tpmStAttestQuote {
+		return errors.Errorf("TPMS_ATTEST type 0x%x is not a quote", attestType)
+	}
+
+	quote.qualifiedSigner, err = readTpm2b(reader)
+	if err != nil {
+		return errors.Wrap(err, "Error reading TPMS_ATTEST qualified signer")
+	}
+
+	quote.extraData, err = readTpm2b(reader)
+	if err != nil {
+		return errors.Wrap(err, "Error reading TPMS_ATTEST extra data")
+	}
+
+	var safe uint8
+	for _, field := range []interface{}{&quote.clockInfo.Clock, &quote.clockInfo.ResetCount, &quote.clockInfo.RestartCount, &safe, &quote.firmwareVersion} {
+		err = binary.Read(reader, binary.BigEndian, field)
+		if err != nil {
+			return errors.Wrap(err, "Error reading TPMS_ATTEST clock info")
+		}
+	}
+	quote.clockInfo.Safe = safe != 0
+
+	var selectionCount uint32
+	err = binary.Read(reader, binary.BigEndian, &selectionCount)
+	if err != nil {
+		return errors.Wrap(err, "Error reading TPMS_QUOTE_INFO pcr selection count")
+	}
+
+	if selectionCount > maxPcrBanks {
+		return errors.Errorf("Invalid TPMS_QUOTE_INFO pcr selection count %d", selectionCount)
+	}
+
+	for i := uint32(0); i < selectionCount; i++ {
+		var selection tpmsPcrSelection
+		var selectSize uint8
+
+		err = binary.Read(reader, binary.BigEndian, &selection.hashAlg)
+		if err != nil {
+			return errors.Wrap(err, "Error reading TPMS_PCR_SELECTION hash algorithm")
+		}
+
+		err = binary.Read(reader, binary.BigEndian, &selectSize)
+		if err != nil {
+			return errors.Wrap(err, "Error reading TPMS_PCR_SELECTION size")
+		}
+
+		pcrSelect := make([]byte, selectSize)
+		_, err = io.ReadFull(reader, pcrSelect)
+		if err != nil {
+			return errors.Wrap(err, "Error reading TPMS_PCR_SELECTION bitmap")
+		}
+
+		for pcr := 0; pcr < 8*int(selectSize); pcr++ {
+			if pcrSelect[pcr/8]&(1<<(uint(pcr)%8)) != 0 {
+				selection.pcrs = append(selection.pcrs, pcr)
+			}
+		}
+
+		quote.pcrSelections = append(quote.pcrSelections, selection)
+	}
+
+	quote.pcrDigest, err = readTpm2b(reader)
+	if err != nil {
+		return errors.Wrap(err, "Error reading TPMS_QUOTE_INFO pcr digest")
+	}
+
+	return nil
+}
+
+func (quote *tpmQuote) parseSignature(reader *bytes.Reader) error {
+	var err error
+
+	err = binary.Read(reader, binary.BigEndian, &quote.sigAlg)
+	if err != nil {
+		return errors.Wrap(err, "Error reading TPMT_SIGNATURE algorithm")
+	}
+
+	err = binary.Read(reader, binary.BigEndian, &quote.sigHashAlg)
+	if err != nil {
+		return errors.Wrap(err, "Error reading TPMT_SIGNATURE hash algorithm")
+	}
+
+	switch quote.sigAlg {
+	case tpmAlgRSASSA, tpmAlgRSAPSS:
+		quote.signature, err = readTpm2b(reader)
+		if err != nil {
+			return errors.Wrap(err, "Error reading TPMT_SIGNATURE rsa signature")
+		}
+	case tpmAlgECDSA:
+		r, err := readTpm2b(reader)
+		if err != nil {
+			return errors.Wrap(err, "Error reading TPMT_SIGNATURE ecdsa signature (r)")
+		}
+
+		s, err := readTpm2b(reader)
+		if err != nil {
+			return errors.Wrap(err, "Error reading TPMT_SIGNATURE ecdsa signature (s)")
+		}
+
+		quote.signatureR = new(big.Int).SetBytes(r)
+		quote.signatureS = new(big.Int).SetBytes(s)
+	default:
+		return errors.Errorf("Unsupported TPMT_SIGNATURE algorithm 0x%x", quote.sigAlg)
+	}
+
+	return nil
+}
+
+func (quote *tpmQuote) parsePcrValues(reader *bytes.Reader) error {
+	for _, selection := range quote.pcrSelections {
+		hash, err := tpmAlgToHash(selection.hashAlg)
+		if err != nil {
+			return err
+		}
+
+		for _, pcr := range selection.pcrs {
+			value := make([]byte, hash.Size())
+			_, err = io.ReadFull(reader, value)
+			if err != nil {
+				return errors.Wrapf(err, "Error reading the value of %s PCR %d from the quote", tpmAlgToBank(selection.hashAlg), pcr)
+			}
+
+			quote.pcrValues = append(quote.pcrValues, tpmPcrValue{
+				bank:  tpmAlgToBank(selection.hashAlg),
+				index: pcr,
+				value: value,
+			})
+		}
+	}
+
+	if reader.Len() != 0 {
+		return errors.Errorf("The quote contains %d unexpected trailing bytes", reader.Len())
+	}
+
+	return nil
+}
+
+// verifySignature checks the AIK's signature over the TPMS_ATTEST
+func (quote *tpmQuote) verifySignature(publicKey crypto.PublicKey) error {
+	hash, err := tpmAlgToHash(quote.sigHashAlg)
+	if err != nil {
+		return err
+	}
+
+	h := hash.New()
+	_, err = h.Write(quote.attest)
+	if err != nil {
+		return err
+	}
+	digest := h.Sum(nil)
+
+	switch quote.sigAlg {
+	case tpmAlgRSASSA, tpmAlgRSAPSS:
+		rsaPublicKey, ok := publicKey.(*rsa.PublicKey)
+		if !ok {
+			return errors.Errorf("The quote has an rsa signature (0x%x) but the AIK is not an rsa key", quote.sigAlg)
+		}
+
+		if quote.sigAlg == tpmAlgRSASSA {
+			return rsa.VerifyPKCS1v15(rsaPublicKey, hash, digest, quote.signature)
+		}
+		return rsa.VerifyPSS(rsaPublicKey, hash, digest, quote.signature, nil)
+
+	case tpmAlgECDSA:
+		ecdsaPublicKey, ok := publicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("The quote has an ecdsa signature but the AIK is not an ecc key")
+		}
+
+		if !ecdsa.Verify(ecdsaPublicKey, digest, quote.signatureR, quote.signatureS) {
+			return errors.New("ecdsa signature verification failed")
+		}
+		return nil
+	}
+
+	return errors.Errorf("Unsupported TPMT_SIGNATURE algorithm 0x%x", quote.sigAlg)
+}
+
+// calculatePcrDigest returns the digest of the concatenated PCR values (using the
+// signature's hash algorithm) which should match the TPMS_QUOTE_INFO's pcr digest.
+func (quote *tpmQuote) calculatePcrDigest() ([]byte, error) {
+	hash, err := tpmAlgToHash(quote.sigHashAlg)
+	if err != nil {
+		return nil, err
+	}
+
+	h := hash.New()
+	for _, pcrValue := range quote.pcrValues {
+		_, err = h.Write(pcrValue.value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package common
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"intel/isecl/go-trust-agent/v4/eventlog"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTpm2b(buf *bytes.Buffer, data []byte) {
+	binary.Write(buf, binary.BigEndian, uint16(len(data)))
+	buf.Write(data)
+}
+
+// createTestQuote builds quote bytes in the format returned by tpmprovider.GetTpmQuote()
+// for the sha256 bank and the provided pcr values (indexed by pcr number).
+func createTestQuote(t *testing.T, key *rsa.PrivateKey, nonce []byte, pcrValues map[int][]byte) []byte {
+	pcrSelect := make([]byte, 3)
+	pcrDigest := sha256.New()
+	pcrBytes := bytes.Buffer{}
+	for i := 0; i < 24; i++ {
+		if value, ok := pcrValues[i]; ok {
+			pcrSelect[i/8] |= 1 << (uint(i) % 8)
+			pcrDigest.Write(value)
+			pcrBytes.Write(value)
+		}
+	}
+
+	attest := bytes.Buffer{}
+	binary.Write(&attest, binary.BigEndian, uint32(tpmGeneratedValue))
+	binary.Write(&attest, binary.BigEndian, uint16(tpmStAttestQuote))
+	writeTpm2b(&attest, []byte("qualified signer"))
+	writeTpm2b(&attest, nonce)
+	binary.Write(&attest, binary.BigEndian, uint64(123456)) // clock
+	binary.Write(&attest, binary.BigEndian, uint32(7))      // reset count
+	binary.Write(&attest, binary.BigEndian, uint32(3))      // restart count
+	binary.Write(&attest, binary.BigEndian, uint8(1))       // safe
+	binary.Write(&attest, binary.BigEndian, uint64(0x1234)) // firmware version
+	binary.Write(&attest, binary.BigEndian, uint32(1))      // pcr selection count
+	binary.Write(&attest, binary.BigEndian, uint16(tpmAlgSHA256))
+	binary.Write(&attest, binary.BigEndian, uint8(len(pcrSelect)))
+	attest.Write(pcrSelect)
+	writeTpm2b(&attest, pcrDigest.Sum(nil))
+
+	attestDigest := sha256.Sum256(attest.Bytes())
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, attestDigest[:])
+	assert.NoError(t, err)
+
+	quote := bytes.Buffer{}
+	writeTpm2b(&quote, attest.Bytes())
+	binary.Write(&quote, binary.BigEndian, uint16(tpmAlgRSASSA))
+	binary.Write(&quote, binary.BigEndian, uint16(tpmAlgSHA256))
+	writeTpm2b(&quote, signature)
+	quote.Write(pcrBytes.Bytes())
+
+	return quote.Bytes()
+}
+
+func extend(pcrValue []byte, measurement []byte) []byte {
+	h := sha256.New()
+	h.Write(pcrValue)
+	h.Write(measurement)
+	return h.Sum(nil)
+}
+
+func TestParseAndVerifyTpmQuote(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	measurement := bytes.Repeat([]byte{0xaa}, sha256.Size)
+	pcrValues := map[int][]byte{
+		0:  extend(make([]byte, sha256.Size), measurement),
+		17: bytes.Repeat([]byte{0x11}, sha256.Size),
+	}
+
+	nonce := []byte("01234567890123456789")
+	quote, err := parseTpmQuote(createTestQuote(t, key, nonce, pcrValues))
+	assert.NoError(t, err)
+
+	assert.Equal(t, nonce, quote.extraData)
+	assert.Equal(t, uint64(123456), quote.clockInfo.Clock)
+	assert.Equal(t, uint32(7), quote.clockInfo.ResetCount)
+	assert.Equal(t, uint32(3), quote.clockInfo.RestartCount)
+	assert.True(t, quote.clockInfo.Safe)
+	assert.Equal(t, uint64(0x1234), quote.firmwareVersion)
+	assert.Equal(t, 2, len(quote.pcrValues))
+	assert.Equal(t, string(eventlog.SHA256), quote.pcrValues[1].bank)
+	assert.Equal(t, 17, quote.pcrValues[1].index)
+
+	assert.NoError(t, quote.verifySignature(&key.PublicKey))
+	assert.NoError(t, verifyQuotePcrDigest(quote))
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	assert.Error(t, quote.verifySignature(&otherKey.PublicKey))
+
+	// replaying the event log for pcr 0 should match the quote
+	eventLogJson, err := json.Marshal([]eventlog.PcrEventLog{
+		{
+			Pcr: eventlog.PcrData{Index: 0, Bank: eventlog.SHA256},
+			TpmEvents: []eventlog.TpmEvent{
+				{TypeName: eventNoAction, Tags: []string{"StartupLocality0"}},
+				{Measurement: hex.EncodeToString(measurement)},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	_, err = verifyEventLogReplay(quote, string(eventLogJson))
+	assert.NoError(t, err)
+
+	// a locality 3 startup changes the initial value of pcr 0 and should not match
+	eventLogJson = bytes.Replace(eventLogJson, []byte("StartupLocality0"), []byte("StartupLocality3"), 1)
+	_, err = verifyEventLogReplay(quote, string(eventLogJson))
+	assert.Error(t, err)
+}
+
+func TestParseTpmQuoteTruncated(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	quoteBytes := createTestQuote(t, key, []byte("nonce"), map[int][]byte{0: make([]byte, sha256.Size)})
+
+	for _, length := range []int{0, 1, 10, 60, len(quoteBytes) - 1} {
+		_, err = parseTpmQuote(quoteBytes[:length])
+		assert.Error(t, err, "length %d", length)
+	}
+
+	_, err = parseTpmQuote(append(quoteBytes, 0))
+	assert.Error(t, err)
+}
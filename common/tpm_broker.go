@@ -0,0 +1,189 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package common
+
+import (
+	"context"
+	"intel/isecl/go-trust-agent/v4/constants"
+	"intel/isecl/lib/tpmprovider/v4"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-secl/intel-secl/v4/pkg/lib/common/log/message"
+	"github.com/pkg/errors"
+)
+
+// TpmOperation is a function that is run by the TpmBroker with exclusive access to
+// the TPM.
+type TpmOperation func(tpm tpmprovider.TpmProvider) error
+
+// TpmBrokerMetrics is a snapshot of the TpmBroker's queue and counters.
+type TpmBrokerMetrics struct {
+	QueueDepth    int    `json:"queue_depth"`
+	QueueCapacity int    `json:"queue_capacity"`
+	Completed     uint64 `json:"completed"`
+	Failed        uint64 `json:"failed"`
+	Rejected      uint64 `json:"rejected"`
+	TimedOut      uint64 `json:"timed_out"`
+	Cancelled     uint64 `json:"cancelled"`
+}
+
+type tpmBrokerRequest struct {
+	ctx           context.Context
+	operationName string
+	operation     TpmOperation
+	result        chan error
+}
+
+// TpmBroker serializes access to the TPM.  HTTP and NATS request handlers submit
+// TpmOperations to a bounded queue that is drained by a single goroutine that
+// 'owns' the TPM, so that tpm sessions from concurrent requests cannot interleave.
+//
+// When the queue is full, Execute fails immediately with a 503 EndpointError.  Callers
+// wait at most the operation timeout for a result.  Note that a tpm command that is
+// already running cannot be interrupted -- if the TPM hangs, the queue fills and
+// subsequent requests are rejected until the TPM responds.
+type TpmBroker struct {
+	// counters are accessed atomically and must be 64 bit aligned (keep them first)
+	completed uint64
+	failed    uint64
+	rejected  uint64
+	timedOut  uint64
+	cancelled uint64
+
+	tpmFactory       tpmprovider.TpmFactory
+	requests         chan *tpmBrokerRequest
+	operationTimeout time.Duration
+	stop             chan struct{}
+}
+
+// NewTpmBroker creates a TpmBroker and starts the goroutine that processes its queue.
+// Default values are used when 'queueSize' or 'operationTimeout' are zero.
+func NewTpmBroker(tpmFactory tpmprovider.TpmFactory, queueSize int, operationTimeout time.Duration) *TpmBroker {
+	if queueSize <= 0 {
+		queueSize = constants.DefaultTpmQueueSize
+	}
+
+	if operationTimeout <= 0 {
+		operationTimeout = constants.DefaultTpmOperationTimeout
+	}
+
+	broker := TpmBroker{
+		tpmFactory:       tpmFactory,
+		requests:         make(chan *tpmBrokerRequest, queueSize),
+		operationTimeout: operationTimeout,
+		stop:             make(chan struct{}),
+	}
+
+	go broker.run()
+	return &broker
+}
+
+// Execute queues the operation and waits for its result, the operation timeout or the
+// cancellation/deadline of the request's 'ctx' (whichever occurs first).  Operations whose
+// request has been cancelled before they are dequeued are not run.  'operationName' (ex.
+// TpmOperationQuote) identifies the operation in the tpm metrics.
+func (broker *TpmBroker) Execute(ctx context.Context, operationName string, operation TpmOperation) error {
+	operationCtx, cancel := context.WithTimeout(ctx, broker.operationTimeout)
+	defer cancel()
+
+	request := tpmBrokerRequest{
+		ctx:           operationCtx,
+		operationName: operationName,
+		operation:     operation,
+		result:        make(chan error, 1),
+	}
+
+	select {
+	case broker.requests <- &request:
+	default:
+		atomic.AddUint64(&broker.rejected, 1)
+		log.Warnf("common/tpm_broker:Execute() %s - The tpm queue is full (%d requests)", message.PerformanceProblem, cap(broker.requests))
+		return &EndpointError{Message: "The TPM is busy, please retry the request", StatusCode: http.StatusServiceUnavailable, Code: ErrorCodeTpmUnavailable}
+	}
+
+	select {
+	case err := <-request.result:
+		return err
+	case <-operationCtx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			atomic.AddUint64(&broker.timedOut, 1)
+			log.Errorf("common/tpm_broker:Execute() %s - The request's deadline expired waiting for the tpm", message.PerformanceProblem)
+			return &EndpointError{Message: "The request timed out waiting for the TPM", StatusCode: http.StatusServiceUnavailable, Code: ErrorCodeTimeout}
+		}
+
+		if operationCtx.Err() == context.DeadlineExceeded {
+			atomic.AddUint64(&broker.timedOut, 1)
+			log.Errorf("common/tpm_broker:Execute() %s - Timed out after %s waiting for the tpm", message.PerformanceProblem, broker.operationTimeout)
+			return &EndpointError{Message: "Timed out waiting for the TPM", StatusCode: http.StatusServiceUnavailable, Code: ErrorCodeTpmUnavailable}
+		}
+
+		atomic.AddUint64(&broker.cancelled, 1)
+		return errors.Wrap(operationCtx.Err(), "common/tpm_broker:Execute() The tpm operation was cancelled")
+	}
+}
+
+// Metrics returns a snapshot of the broker's queue depth and counters.
+func (broker *TpmBroker) Metrics() TpmBrokerMetrics {
+	return TpmBrokerMetrics{
+		QueueDepth:    len(broker.requests),
+		QueueCapacity: cap(broker.requests),
+		Completed:     atomic.LoadUint64(&broker.completed),
+		Failed:        atomic.LoadUint64(&broker.failed),
+		Rejected:      atomic.LoadUint64(&broker.rejected),
+		TimedOut:      atomic.LoadUint64(&broker.timedOut),
+		Cancelled:     atomic.LoadUint64(&broker.cancelled),
+	}
+}
+
+// Close stops the goroutine that processes the queue.  Requests that are still queued
+// will time out.
+func (broker *TpmBroker) Close() {
+	close(broker.stop)
+}
+
+func (broker *TpmBroker) run() {
+	for {
+		select {
+		case <-broker.stop:
+			return
+		case request := <-broker.requests:
+			// don't touch the tpm if the caller has already given up
+			if request.ctx.Err() != nil {
+				log.Debugf("common/tpm_broker:run() Skipping tpm operation: %s", request.ctx.Err())
+				continue
+			}
+
+			start := time.Now()
+			err := broker.execute(request.operation)
+			observeTpmOperation(request.operationName, start, err)
+			if err != nil {
+				atomic.AddUint64(&broker.failed, 1)
+			} else {
+				atomic.AddUint64(&broker.completed, 1)
+			}
+
+			request.result <- err
+		}
+	}
+}
+
+func (broker *TpmBroker) execute(operation TpmOperation) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("common/tpm_broker:execute() Panic during tpm operation: %+v", r)
+		}
+	}()
+
+	tpm, err := broker.tpmFactory.NewTpmProvider()
+	if err != nil {
+		log.WithError(err).Errorf("common/tpm_broker:execute() %s - Error creating tpm provider", message.AppRuntimeErr)
+		return &EndpointError{Message: "The TPM could not be opened", StatusCode: http.StatusServiceUnavailable, Code: ErrorCodeTpmUnavailable}
+	}
+	defer tpm.Close()
+
+	return operation(tpm)
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package common
+
+import (
+	"context"
+	"intel/isecl/lib/tpmprovider/v4"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newMockedTpmBroker(queueSize int, operationTimeout time.Duration) *TpmBroker {
+	mockedTpmProvider := new(tpmprovider.MockedTpmProvider)
+	mockedTpmProvider.On("Close").Return(nil)
+
+	return NewTpmBroker(tpmprovider.MockedTpmFactory{TpmProvider: mockedTpmProvider}, queueSize, operationTimeout)
+}
+
+func TestTpmBrokerSerializesOperations(t *testing.T) {
+	broker := newMockedTpmBroker(32, time.Second)
+	defer broker.Close()
+
+	var running int32
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := broker.Execute(context.Background(), "test", func(tpm tpmprovider.TpmProvider) error {
+				assert.Equal(t, int32(1), atomic.AddInt32(&running, 1), "tpm operations overlapped")
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				return nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, uint64(16), broker.Metrics().Completed)
+}
+
+func TestTpmBrokerSaturatedAndTimeout(t *testing.T) {
+	broker := newMockedTpmBroker(1, 50*time.Millisecond)
+	defer broker.Close()
+
+	// block the broker's goroutine with a 'hung' tpm operation
+	hung := make(chan struct{})
+	defer close(hung)
+	started := make(chan struct{})
+	go broker.Execute(context.Background(), "test", func(tpm tpmprovider.TpmProvider) error {
+		close(started)
+		<-hung
+		return nil
+	})
+	<-started
+
+	// fill the queue...
+	go broker.Execute(context.Background(), "test", func(tpm tpmprovider.TpmProvider) error { return nil })
+	for broker.Metrics().QueueDepth != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// ...the next request should be rejected
+	err := broker.Execute(context.Background(), "test", func(tpm tpmprovider.TpmProvider) error { return nil })
+	endpointError, ok := err.(*EndpointError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusServiceUnavailable, endpointError.StatusCode)
+	assert.Equal(t, uint64(1), broker.Metrics().Rejected)
+
+	// wait for the queued request to time out
+	for broker.Metrics().TimedOut == 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTpmBrokerRequestDeadline(t *testing.T) {
+	broker := newMockedTpmBroker(4, time.Second)
+	defer broker.Close()
+
+	// block the broker's goroutine so that the next request waits in the queue
+	hung := make(chan struct{})
+	defer close(hung)
+	started := make(chan struct{})
+	go broker.Execute(context.Background(), "test", func(tpm tpmprovider.TpmProvider) error {
+		close(started)
+		<-hung
+		return nil
+	})
+	<-started
+
+	// the request's deadline expires before the broker's operation timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	ran := false
+	err := broker.Execute(ctx, "test", func(tpm tpmprovider.TpmProvider) error {
+		ran = true
+		return nil
+	})
+
+	endpointError, ok := err.(*EndpointError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusServiceUnavailable, endpointError.StatusCode)
+	assert.Equal(t, ErrorCodeTimeout, endpointError.ErrorCode())
+	assert.False(t, ran)
+}
@@ -7,9 +7,12 @@ package config
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"intel/isecl/go-trust-agent/v4/constants"
@@ -31,11 +34,52 @@ type WebService struct {
 	WriteTimeout      time.Duration // TA_SERVER_WRITE_TIMEOUT
 	IdleTimeout       time.Duration // TA_SERVER_IDLE_TIMEOUT
 	MaxHeaderBytes    int           // TA_SERVER_MAX_HEADER_BYTES
+	RequestTimeout    time.Duration // TA_SERVER_REQUEST_TIMEOUT
+	AuthMode          string        // TA_AUTH_MODE
+}
+
+type Metrics struct {
+	Port int // TA_METRICS_PORT
+}
+
+// RateLimit limits the requests to a route (web) or subject (NATS).  Zero values disable
+// the corresponding limit.
+type RateLimit struct {
+	RequestsPerSecond     float64 // token bucket rate of each caller (JWT subject)
+	Burst                 int     // token bucket size of each caller
+	MaxInFlight           int     // concurrent requests to the route from all callers
+	MaxInFlightPerSubject int     // concurrent requests to the route from each caller
+}
+
+type RateLimits struct {
+	Quote                  RateLimit // TA_QUOTE_RATE_LIMIT, TA_QUOTE_RATE_BURST, TA_QUOTE_MAX_IN_FLIGHT, TA_QUOTE_MAX_IN_FLIGHT_PER_SUBJECT
+	ApplicationMeasurement RateLimit // TA_MEASUREMENT_RATE_LIMIT, TA_MEASUREMENT_RATE_BURST, TA_MEASUREMENT_MAX_IN_FLIGHT, TA_MEASUREMENT_MAX_IN_FLIGHT_PER_SUBJECT
+	Default                RateLimit // TA_RATE_LIMIT, TA_RATE_BURST, TA_MAX_IN_FLIGHT, TA_MAX_IN_FLIGHT_PER_SUBJECT
+}
+
+// DefaultRateLimits returns the limits of the quote and application-measurement routes (see
+// constants.DefaultQuoteRateLimit, etc.).  Other routes are not limited by default.
+func DefaultRateLimits() RateLimits {
+	return RateLimits{
+		Quote: RateLimit{
+			RequestsPerSecond:     constants.DefaultQuoteRateLimit,
+			Burst:                 constants.DefaultQuoteRateBurst,
+			MaxInFlight:           constants.DefaultQuoteMaxInFlight,
+			MaxInFlightPerSubject: constants.DefaultQuoteMaxInFlightPerSubject,
+		},
+		ApplicationMeasurement: RateLimit{
+			RequestsPerSecond:     constants.DefaultMeasurementRateLimit,
+			Burst:                 constants.DefaultMeasurementRateBurst,
+			MaxInFlight:           constants.DefaultMeasurementMaxInFlight,
+			MaxInFlightPerSubject: constants.DefaultMeasurementMaxInFlightPerSubject,
+		},
+	}
 }
 
 type NatsService struct {
-	Servers []string
-	HostID  string
+	Servers        []string
+	HostID         string
+	RequestTimeout time.Duration // TA_NATS_REQUEST_TIMEOUT
 }
 
 type TrustAgentConfiguration struct {
@@ -51,7 +95,15 @@ type TrustAgentConfiguration struct {
 		Url string // HVS_URL
 	}
 	Tpm struct {
-		TagSecretKey string
+		TagSecretKey     string
+		QueueSize        int           // TA_TPM_QUEUE_SIZE
+		OperationTimeout time.Duration // TA_TPM_OPERATION_TIMEOUT
+
+		AikExpiryCheckInterval time.Duration // TA_AIK_EXPIRY_CHECK_INTERVAL
+		AikExpiryWarningDays   int           // TA_AIK_EXPIRY_WARNING_DAYS
+	}
+	AssetTag struct {
+		SkipHardwareUUIDCheck bool // TA_SKIP_TAG_HARDWARE_UUID_CHECK
 	}
 	AAS struct {
 		BaseURL string // AAS_API_URL
@@ -63,9 +115,14 @@ type TrustAgentConfiguration struct {
 	TLS struct {
 		CertSAN string // SAN_LIST
 		CertCN  string // TA_TLS_CERT_CN
+
+		RenewalToken string // used to renew the TLS certificate from CMS (see 'download-tls-renewal-token')
+		RenewalDays  int    // TA_TLS_CERT_RENEWAL_DAYS
 	}
-	Nats     NatsService
-	ApiToken string
+	Nats       NatsService
+	Metrics    Metrics
+	RateLimits RateLimits
+	ApiToken   string
 }
 
 var mu sync.Mutex
@@ -75,6 +132,11 @@ var secLog = commLog.GetSecurityLogger()
 func NewConfigFromYaml(pathToYaml string) (*TrustAgentConfiguration, error) {
 
 	var c TrustAgentConfiguration
+
+	// the defaults are replaced by the values in config.yml, config files from previous
+	// versions (without 'ratelimits') use the default limits
+	c.RateLimits = DefaultRateLimits()
+
 	file, err := os.Open(pathToYaml)
 	if err == nil {
 		defer func() {
@@ -98,39 +160,62 @@ func NewConfigFromYaml(pathToYaml string) (*TrustAgentConfiguration, error) {
 
 var ErrNoConfigFile = errors.New("no config file")
 
+// Save writes the configuration to a temporary file that is renamed over config.yml, so that
+// the service (or a concurrent 'tagent' command) never reads a partially written file.  The
+// permissions and owner of an existing config.yml are preserved.
 func (cfg *TrustAgentConfiguration) Save() error {
 
 	if cfg.configFile == "" {
 		return ErrNoConfigFile
 	}
 
-	file, err := os.OpenFile(cfg.configFile, os.O_RDWR|os.O_TRUNC, 0)
-	if err != nil {
-		// we have an error
-		if os.IsNotExist(err) {
-			// error is that the config doesnt yet exist, create it
-			file, err = os.OpenFile(cfg.configFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
-			if err != nil {
-				return err
-			}
-			err = os.Chmod(cfg.configFile, 0660)
-			if err != nil {
-				return err
-			}
-		} else {
-			// someother I/O related error
-			return err
+	mode := os.FileMode(0660)
+	uid, gid := -1, -1
+	if info, err := os.Stat(cfg.configFile); err == nil {
+		mode = info.Mode().Perm()
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			uid, gid = int(stat.Uid), int(stat.Gid)
 		}
+	} else if !os.IsNotExist(err) {
+		// someother I/O related error
+		return err
 	}
 
+	file, err := ioutil.TempFile(filepath.Dir(cfg.configFile), "."+filepath.Base(cfg.configFile)+".")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := file.Name()
 	defer func() {
-		derr := file.Close()
-		if derr != nil {
-			log.WithError(derr).Warn("Error closing file")
-		}
+		// removes the temporary file when it was not renamed
+		_ = os.Remove(tmpPath)
 	}()
 
 	err = yaml.NewEncoder(file).Encode(cfg)
+	if err == nil {
+		err = file.Sync()
+	}
+	if derr := file.Close(); err == nil {
+		err = derr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmpPath, mode)
+	if err != nil {
+		return err
+	}
+
+	if uid >= 0 && os.Geteuid() == 0 {
+		err = os.Chown(tmpPath, uid, gid)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.Rename(tmpPath, cfg.configFile)
 	if err != nil {
 		return err
 	}
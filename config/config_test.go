@@ -0,0 +1,56 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewConfigFromYamlDefaultRateLimits(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// a config.yml from a previous version does not contain 'ratelimits'
+	configFile := filepath.Join(tmpDir, "config.yml")
+	err = ioutil.WriteFile(configFile, []byte("mode: http\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := NewConfigFromYaml(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, DefaultRateLimits(), cfg.RateLimits)
+
+	// limits in config.yml (including zeros that disable a limit) replace the defaults
+	err = ioutil.WriteFile(configFile, []byte("ratelimits:\n  quote:\n    requestspersecond: 0\n    maxinflight: 16\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err = NewConfigFromYaml(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0.0, cfg.RateLimits.Quote.RequestsPerSecond)
+	assert.Equal(t, 16, cfg.RateLimits.Quote.MaxInFlight)
+	assert.Equal(t, DefaultRateLimits().ApplicationMeasurement, cfg.RateLimits.ApplicationMeasurement)
+
+	// the defaults are used when config.yml does not exist
+	cfg, err = NewConfigFromYaml(filepath.Join(tmpDir, "does-not-exist.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, DefaultRateLimits(), cfg.RateLimits)
+}
@@ -17,19 +17,23 @@ const (
 	SecurityLogFilePath             = LogDir + "trustagent-security.log"
 	TLSCertFilePath                 = ConfigDir + "tls-cert.pem"
 	TLSKeyFilePath                  = ConfigDir + "tls-key.pem"
+	ClientCertificatePolicyFile     = ConfigDir + "client-certificate-policy.yml"
 	EndorsementCertificateFile      = ConfigDir + "endorsement-certificate.pem"
 	AikCert                         = ConfigDir + "aik.pem"
 	PrivacyCA                       = ConfigDir + "privacy-ca.cer"
+	EndorsementCADir                = ConfigDir + "endorsement-cas/"
 	NatsCredentials                 = ConfigDir + "credentials/trust-agent.creds"
 	VarDir                          = InstallationDir + "var/"
 	RamfsDir                        = VarDir + "ramfs/"
 	SystemInfoDir                   = VarDir + "system-info/"
 	PlatformInfoFilePath            = SystemInfoDir + "platform-info"
 	MeasureLogFilePath              = VarDir + "measure-log.json"
+	AssetTagHistoryFilePath         = VarDir + "asset-tag-history.json"
 	BindingKeyCertificatePath       = "/etc/workload-agent/bindingkey.pem"
 	TBootXmMeasurePath              = "/opt/tbootxm/bin/measure"
 	DevMemFilePath                  = "/dev/mem"
 	Tpm2FilePath                    = "/sys/firmware/acpi/tables/TPM2"
+	TpmResourceManagerFilePath      = "/dev/tpmrm0"
 	AppEventFilePath                = RamfsDir + "pcr_event_log"
 	RootUserName                    = "root"
 	TagentUserName                  = "tagent"
@@ -60,14 +64,41 @@ const (
 	DefaultReadHeaderTimeout        = 10 * time.Second
 	DefaultWriteTimeout             = 10 * time.Second
 	DefaultIdleTimeout              = 10 * time.Second
+	DefaultRequestTimeout           = 30 * time.Second
+	DefaultNatsRequestTimeout       = 30 * time.Second
 	DefaultMaxHeaderBytes           = 1 << 20
 	AikSecretKeyFile                = ConfigDir + "aiksecretkey"
-	TagIndexSize                    = 48 // size of sha384 hash
+	TagIndexSize                    = 67 // version, algorithm and (up to sha512) digest, see util.AssetTagIndex
+	TagIndexVersion                 = 1
+	LegacyTagIndexSize              = 48 // size of sha384 hash (v4.0 tag index)
 	CommunicationModeHttp           = "http"
 	CommunicationModeOutbound       = "outbound"
 	DefaultApiTokenExpiration       = 31536000
 	DefaultAsyncReportRetryInterval = 5
 	VerificationServiceName         = "HVS"
+	CertificateServiceName          = "CMS"
+	CertApproverRoleName            = "CertApprover"
+	DefaultTpmQueueSize             = 16
+	DefaultTpmOperationTimeout      = 30 * time.Second
+	PcrCacheTTL                     = 10 * time.Second
+	HealthCheckCacheTTL             = 5 * time.Second
+	HealthCheckTpmTimeout           = 5 * time.Second // less than the readiness probe's timeoutSeconds (dist/k8s)
+	RateLimitCleanupThreshold       = 1024
+	DefaultAikExpiryCheckInterval   = 24 * time.Hour
+	DefaultAikExpiryWarningDays     = 30
+	TLSCertReloadInterval           = time.Minute
+	TLSCertRenewalCheckInterval     = time.Hour
+	DefaultTLSCertRenewalDays       = 30
+	MetricsNamespace                = "trustagent"
+	MetricsCertificateExpiry        = "certificate_expiry_timestamp_seconds"
+	MetricsTLSRenewalTokenExpiry    = "tls_renewal_token_expiry_timestamp_seconds"
+)
+
+// Authentication of web service requests (TA_AUTH_MODE)
+const (
+	AuthModeJWT     = "jwt"  // bearer tokens from AAS
+	AuthModeMTLS    = "mtls" // client certificates issued by a CA in TrustedCaCertsDir
+	DefaultAuthMode = AuthModeJWT
 )
 
 // Env Variables
@@ -89,10 +120,63 @@ const (
 	EnvTAServerReadHeaderTimeout = "TA_SERVER_READ_HEADER_TIMEOUT"
 	EnvTAServerWriteTimeout      = "TA_SERVER_WRITE_TIMEOUT"
 	EnvTAServerIdleTimeout       = "TA_SERVER_IDLE_TIMEOUT"
+	EnvTAServerRequestTimeout    = "TA_SERVER_REQUEST_TIMEOUT"
+	EnvNatsRequestTimeout        = "TA_NATS_REQUEST_TIMEOUT"
+	EnvMetricsPort               = "TA_METRICS_PORT"
 	EnvTAServerMaxHeaderBytes    = "TA_SERVER_MAX_HEADER_BYTES"
 	EnvTAServiceMode             = "TA_SERVICE_MODE"
 	EnvNATServers                = "NATS_SERVERS"
 	EnvTAHostId                  = "TA_HOST_ID"
+	EnvTpmQueueSize              = "TA_TPM_QUEUE_SIZE"
+	EnvTpmOperationTimeout       = "TA_TPM_OPERATION_TIMEOUT"
+	EnvSkipTagHardwareUUIDCheck  = "TA_SKIP_TAG_HARDWARE_UUID_CHECK"
+	EnvAikExpiryCheckInterval    = "TA_AIK_EXPIRY_CHECK_INTERVAL"
+	EnvAikExpiryWarningDays      = "TA_AIK_EXPIRY_WARNING_DAYS"
+	EnvVerifyEkChain             = "TA_VERIFY_EK_CHAIN"
+	EnvForceAikRenewal           = "TA_FORCE_AIK_RENEWAL"
+	EnvTLSCertRenewalDays        = "TA_TLS_CERT_RENEWAL_DAYS"
+	EnvAuthMode                  = "TA_AUTH_MODE"
+)
+
+// Rate limit env variables (see config.RateLimits)
+const (
+	EnvQuoteRateLimit                   = "TA_QUOTE_RATE_LIMIT"
+	EnvQuoteRateBurst                   = "TA_QUOTE_RATE_BURST"
+	EnvQuoteMaxInFlight                 = "TA_QUOTE_MAX_IN_FLIGHT"
+	EnvQuoteMaxInFlightPerSubject       = "TA_QUOTE_MAX_IN_FLIGHT_PER_SUBJECT"
+	EnvMeasurementRateLimit             = "TA_MEASUREMENT_RATE_LIMIT"
+	EnvMeasurementRateBurst             = "TA_MEASUREMENT_RATE_BURST"
+	EnvMeasurementMaxInFlight           = "TA_MEASUREMENT_MAX_IN_FLIGHT"
+	EnvMeasurementMaxInFlightPerSubject = "TA_MEASUREMENT_MAX_IN_FLIGHT_PER_SUBJECT"
+	EnvDefaultRateLimit                 = "TA_RATE_LIMIT"
+	EnvDefaultRateBurst                 = "TA_RATE_BURST"
+	EnvDefaultMaxInFlight               = "TA_MAX_IN_FLIGHT"
+	EnvDefaultMaxInFlightPerSubject     = "TA_MAX_IN_FLIGHT_PER_SUBJECT"
+)
+
+// Default rate limits of the quote and application-measurement routes (other routes are
+// not limited by default, see config.RateLimits)
+const (
+	DefaultQuoteRateLimit                   = 5.0
+	DefaultQuoteRateBurst                   = 10
+	DefaultQuoteMaxInFlight                 = 8
+	DefaultQuoteMaxInFlightPerSubject       = 4
+	DefaultMeasurementRateLimit             = 1.0
+	DefaultMeasurementRateBurst             = 2
+	DefaultMeasurementMaxInFlight           = 2
+	DefaultMeasurementMaxInFlightPerSubject = 1
+)
+
+// NATS subjects (see taModel.CreateSubject) that are not defined in intel-secl/pkg/model/ta
+const (
+	NatsAikCaRequest = "aik-ca-request"
+	NatsReadyRequest = "ready-request"
+)
+
+// Headers of NATS error replies (the body is empty)
+const (
+	NatsStatusHeader    = "Status"
+	NatsErrorCodeHeader = "Error-Code"
 )
 
 // "TODO" comment -- the SHA constants should live in intel-secl/pkg/model/
@@ -32,6 +32,7 @@ import (
 	_ "intel/isecl/go-trust-agent/v4/swagger/docs"
 	"intel/isecl/go-trust-agent/v4/tasks"
 	"intel/isecl/go-trust-agent/v4/util"
+	"intel/isecl/lib/tpmprovider/v4"
 	"net/http"
 	"os"
 	"os/exec"
@@ -72,6 +73,16 @@ Available Commands:
   stop                             Stop the trust agent service.
   status                           Get the status of the trust agent service.
   fetch-ekcert-with-issuer         Print Tpm Endorsement Certificate in Base64 encoded string along with issuer
+  ekcert [--json]                  Print the RSA/ECC EK certificates and their issuing chains (EK certificate first) in PEM
+                                   format, or as json when '--json' is provided.
+  ekcert --verify [--json]         Verify the EK certificate chains against the TPM manufacturer CAs in
+                                   /opt/trustagent/configuration/endorsement-cas/ and print the TPM vendor, model and
+                                   chain status.  Exits with 1 when a chain is not trusted.
+  tag show                         Print the asset tag, whether it is provisioned and the size, attributes and
+                                   auth policy of its nv index.
+  tag clear                        Clear (zero) the asset tag so that the host is no longer tag provisioned.
+  quote --verify                   Create a quote with a random nonce and verify it locally (AIK signature, nonce,
+                                   pcr digest and event log replay).
 
 Setup command usage:  tagent setup [cmd] [-f <env-file>]
 
@@ -91,6 +102,17 @@ Available Tasks for 'setup', all commands support env file flag
                                                   - TA_SERVER_READ_TIMEOUT=<t seconds>                : Sets trust agent service's read timeout.  Defaults to 30 seconds.
                                                   - TA_SERVER_READ_HEADER_TIMEOUT=<t seconds>         : Sets trust agent service's read header timeout.  Defaults to 30 seconds.
                                                   - TA_SERVER_WRITE_TIMEOUT=<t seconds>               : Sets trust agent service's write timeout.  Defaults to 10 seconds.
+                                                  - TA_SERVER_REQUEST_TIMEOUT=<t seconds>             : Sets the deadline of http requests (TPM operations and 'measure' are
+                                                                                                        cancelled when it expires).  Defaults to 30 seconds.
+                                                  - TA_NATS_REQUEST_TIMEOUT=<t seconds>               : Sets the deadline of NATS requests in outbound mode.  Defaults to 30 seconds.
+                                                  - TA_METRICS_PORT=<port>                            : When set, metrics are also served (without authentication) at
+                                                                                                        http://127.0.0.1:<port>/metrics.  Not set by default.
+                                                  - TA_QUOTE_RATE_LIMIT=<requests/second>             : Rate limits of /tpm/quote for each caller (see TA_QUOTE_RATE_BURST,
+                                                                                                        TA_QUOTE_MAX_IN_FLIGHT and TA_QUOTE_MAX_IN_FLIGHT_PER_SUBJECT).  Defaults to 5.
+                                                  - TA_MEASUREMENT_RATE_LIMIT=<requests/second>       : Rate limits of /host/application-measurement (see TA_MEASUREMENT_RATE_BURST,
+                                                                                                        TA_MEASUREMENT_MAX_IN_FLIGHT and TA_MEASUREMENT_MAX_IN_FLIGHT_PER_SUBJECT).  Defaults to 1.
+                                                  - TA_RATE_LIMIT=<requests/second>                   : Rate limits of the other routes (see TA_RATE_BURST, TA_MAX_IN_FLIGHT
+                                                                                                        and TA_MAX_IN_FLIGHT_PER_SUBJECT).  Defaults to 0 (no limit).
                                                   - SAN_LIST=<host1,host2.acme.com,...>               : CSV list that sets the value for SAN list in the TA TLS certificate.
                                                                                                         Defaults to "127.0.0.1,localhost".
                                                   - TA_TLS_CERT_CN=<Common Name>                      : Sets the value for Common Name in the TA TLS certificate.  Defaults to "Trust Agent TLS Certificate".
@@ -99,6 +121,18 @@ Available Tasks for 'setup', all commands support env file flag
                                                   - TRUSTAGENT_LOG_LEVEL=<trace|debug|info|error>     : Sets the verbosity level of logging. Defaults to 'info'.
                                                   - TRUSTAGENT_PORT=<portnum>                         : The port on which the trust agent service will listen.
                                                                                                         Defaults to 1443
+                                                  - TA_TPM_QUEUE_SIZE=<n requests>                    : Sets the number of requests that can wait for the TPM before
+                                                                                                        the service responds with 503.  Defaults to 16.
+                                                  - TA_TPM_OPERATION_TIMEOUT=<t seconds>              : Sets how long a request waits for the TPM.  Defaults to 30 seconds.
+                                                  - TA_AIK_EXPIRY_CHECK_INTERVAL=<t hours>            : How often the service checks whether the AIK certificate is
+                                                                                                        about to expire (0 disables the check).  Defaults to 24 hours.
+                                                  - TA_AIK_EXPIRY_WARNING_DAYS=<n days>               : Warn when the AIK certificate expires within 'n' days.
+                                                                                                        Defaults to 30 days.
+                                                  - TA_AUTH_MODE=<jwt|mtls>                           : 'mtls' authenticates requests with client certificates issued by the CAs
+                                                                                                        in cacerts/ instead of AAS tokens (see client-certificate-policy.yml).
+                                                                                                        Defaults to 'jwt'.
+                                                  - TA_TLS_CERT_RENEWAL_DAYS=<n days>                 : Renew the TLS certificate from CMS when it expires within 'n' days
+                                                                                                        (0 disables automatic renewal).  Defaults to 30 days.
 
   download-ca-cert                          - Fetches the latest CMS Root CA Certificates, overwriting existing files.
                                                     Required environment variables:
@@ -123,7 +157,14 @@ Available Tasks for 'setup', all commands support env file flag
                                                     Required environment variables:
                                                        - BEARER_TOKEN=<token>                              : for authenticating with AAS
                                                        - AAS_API_URL=<url>                                 : AAS API URL
-  update-certificates                       - Runs 'download-ca-cert' and 'download-cert'
+  download-tls-renewal-token                - Fetches a token from AAS that allows the service to renew its TLS certificate from CMS
+                                              before it expires (see TA_TLS_CERT_RENEWAL_DAYS).  The token is valid for one year
+                                              and is not refreshed: run the task again and restart the service before it expires.
+                                                    Required environment variables:
+                                                       - BEARER_TOKEN=<token>                              : for authenticating with AAS
+                                                       - AAS_API_URL=<url>                                 : AAS API URL
+  update-certificates                       - Runs 'download-ca-cert' and 'download-cert'.  The running service uses the new TLS certificate
+                                              within a minute (or immediately after 'systemctl reload tagent').
                                                     Required environment variables:
                                                        - CMS_BASE_URL=<url>                                : CMS API URL
                                                        - CMS_TLS_CERT_SHA384=<CMS TLS cert sha384 hash>    : to ensure that TA is communicating with the right CMS instance
@@ -140,6 +181,19 @@ Available Tasks for 'setup', all commands support env file flag
                                                     Optional environment variables:
                                                         - TPM_OWNER_SECRET=<40 byte hex>                    : When provided, setup uses the 40 character hex string for the TPM
                                                                                                               owner password. Auto-generated when not provided.
+                                                        - TA_VERIFY_EK_CHAIN=<true/false>                   : When 'true', fail before contacting HVS if the EK certificate chain is
+                                                                                                              not trusted by the CAs in endorsement-cas/.  Defaults to false.
+
+  renew-aik                                 - Replaces the AIK (and aik.pem) with a new key from the privacy-ca.  The current AIK
+                                              is evicted before HVS certifies the new key, so quote requests fail with 503 until
+                                              the new certificate is installed.
+                                                    Required environment variables:
+                                                        - HVS_URL=<url>                            : VS API URL
+                                                        - BEARER_TOKEN=<token>                              : for authenticating with VS
+                                                        - TA_FORCE_AIK_RENEWAL=true                         : Confirms that attestation may be interrupted
+                                                                                                              (the task refuses to run otherwise).
+                                                    Optional environment variables:
+                                                        - TPM_OWNER_SECRET=<40 byte hex>                    : The TPM owner password.
 
   create-host                                 - Registers the trust agent with the verification service.
                                                     Required environment variables:
@@ -171,9 +225,23 @@ Available Tasks for 'setup', all commands support env file flag
                                                         - TA_SERVER_WRITE_TIMEOUT                           : Tustagent Write Timeout                                                   
                                                         - TA_SERVER_IDLE_TIMEOUT                            : Trustagent Idle Timeout                                                    
                                                         - TA_SERVER_MAX_HEADER_BYTES                        : Trustagent Max Header Bytes Timeout                                                    
+                                                        - TA_SERVER_REQUEST_TIMEOUT                         : Trustagent Request Timeout
+                                                        - TA_NATS_REQUEST_TIMEOUT                           : Trustagent NATS Request Timeout
+                                                        - TA_METRICS_PORT                                   : Trustagent Metrics Port
+                                                        - TA_QUOTE_RATE_LIMIT, TA_MEASUREMENT_RATE_LIMIT,
+                                                          TA_RATE_LIMIT (and *_RATE_BURST, *_MAX_IN_FLIGHT,
+                                                          *_MAX_IN_FLIGHT_PER_SUBJECT)                      : Trustagent Rate Limits
                                                         - TRUSTAGENT_LOG_LEVEL                              : Logging Level                                                    
                                                         - TA_ENABLE_CONSOLE_LOG                             : Trustagent Enable standard output                                                    
                                                         - LOG_ENTRY_MAXLENGTH                               : Maximum length of each entry in a log
+                                                        - TA_TPM_QUEUE_SIZE                                 : Trustagent TPM Request Queue Size
+                                                        - TA_TPM_OPERATION_TIMEOUT                          : Trustagent TPM Operation Timeout
+                                                        - TA_AIK_EXPIRY_CHECK_INTERVAL                      : Trustagent AIK Expiry Check Interval
+                                                        - TA_AIK_EXPIRY_WARNING_DAYS                        : Trustagent AIK Expiry Warning Days
+                                                        - TA_TLS_CERT_RENEWAL_DAYS                          : Trustagent TLS Certificate Renewal Days
+                                                        - TA_AUTH_MODE                                      : Trustagent Authentication Mode ('jwt' or 'mtls')
+                                                        - TA_SKIP_TAG_HARDWARE_UUID_CHECK                   : When 'true', asset tags are deployed without comparing the request's
+                                                                                                              hardware_uuid to the host's (legacy behavior).  Defaults to false.
   define-tag-index                          - Allocates nvram in the TPM for use by asset tags.`
 
 	fmt.Println(usage)
@@ -518,21 +586,35 @@ func main() {
 
 		cfg.LogConfiguration(cfg.Logging.LogEnableStdout)
 
+		requestHandler, err := common.NewRequestHandler(cfg)
+		if err != nil {
+			log.WithError(err).Info("Failed to create request handler")
+			os.Exit(1)
+		}
+
 		serviceParameters := service.ServiceParameters{
 			Mode: cfg.Mode,
 			Web: service.WebParameters{
-				WebService:                cfg.WebService,
-				TLSCertFilePath:           constants.TLSCertFilePath,
-				TLSKeyFilePath:            constants.TLSKeyFilePath,
-				TrustedJWTSigningCertsDir: constants.TrustedJWTSigningCertsDir,
-				TrustedCaCertsDir:         constants.TrustedCaCertsDir,
+				WebService:                  cfg.WebService,
+				TLSCertFilePath:             constants.TLSCertFilePath,
+				TLSKeyFilePath:              constants.TLSKeyFilePath,
+				TrustedJWTSigningCertsDir:   constants.TrustedJWTSigningCertsDir,
+				TrustedCaCertsDir:           constants.TrustedCaCertsDir,
+				ClientCertificatePolicyFile: constants.ClientCertificatePolicyFile,
+				CmsBaseURL:                  cfg.CMS.BaseURL,
+				TLSCertCN:                   cfg.TLS.CertCN,
+				TLSCertSAN:                  cfg.TLS.CertSAN,
+				TLSRenewalToken:             cfg.TLS.RenewalToken,
+				TLSCertRenewalDays:          cfg.TLS.RenewalDays,
 			},
 			Nats: service.NatsParameters{
 				NatsService:       cfg.Nats,
 				CredentialFile:    constants.NatsCredentials,
 				TrustedCaCertsDir: constants.TrustedCaCertsDir,
 			},
-			RequestHandler: common.NewRequestHandler(cfg),
+			Metrics:        cfg.Metrics,
+			RateLimits:     cfg.RateLimits,
+			RequestHandler: requestHandler,
 		}
 
 		trustAgentService, err := service.NewTrustAgentService(&serviceParameters)
@@ -541,13 +623,17 @@ func main() {
 			os.Exit(1)
 		}
 
-		// Setup signal handlers to terminate service
-		stop := make(chan os.Signal)
-		signal.Notify(stop, syscall.SIGINT, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGKILL)
+		// Setup signal handlers to terminate service (SIGINT/SIGTERM) and to reload it (SIGHUP,
+		// ex. 'systemctl reload tagent')
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 		err = trustAgentService.Start()
 		if err != nil {
 			log.WithError(err).Info("Failed to start service")
-			stop <- syscall.SIGTERM
+			select {
+			case stop <- syscall.SIGTERM:
+			default: // a signal is already pending
+			}
 		}
 
 		err = sendAsyncReportRequest(cfg)
@@ -555,7 +641,22 @@ func main() {
 			asyncReportCreateRetry(cfg)
 		}
 
-		<-stop
+		if cfg.Tpm.AikExpiryCheckInterval > 0 {
+			stopAikExpiryCheck := common.StartAikExpiryCheck(cfg.Tpm.AikExpiryCheckInterval, time.Duration(cfg.Tpm.AikExpiryWarningDays)*24*time.Hour)
+			defer stopAikExpiryCheck()
+		}
+
+		for sig := range stop {
+			if sig != syscall.SIGHUP {
+				break
+			}
+
+			log.Info("Received SIGHUP, reloading service")
+			if err := trustAgentService.Reload(); err != nil {
+				log.WithError(err).Error("Failed to reload service")
+			}
+		}
+
 		if err := trustAgentService.Stop(); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to shutdown service: %v\n", err)
 			log.WithError(err).Info("Failed to shutdown service")
@@ -667,6 +768,99 @@ func main() {
 			fmt.Fprintf(os.Stderr, "main:main() Error while running trustagent fetch-ekcert-with-issuer %s\n", err.Error())
 			os.Exit(1)
 		}
+	case "ekcert":
+
+		if currentUser.Username != constants.RootUserName {
+			fmt.Printf("'tagent ekcert' must be run as root, not user '%s'\n", currentUser.Username)
+			os.Exit(1)
+		}
+
+		jsonOutput := false
+		verify := false
+		for _, arg := range os.Args[2:] {
+			switch arg {
+			case "--json":
+				jsonOutput = true
+			case "--verify":
+				verify = true
+			default:
+				fmt.Fprintf(os.Stderr, "Invalid arguments: %s\n", os.Args)
+				printUsage()
+				os.Exit(1)
+			}
+		}
+
+		if verify {
+			trusted, err := printEndorsementKeyReports(cfg, jsonOutput)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "main:main() Error while running trustagent ekcert --verify %+v\n", err)
+				os.Exit(1)
+			}
+
+			if !trusted {
+				os.Exit(1)
+			}
+			break
+		}
+
+		err = printEndorsementKeyCertificates(cfg, jsonOutput)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "main:main() Error while running trustagent ekcert %+v\n", err)
+			os.Exit(1)
+		}
+
+	case "tag":
+
+		if currentUser.Username != constants.RootUserName {
+			fmt.Printf("'tagent tag' must be run as root, not user '%s'\n", currentUser.Username)
+			os.Exit(1)
+		}
+
+		if len(os.Args) != 3 {
+			fmt.Fprintf(os.Stderr, "Invalid arguments: %s\n", os.Args)
+			printUsage()
+			os.Exit(1)
+		}
+
+		switch os.Args[2] {
+		case "show":
+			err = showAssetTag(cfg)
+		case "clear":
+			err = clearAssetTag(cfg, "cli:"+currentUser.Username)
+		default:
+			fmt.Fprintf(os.Stderr, "Invalid option: 'tag %s'\n\n", os.Args[2])
+			printUsage()
+			os.Exit(1)
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "main:main() Error while running trustagent tag %s %+v\n", os.Args[2], err)
+			os.Exit(1)
+		}
+
+	case "quote":
+
+		if currentUser.Username != constants.RootUserName {
+			fmt.Printf("'tagent quote' must be run as root, not user '%s'\n", currentUser.Username)
+			os.Exit(1)
+		}
+
+		if len(os.Args) < 3 || os.Args[2] != "--verify" {
+			fmt.Fprintf(os.Stderr, "Invalid arguments: %s\n", os.Args)
+			printUsage()
+			os.Exit(1)
+		}
+
+		passed, err := verifyQuote(cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "main:main() Error while running trustagent quote --verify %+v\n", err)
+			os.Exit(1)
+		}
+
+		if !passed {
+			os.Exit(1)
+		}
+
 	case "uninstall":
 		err = uninstall()
 		if err != nil {
@@ -736,6 +930,195 @@ func fetchEndorsementCert(assetTagSecret string) error {
 	return nil
 }
 
+// printEndorsementKeyCertificates prints the EK certificate chains as PEM (with the
+// EK certificate first) or json.
+func printEndorsementKeyCertificates(cfg *config.TrustAgentConfiguration, jsonOutput bool) error {
+	log.Trace("main:printEndorsementKeyCertificates() Entering")
+	defer log.Trace("main:printEndorsementKeyCertificates() Leaving")
+
+	tpmFactory, err := tpmprovider.NewTpmFactory()
+	if err != nil {
+		return errors.Wrap(err, "Could not create tpm factory")
+	}
+
+	tpm, err := tpmFactory.NewTpmProvider()
+	if err != nil {
+		return errors.Wrap(err, "Error creating tpm provider")
+	}
+	defer tpm.Close()
+
+	ekCertificates, err := common.ReadEndorsementKeyCertificates(cfg.Tpm.TagSecretKey, tpm)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		ekCertificatesJSON, err := json.MarshalIndent(ekCertificates, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "Error marshaling ek certificates")
+		}
+
+		fmt.Println(string(ekCertificatesJSON))
+		return nil
+	}
+
+	for _, ekCertificate := range ekCertificates {
+		for _, cert := range ekCertificate.Certificates {
+			err = pem.Encode(os.Stdout, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+			if err != nil {
+				return errors.Wrap(err, "Could not pem encode cert")
+			}
+		}
+	}
+
+	return nil
+}
+
+// printEndorsementKeyReports verifies the EK certificate chains against the TPM manufacturer
+// CAs in constants.EndorsementCADir and prints the TPM's vendor/model and the status of each
+// chain.  Returns false if any chain is not trusted.
+func printEndorsementKeyReports(cfg *config.TrustAgentConfiguration, jsonOutput bool) (bool, error) {
+	log.Trace("main:printEndorsementKeyReports() Entering")
+	defer log.Trace("main:printEndorsementKeyReports() Leaving")
+
+	tpmFactory, err := tpmprovider.NewTpmFactory()
+	if err != nil {
+		return false, errors.Wrap(err, "Could not create tpm factory")
+	}
+
+	tpm, err := tpmFactory.NewTpmProvider()
+	if err != nil {
+		return false, errors.Wrap(err, "Error creating tpm provider")
+	}
+	defer tpm.Close()
+
+	reports, err := util.CreateEndorsementKeyReports(tpm, cfg.Tpm.TagSecretKey, constants.EndorsementCADir)
+	if err != nil {
+		return false, err
+	}
+
+	trusted := true
+	for _, report := range reports {
+		trusted = trusted && report.Trusted
+	}
+
+	if jsonOutput {
+		reportsJSON, err := json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			return false, errors.Wrap(err, "Error marshaling ek reports")
+		}
+
+		fmt.Println(string(reportsJSON))
+		return trusted, nil
+	}
+
+	for _, report := range reports {
+		fmt.Printf("%s EK\n", report.KeyType)
+		fmt.Printf("  Vendor:           %s (%s)\n", report.Manufacturer, report.ManufacturerID)
+		fmt.Printf("  Model:            %s\n", report.Model)
+		fmt.Printf("  Firmware Version: %s\n", report.FirmwareVersion)
+		fmt.Printf("  Chain Length:     %d\n", report.ChainLength)
+		if report.Trusted {
+			fmt.Printf("  Trust Anchor:     %s\n", report.TrustAnchor)
+		}
+		fmt.Printf("  Status:           %s\n", report.Status)
+	}
+
+	return trusted, nil
+}
+
+func showAssetTag(cfg *config.TrustAgentConfiguration) error {
+	log.Trace("main:showAssetTag() Entering")
+	defer log.Trace("main:showAssetTag() Leaving")
+
+	tpmFactory, err := tpmprovider.NewTpmFactory()
+	if err != nil {
+		return errors.Wrap(err, "Could not create tpm factory")
+	}
+
+	tpm, err := tpmFactory.NewTpmProvider()
+	if err != nil {
+		return errors.Wrap(err, "Error creating tpm provider")
+	}
+	defer tpm.Close()
+
+	assetTag, err := common.ReadAssetTag(cfg.Tpm.TagSecretKey, tpm)
+	if err != nil {
+		return err
+	}
+
+	assetTagJSON, err := json.MarshalIndent(assetTag, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "Error marshaling asset tag")
+	}
+
+	fmt.Println(string(assetTagJSON))
+	return nil
+}
+
+func clearAssetTag(cfg *config.TrustAgentConfiguration, caller string) error {
+	log.Trace("main:clearAssetTag() Entering")
+	defer log.Trace("main:clearAssetTag() Leaving")
+
+	tpmFactory, err := tpmprovider.NewTpmFactory()
+	if err != nil {
+		return errors.Wrap(err, "Could not create tpm factory")
+	}
+
+	tpm, err := tpmFactory.NewTpmProvider()
+	if err != nil {
+		return errors.Wrap(err, "Error creating tpm provider")
+	}
+	defer tpm.Close()
+
+	err = common.ClearAssetTag(cfg.Tpm.TagSecretKey, tpm, caller)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("The asset tag was cleared")
+	return nil
+}
+
+// verifyQuote prints the results of common.VerifyTpmQuote and returns true when all of
+// the checks passed.
+func verifyQuote(cfg *config.TrustAgentConfiguration) (bool, error) {
+	log.Trace("main:verifyQuote() Entering")
+	defer log.Trace("main:verifyQuote() Leaving")
+
+	tpmFactory, err := tpmprovider.NewTpmFactory()
+	if err != nil {
+		return false, errors.Wrap(err, "Could not create tpm factory")
+	}
+
+	tpm, err := tpmFactory.NewTpmProvider()
+	if err != nil {
+		return false, errors.Wrap(err, "Error creating tpm provider")
+	}
+	defer tpm.Close()
+
+	report, err := common.VerifyTpmQuote(cfg, tpm)
+	if err != nil {
+		return false, err
+	}
+
+	for _, check := range report.Checks {
+		result := "PASS"
+		if !check.Passed {
+			result = "FAIL"
+		}
+		fmt.Printf("%-5s %-18s %s\n", result, check.Name, check.Message)
+	}
+
+	if report.Passed() {
+		fmt.Println("Quote verification passed")
+	} else {
+		fmt.Println("Quote verification failed")
+	}
+
+	return report.Passed(), nil
+}
+
 func sendAsyncReportRequest(cfg *config.TrustAgentConfiguration) error {
 	log.Trace("main:sendAsyncReportRequest() Entering")
 	defer log.Trace("main:sendAsyncReportRequest() Leaving")
@@ -11,11 +11,13 @@ import (
 	"net/http"
 
 	"github.com/intel-secl/intel-secl/v4/pkg/lib/common/log/message"
+	"github.com/pkg/errors"
 )
 
 func getAik(requestHandler common.RequestHandler) endpointHandler {
 
 	return func(httpWriter http.ResponseWriter, httpRequest *http.Request) error {
+		log := common.GetLogger(httpRequest.Context())
 		log.Trace("resource/aik:getAik() Entering")
 		defer log.Trace("resource/aik:getAik() Leaving")
 
@@ -28,8 +30,11 @@ func getAik(requestHandler common.RequestHandler) endpointHandler {
 			return &common.EndpointError{Message: "Invalid content-type", StatusCode: http.StatusBadRequest}
 		}
 
-		aikDer, err := requestHandler.GetAikDerBytes()
-		if err != nil {
+		aikDer, err := requestHandler.GetAikDerBytes(httpRequest.Context())
+		if endpointError, ok := errors.Cause(err).(*common.EndpointError); ok {
+			log.WithError(err).Errorf("resource/aik:getAik() %s - Error reading %s", message.AppRuntimeErr, constants.AikCert)
+			return endpointError
+		} else if err != nil {
 			log.WithError(err).Errorf("resource/aik:getAik() %s - There was an error reading %s", message.AppRuntimeErr, constants.AikCert)
 			return &common.EndpointError{Message: "Unable to fetch AIK certificate", StatusCode: http.StatusInternalServerError}
 		}
@@ -39,3 +44,29 @@ func getAik(requestHandler common.RequestHandler) endpointHandler {
 		return nil
 	}
 }
+
+// getAikCa returns the (der encoded) privacy-ca certificate that issued the AIK, so that
+// verifiers can retrieve the AIK's trust chain from the Trust-Agent.
+func getAikCa(requestHandler common.RequestHandler) endpointHandler {
+	return func(httpWriter http.ResponseWriter, httpRequest *http.Request) error {
+		log := common.GetLogger(httpRequest.Context())
+		log.Trace("resource/aik:getAikCa() Entering")
+		defer log.Trace("resource/aik:getAikCa() Leaving")
+
+		log.Debugf("resource/aik:getAikCa() Request: %s", httpRequest.URL.Path)
+
+		aikCaDer, err := requestHandler.GetAikCaDerBytes(httpRequest.Context())
+		if endpointError, ok := errors.Cause(err).(*common.EndpointError); ok {
+			log.WithError(err).Errorf("resource/aik:getAikCa() %s - Error reading %s", message.AppRuntimeErr, constants.PrivacyCA)
+			return endpointError
+		} else if err != nil {
+			log.WithError(err).Errorf("resource/aik:getAikCa() %s - There was an error reading %s", message.AppRuntimeErr, constants.PrivacyCA)
+			return &common.EndpointError{Message: "Unable to fetch AIK CA certificate", StatusCode: http.StatusInternalServerError}
+		}
+
+		httpWriter.Header().Set("Content-Type", "application/octet-stream")
+		httpWriter.WriteHeader(http.StatusOK)
+		_, _ = bytes.NewBuffer(aikCaDer).WriteTo(httpWriter)
+		return nil
+	}
+}
@@ -13,6 +13,7 @@ import (
 
 	"github.com/intel-secl/intel-secl/v4/pkg/lib/common/log/message"
 	taModel "github.com/intel-secl/intel-secl/v4/pkg/model/ta"
+	"github.com/pkg/errors"
 )
 
 //
@@ -23,6 +24,8 @@ import (
 //
 func setAssetTag(requestHandler common.RequestHandler) endpointHandler {
 	return func(httpWriter http.ResponseWriter, httpRequest *http.Request) error {
+		log := common.GetLogger(httpRequest.Context())
+		secLog := common.GetSecurityLogger(httpRequest.Context())
 		log.Trace("resource/asset_tag:setAssetTag() Entering")
 		defer log.Trace("resource/asset_tag:setAssetTag() Leaving")
 
@@ -50,7 +53,7 @@ func setAssetTag(requestHandler common.RequestHandler) endpointHandler {
 			return &common.EndpointError{Message: "Error processing request", StatusCode: http.StatusBadRequest}
 		}
 
-		err = requestHandler.DeployAssetTag(&tagWriteRequest)
+		err = requestHandler.DeployAssetTag(httpRequest.Context(), &tagWriteRequest, getCaller(httpRequest))
 		if err != nil {
 			log.WithError(err).Errorf("resource/asset_tag:setAssetTag() %s - Error while deploying asset tag", message.AppRuntimeErr)
 			return err
@@ -59,3 +62,84 @@ func setAssetTag(requestHandler common.RequestHandler) endpointHandler {
 		return nil
 	}
 }
+
+// getAssetTag returns the current asset tag, whether it is provisioned and the
+// attributes of its nv index.
+func getAssetTag(requestHandler common.RequestHandler) endpointHandler {
+	return func(httpWriter http.ResponseWriter, httpRequest *http.Request) error {
+		log := common.GetLogger(httpRequest.Context())
+		log.Trace("resource/asset_tag:getAssetTag() Entering")
+		defer log.Trace("resource/asset_tag:getAssetTag() Leaving")
+
+		log.Debugf("resource/asset_tag:getAssetTag() Request: %s", httpRequest.URL.Path)
+
+		assetTag, err := requestHandler.GetAssetTag(httpRequest.Context())
+		if endpointError, ok := errors.Cause(err).(*common.EndpointError); ok {
+			log.WithError(err).Errorf("resource/asset_tag:getAssetTag() %s - Error reading asset tag", message.AppRuntimeErr)
+			return endpointError
+		} else if err != nil {
+			log.WithError(err).Errorf("resource/asset_tag:getAssetTag() %s - Error reading asset tag", message.AppRuntimeErr)
+			return &common.EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError}
+		}
+
+		assetTagJSON, err := json.Marshal(assetTag)
+		if err != nil {
+			log.WithError(err).Errorf("resource/asset_tag:getAssetTag() %s - There was an error marshaling the asset tag", message.AppRuntimeErr)
+			return &common.EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError}
+		}
+
+		httpWriter.Header().Set("Content-Type", "application/json")
+		httpWriter.WriteHeader(http.StatusOK)
+		_, _ = bytes.NewBuffer(assetTagJSON).WriteTo(httpWriter)
+		return nil
+	}
+}
+
+// clearAssetTag zeros the asset tag nv index so that the host is no longer tag
+// provisioned.
+func clearAssetTag(requestHandler common.RequestHandler) endpointHandler {
+	return func(httpWriter http.ResponseWriter, httpRequest *http.Request) error {
+		log := common.GetLogger(httpRequest.Context())
+		log.Trace("resource/asset_tag:clearAssetTag() Entering")
+		defer log.Trace("resource/asset_tag:clearAssetTag() Leaving")
+
+		log.Debugf("resource/asset_tag:clearAssetTag() Request: %s", httpRequest.URL.Path)
+
+		err := requestHandler.ClearAssetTag(httpRequest.Context(), getCaller(httpRequest))
+		if err != nil {
+			log.WithError(err).Errorf("resource/asset_tag:clearAssetTag() %s - Error while clearing asset tag", message.AppRuntimeErr)
+			return err
+		}
+
+		httpWriter.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
+
+// getAssetTagHistory returns the asset tag audit trail (oldest first).
+func getAssetTagHistory(requestHandler common.RequestHandler) endpointHandler {
+	return func(httpWriter http.ResponseWriter, httpRequest *http.Request) error {
+		log := common.GetLogger(httpRequest.Context())
+		log.Trace("resource/asset_tag:getAssetTagHistory() Entering")
+		defer log.Trace("resource/asset_tag:getAssetTagHistory() Leaving")
+
+		log.Debugf("resource/asset_tag:getAssetTagHistory() Request: %s", httpRequest.URL.Path)
+
+		history, err := requestHandler.GetAssetTagHistory(httpRequest.Context())
+		if err != nil {
+			log.WithError(err).Errorf("resource/asset_tag:getAssetTagHistory() %s - Error reading asset tag history", message.AppRuntimeErr)
+			return &common.EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError}
+		}
+
+		historyJSON, err := json.Marshal(history)
+		if err != nil {
+			log.WithError(err).Errorf("resource/asset_tag:getAssetTagHistory() %s - There was an error marshaling the asset tag history", message.AppRuntimeErr)
+			return &common.EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError}
+		}
+
+		httpWriter.Header().Set("Content-Type", "application/json")
+		httpWriter.WriteHeader(http.StatusOK)
+		_, _ = bytes.NewBuffer(historyJSON).WriteTo(httpWriter)
+		return nil
+	}
+}
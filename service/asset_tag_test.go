@@ -0,0 +1,104 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"intel/isecl/go-trust-agent/v4/common"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	taModel "github.com/intel-secl/intel-secl/v4/pkg/model/ta"
+	"github.com/stretchr/testify/assert"
+)
+
+// assetTagRequestHandler returns 'err' from DeployAssetTag/ClearAssetTag and keeps the
+// caller (the other RequestHandler methods are not implemented).
+type assetTagRequestHandler struct {
+	common.RequestHandler
+	err    error
+	caller string
+}
+
+func (handler *assetTagRequestHandler) DeployAssetTag(ctx context.Context, tagWriteRequest *taModel.TagWriteRequest, caller string) error {
+	handler.caller = caller
+	return handler.err
+}
+
+func (handler *assetTagRequestHandler) ClearAssetTag(ctx context.Context, caller string) error {
+	handler.caller = caller
+	return handler.err
+}
+
+func TestClearAssetTagHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	requestHandler := &assetTagRequestHandler{}
+	request := httptest.NewRequest("DELETE", "/v2/tag", nil)
+	client := &x509.Certificate{Subject: pkix.Name{CommonName: "Admin"}}
+	request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{client}}}
+
+	recorder := httptest.NewRecorder()
+	errorHandler(clearAssetTag(requestHandler)).ServeHTTP(recorder, request)
+
+	assert.Equal(http.StatusNoContent, recorder.Code)
+	assert.Empty(recorder.Body.Bytes())
+	assert.Equal("cert:CN=Admin", requestHandler.caller)
+}
+
+func TestAssetTagHandlerErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		name       string
+		handler    func(common.RequestHandler) endpointHandler
+		method     string
+		body       string
+		err        error
+		statusCode int
+		code       common.ErrorCode
+	}{
+		{
+			name:       "clear without tag index",
+			handler:    clearAssetTag,
+			method:     "DELETE",
+			err:        &common.EndpointError{Message: "The asset tag index does not exist", StatusCode: http.StatusInternalServerError, Code: common.ErrorCodeTagIndexMissing},
+			statusCode: http.StatusInternalServerError,
+			code:       common.ErrorCodeTagIndexMissing,
+		},
+		{
+			name:       "deploy to another host",
+			handler:    setAssetTag,
+			method:     "POST",
+			body:       `{"tag": "dGFn", "hardware_uuid": "7a569dad-2d82-49e4-9156-069b0065b262"}`,
+			err:        &common.EndpointError{Message: "The hardware_uuid does not match", StatusCode: http.StatusConflict, Code: common.ErrorCodeHardwareUUIDMismatch},
+			statusCode: http.StatusConflict,
+			code:       common.ErrorCodeHardwareUUIDMismatch,
+		},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, "/v2/tag", strings.NewReader(test.body))
+		request.Header.Set("Content-Type", "application/json")
+
+		recorder := httptest.NewRecorder()
+		errorHandler(test.handler(&assetTagRequestHandler{err: test.err})).ServeHTTP(recorder, request)
+
+		assert.Equal(test.statusCode, recorder.Code, test.name)
+
+		var errorResponse common.ErrorResponse
+		err := json.Unmarshal(recorder.Body.Bytes(), &errorResponse)
+		if err != nil {
+			t.Fatalf("%s: the response is not a json ErrorResponse: %v", test.name, err)
+		}
+		assert.Equal(test.code, errorResponse.Code, test.name)
+	}
+}
@@ -17,6 +17,7 @@ import (
 // Ex. curl --request GET --user tagentadmin:TAgentAdminPassword https://localhost:1443/v2/binding-key-certificate -k --noproxy "*"
 func getBindingKeyCertificate(requestHandler common.RequestHandler) endpointHandler {
 	return func(httpWriter http.ResponseWriter, httpRequest *http.Request) error {
+		log := common.GetLogger(httpRequest.Context())
 		log.Trace("resource/binding_key_certificate:getBindingKeyCertificate() Entering")
 		defer log.Trace("resource/binding_key_certificate:getBindingKeyCertificate() Leaving")
 
@@ -29,7 +30,7 @@ func getBindingKeyCertificate(requestHandler common.RequestHandler) endpointHand
 			return &common.EndpointError{Message: "Invalid content-type", StatusCode: http.StatusBadRequest}
 		}
 
-		bindingKeyBytes, err := requestHandler.GetBindingCertificateDerBytes()
+		bindingKeyBytes, err := requestHandler.GetBindingCertificateDerBytes(httpRequest.Context())
 		if err != nil {
 			log.WithError(err).Errorf("resource/binding_key_certificate:getBindingKeyCertificate() %s - Error while getting binding key", message.AppRuntimeErr)
 			return err
@@ -0,0 +1,194 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package service
+
+import (
+	"crypto/x509"
+	"intel/isecl/go-trust-agent/v4/common"
+	"intel/isecl/go-trust-agent/v4/constants"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	commContext "github.com/intel-secl/intel-secl/v4/pkg/lib/common/context"
+	"github.com/intel-secl/intel-secl/v4/pkg/lib/common/log/message"
+	ct "github.com/intel-secl/intel-secl/v4/pkg/model/aas"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// clientCertificatePolicy maps client certificates to the permissions used by the web
+// service's endpoints (ex. 'quote:create') when TA_AUTH_MODE is 'mtls'.  Ex...
+//
+//	clients:
+//	- subject: CN=HVS Client,O=Intel
+//	  permissions: ["quote:create", "host_info:retrieve", "aik:retrieve"]
+//	- san: wls.example.com
+//	  permissions: ["*:*"]
+type clientCertificatePolicy struct {
+	Clients []clientCertificateRule `yaml:"clients"`
+}
+
+// clientCertificateRule applies to a certificate when the full subject (ex. 'CN=HVS Client,O=Intel',
+// all the attributes must match in any order) and/or a DNS/IP/URI/email subject alternative name
+// match.
+type clientCertificateRule struct {
+	Subject     string   `yaml:"subject"`
+	SAN         string   `yaml:"san"`
+	Permissions []string `yaml:"permissions"`
+
+	subjectAttributes []string
+}
+
+func loadClientCertificatePolicy(policyFile string) (*clientCertificatePolicy, error) {
+	file, err := os.Open(policyFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error opening the client certificate policy %s", policyFile)
+	}
+	defer file.Close()
+
+	var policy clientCertificatePolicy
+	err = yaml.NewDecoder(file).Decode(&policy)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error parsing the client certificate policy %s", policyFile)
+	}
+
+	for i := range policy.Clients {
+		rule := &policy.Clients[i]
+		if rule.Subject == "" && rule.SAN == "" {
+			return nil, errors.Errorf("Client %d in %s does not have a subject or san", i, policyFile)
+		}
+
+		if rule.Subject != "" {
+			rule.subjectAttributes, err = parseDistinguishedName(rule.Subject)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid subject '%s' in %s", rule.Subject, policyFile)
+			}
+		}
+
+		for _, permission := range rule.Permissions {
+			if len(strings.Split(permission, ":")) < 2 {
+				return nil, errors.Errorf("Invalid permission '%s' in %s (should be '<resource>:<action>')", permission, policyFile)
+			}
+		}
+	}
+
+	return &policy, nil
+}
+
+// parseDistinguishedName returns the (lower case and sorted) 'type=value' attributes of a
+// distinguished name (ex. 'CN=HVS Client,O=Intel'), so that subjects can be compared regardless
+// of the order of the attributes, the case and the spaces around the separators.  Escaped characters (ex. '\,') are kept
+// as they are formatted by pkix.Name.String().
+func parseDistinguishedName(distinguishedName string) ([]string, error) {
+	var attributes []string
+	var attribute strings.Builder
+
+	addAttribute := func() error {
+		typeAndValue := strings.SplitN(attribute.String(), "=", 2)
+		attribute.Reset()
+		if len(typeAndValue) != 2 || strings.TrimSpace(typeAndValue[0]) == "" || strings.TrimSpace(typeAndValue[1]) == "" {
+			return errors.New("The attributes of the distinguished name should be '<type>=<value>'")
+		}
+
+		attributes = append(attributes, strings.ToLower(strings.TrimSpace(typeAndValue[0])+"="+strings.TrimSpace(typeAndValue[1])))
+		return nil
+	}
+
+	escaped := false
+	for _, c := range distinguishedName {
+		if !escaped && (c == ',' || c == '+') {
+			if err := addAttribute(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		escaped = !escaped && c == '\\'
+		attribute.WriteRune(c)
+	}
+
+	if err := addAttribute(); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(attributes)
+	return attributes, nil
+}
+
+func (rule *clientCertificateRule) matches(certificate *x509.Certificate) bool {
+	if rule.Subject != "" {
+		subjectAttributes, err := parseDistinguishedName(certificate.Subject.String())
+		if err != nil || len(subjectAttributes) != len(rule.subjectAttributes) {
+			return false
+		}
+
+		for i := range subjectAttributes {
+			if subjectAttributes[i] != rule.subjectAttributes[i] {
+				return false
+			}
+		}
+	}
+
+	if rule.SAN != "" {
+		sans := append([]string{}, certificate.DNSNames...)
+		sans = append(sans, certificate.EmailAddresses...)
+		for _, ip := range certificate.IPAddresses {
+			sans = append(sans, ip.String())
+		}
+		for _, uri := range certificate.URIs {
+			sans = append(sans, uri.String())
+		}
+
+		for _, san := range sans {
+			if strings.EqualFold(rule.SAN, san) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// getPermissions returns the permissions of all the rules that match the certificate.
+func (policy *clientCertificatePolicy) getPermissions(certificate *x509.Certificate) []string {
+	var permissions []string
+	for _, rule := range policy.Clients {
+		if rule.matches(certificate) {
+			permissions = append(permissions, rule.Permissions...)
+		}
+	}
+
+	return permissions
+}
+
+// newClientCertificateAuth replaces middleware.NewTokenAuth when TA_AUTH_MODE is 'mtls': the
+// permissions of the (verified) client certificate are added to the request's context so that
+// they are checked by requiresPermission.
+func newClientCertificateAuth(policy *clientCertificatePolicy) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+				secLog.Errorf("resource/client_certificate_auth:newClientCertificateAuth() %s A client certificate was not provided for %s", message.AuthenticationFailed, r.RequestURI)
+				writeErrorResponse(w, r, http.StatusUnauthorized, &common.ErrorResponse{Code: common.ErrorCodeUnauthorized, Message: "A valid client certificate is required"})
+				return
+			}
+
+			certificate := r.TLS.VerifiedChains[0][0]
+			permissions := policy.getPermissions(certificate)
+			if len(permissions) == 0 {
+				secLog.Errorf("resource/client_certificate_auth:newClientCertificateAuth() %s The client certificate '%s' is not in the client certificate policy", message.UnauthorizedAccess, certificate.Subject.String())
+				writeErrorResponse(w, r, http.StatusUnauthorized, &common.ErrorResponse{Code: common.ErrorCodeUnauthorized, Message: "The client certificate is not authorized"})
+				return
+			}
+
+			r = commContext.SetUserPermissions(r, []ct.PermissionInfo{{Service: constants.TAServiceName, Rules: permissions}})
+			next.ServeHTTP(w, r)
+		})
+	}
+}
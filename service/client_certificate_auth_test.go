@@ -0,0 +1,190 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"intel/isecl/go-trust-agent/v4/common"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+const testClientCertificatePolicy = `
+clients:
+- subject: O=Intel, CN=HVS Client
+  permissions: ["quote:create", "host_info:retrieve"]
+- subject: CN=WLS Client,O=Intel
+  san: wls.example.com
+  permissions: ["*:*"]
+- san: 10.1.2.3
+  permissions: ["aik:retrieve"]
+`
+
+func TestClientCertificateAuth(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "client-certificate-policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	policyFile := filepath.Join(tmpDir, "client-certificate-policy.yml")
+	err = ioutil.WriteFile(policyFile, []byte(testClientCertificatePolicy), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := loadClientCertificatePolicy(policyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	authRouter := router.PathPrefix("/v2/").Subrouter()
+	authRouter.Use(newClientCertificateAuth(policy))
+	endpoint := func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+	authRouter.HandleFunc("/tpm/quote", errorHandler(requiresPermission(endpoint, []string{postQuotePerm}))).Methods("POST")
+	authRouter.HandleFunc("/aik", errorHandler(requiresPermission(endpoint, []string{getAIKPerm}))).Methods("GET")
+
+	hvsClient := &x509.Certificate{Subject: pkix.Name{CommonName: "HVS Client", Organization: []string{"Intel"}}}
+	wlsClient := &x509.Certificate{Subject: pkix.Name{CommonName: "WLS Client", Organization: []string{"Intel"}}, DNSNames: []string{"wls.example.com"}}
+	wlsClientOtherHost := &x509.Certificate{Subject: pkix.Name{CommonName: "WLS Client", Organization: []string{"Intel"}}, DNSNames: []string{"other.example.com"}}
+	// the subjects of the policy are compared with the full subject of the certificates
+	hvsClientOtherOrganization := &x509.Certificate{Subject: pkix.Name{CommonName: "HVS Client", Organization: []string{"Other"}}}
+	hvsClientNoOrganization := &x509.Certificate{Subject: pkix.Name{CommonName: "HVS Client"}}
+	hvsClientOrganizationalUnit := &x509.Certificate{Subject: pkix.Name{CommonName: "HVS Client", Organization: []string{"Intel"}, OrganizationalUnit: []string{"Other"}}}
+	ipClient := &x509.Certificate{Subject: pkix.Name{CommonName: "Other Client"}, IPAddresses: []net.IP{net.ParseIP("10.1.2.3")}}
+
+	tests := []struct {
+		name           string
+		certificate    *x509.Certificate
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		{"hvs quote", hvsClient, "POST", "/v2/tpm/quote", http.StatusOK},
+		{"hvs aik", hvsClient, "GET", "/v2/aik", http.StatusUnauthorized},
+		{"hvs other organization", hvsClientOtherOrganization, "POST", "/v2/tpm/quote", http.StatusUnauthorized},
+		{"hvs no organization", hvsClientNoOrganization, "POST", "/v2/tpm/quote", http.StatusUnauthorized},
+		{"hvs organizational unit", hvsClientOrganizationalUnit, "POST", "/v2/tpm/quote", http.StatusUnauthorized},
+		{"wls quote", wlsClient, "POST", "/v2/tpm/quote", http.StatusOK},
+		{"wls aik", wlsClient, "GET", "/v2/aik", http.StatusOK},
+		{"wls other host", wlsClientOtherHost, "GET", "/v2/aik", http.StatusUnauthorized},
+		{"ip aik", ipClient, "GET", "/v2/aik", http.StatusOK},
+		{"ip quote", ipClient, "POST", "/v2/tpm/quote", http.StatusUnauthorized},
+		{"no certificate", nil, "GET", "/v2/aik", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, test.path, nil)
+		request.TLS = &tls.ConnectionState{}
+		if test.certificate != nil {
+			request.TLS.VerifiedChains = [][]*x509.Certificate{{test.certificate}}
+		}
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		if recorder.Code != test.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.expectedStatus, recorder.Code)
+		}
+	}
+
+	if caller := getCaller(httptest.NewRequest("GET", "/v2/tag", nil)); caller != "jwt:unknown" {
+		t.Errorf("Expected 'jwt:unknown', got '%s'", caller)
+	}
+
+	request := httptest.NewRequest("GET", "/v2/tag", nil)
+	request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{hvsClient}}}
+	if caller := getCaller(request); caller != "cert:CN=HVS Client,O=Intel" {
+		t.Errorf("Expected 'cert:CN=HVS Client,O=Intel', got '%s'", caller)
+	}
+}
+
+func TestLoadClientCertificatePolicyErrors(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "client-certificate-policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, policy := range []string{
+		"clients:\n- permissions: [\"quote:create\"]\n",
+		"clients:\n- subject: CN=HVS Client\n  permissions: [\"quote\"]\n",
+		"clients:\n- subject: HVS Client\n  permissions: [\"quote:create\"]\n",
+		"clients:\n- subject: CN=HVS Client,\n  permissions: [\"quote:create\"]\n",
+		"clients: [",
+	} {
+		policyFile := filepath.Join(tmpDir, "policy.yml")
+		err = ioutil.WriteFile(policyFile, []byte(policy), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = loadClientCertificatePolicy(policyFile)
+		if err == nil {
+			t.Errorf("Expected an error for policy %q", policy)
+		}
+	}
+
+	_, err = loadClientCertificatePolicy(filepath.Join(tmpDir, "missing.yml"))
+	if err == nil {
+		t.Error("Expected an error for a missing policy file")
+	}
+}
+
+func TestParseDistinguishedName(t *testing.T) {
+	certificate := &x509.Certificate{Subject: pkix.Name{CommonName: "Client, Inc.", Organization: []string{"Intel"}, OrganizationalUnit: []string{"ISecL"}}}
+	subject, err := parseDistinguishedName(certificate.Subject.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rule, err := parseDistinguishedName(`ou=ISecL + O = intel,CN=Client\, Inc.`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(subject, ",") != strings.Join(rule, ",") {
+		t.Errorf("Expected %v, got %v", subject, rule)
+	}
+}
+
+func TestClientCertificateAuthErrorResponse(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(newClientCertificateAuth(&clientCertificatePolicy{}))
+	router.HandleFunc("/v2/aik", func(w http.ResponseWriter, r *http.Request) {}).Methods("GET")
+
+	request := httptest.NewRequest("GET", "/v2/aik", nil)
+	request.Header.Set(requestIDHeader, "test-request")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusUnauthorized || recorder.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Unexpected response: %d %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+
+	var errorResponse common.ErrorResponse
+	err := json.Unmarshal(recorder.Body.Bytes(), &errorResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if errorResponse.Code != common.ErrorCodeUnauthorized || errorResponse.RequestID != "test-request" {
+		t.Errorf("Unexpected error response: %+v", errorResponse)
+	}
+}
@@ -18,6 +18,8 @@ import (
 // Writes the manifest xml received to /opt/trustagent/var/manifest_{UUID}.xml.
 func deployManifest(requestHandler common.RequestHandler) endpointHandler {
 	return func(httpWriter http.ResponseWriter, httpRequest *http.Request) error {
+		log := common.GetLogger(httpRequest.Context())
+		secLog := common.GetSecurityLogger(httpRequest.Context())
 		log.Trace("resource/deploy_manifest:deployManifest() Entering")
 		defer log.Trace("resource/deploy_manifest:deployManifest() Leaving")
 
@@ -44,7 +46,7 @@ func deployManifest(requestHandler common.RequestHandler) endpointHandler {
 			return &common.EndpointError{Message: "Error: Invalid xml format", StatusCode: http.StatusBadRequest}
 		}
 
-		err = requestHandler.DeploySoftwareManifest(&manifest)
+		err = requestHandler.DeploySoftwareManifest(httpRequest.Context(), &manifest)
 		if err != nil {
 			log.WithError(err).Errorf("resource/deploy_manifest:deployManifest() %s - Error while deploying manifest", message.AppRuntimeErr)
 			return err
@@ -0,0 +1,47 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"intel/isecl/go-trust-agent/v4/common"
+	"net/http"
+
+	"github.com/intel-secl/intel-secl/v4/pkg/lib/common/log/message"
+	"github.com/pkg/errors"
+)
+
+// getEndorsementKeyCertificates returns the certificate chains of the TPM's RSA/ECC EKs
+// (including the on-die issuing certificates when provisioned).
+func getEndorsementKeyCertificates(requestHandler common.RequestHandler) endpointHandler {
+	return func(httpWriter http.ResponseWriter, httpRequest *http.Request) error {
+		log := common.GetLogger(httpRequest.Context())
+		log.Trace("resource/ek_certificate:getEndorsementKeyCertificates() Entering")
+		defer log.Trace("resource/ek_certificate:getEndorsementKeyCertificates() Leaving")
+
+		log.Debugf("resource/ek_certificate:getEndorsementKeyCertificates() Request: %s", httpRequest.URL.Path)
+
+		ekCertificates, err := requestHandler.GetEndorsementKeyCertificates(httpRequest.Context())
+		if endpointError, ok := errors.Cause(err).(*common.EndpointError); ok {
+			log.WithError(err).Errorf("resource/ek_certificate:getEndorsementKeyCertificates() %s - Error reading ek certificates", message.AppRuntimeErr)
+			return endpointError
+		} else if err != nil {
+			log.WithError(err).Errorf("resource/ek_certificate:getEndorsementKeyCertificates() %s - Error reading ek certificates", message.AppRuntimeErr)
+			return &common.EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError}
+		}
+
+		ekCertificatesJSON, err := json.Marshal(ekCertificates)
+		if err != nil {
+			log.WithError(err).Errorf("resource/ek_certificate:getEndorsementKeyCertificates() %s - There was an error marshaling the ek certificates", message.AppRuntimeErr)
+			return &common.EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError}
+		}
+
+		httpWriter.Header().Set("Content-Type", "application/json")
+		httpWriter.WriteHeader(http.StatusOK)
+		_, _ = bytes.NewBuffer(ekCertificatesJSON).WriteTo(httpWriter)
+		return nil
+	}
+}
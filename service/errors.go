@@ -0,0 +1,81 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package service
+
+import (
+	"intel/isecl/go-trust-agent/v4/common"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// notFoundHandler returns the json ErrorResponse when a request does not match any route
+// (mux.Router.NotFoundHandler).
+func notFoundHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeErrorResponse(w, r, http.StatusNotFound, &common.ErrorResponse{Code: common.ErrorCodeNotFound, Message: "The requested resource was not found"})
+	})
+}
+
+// methodNotAllowedHandler returns the json ErrorResponse when a route does not support the
+// request's method (mux.Router.MethodNotAllowedHandler).
+func methodNotAllowedHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeErrorResponse(w, r, http.StatusMethodNotAllowed, &common.ErrorResponse{Code: common.ErrorCodeInvalidRequest, Message: "The method is not allowed for the requested resource"})
+	})
+}
+
+// withJSONErrors wraps an authentication middleware that does not write a body when it
+// rejects a request (ex. middleware.NewTokenAuth returns 401 without a body), so that the
+// rejection is returned as a json ErrorResponse like the other errors of the web service.
+func withJSONErrors(authMiddleware mux.MiddlewareFunc) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		authenticated := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if errorWriter, ok := w.(*authErrorWriter); ok {
+				errorWriter.authenticated = true
+				w = errorWriter.ResponseWriter
+			}
+			next.ServeHTTP(w, r)
+		})
+
+		handler := authMiddleware(authenticated)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler.ServeHTTP(&authErrorWriter{ResponseWriter: w, request: r}, r)
+		})
+	}
+}
+
+// authErrorWriter replaces the (empty or text) error responses written by an authentication
+// middleware before the request was passed to the next handler.
+type authErrorWriter struct {
+	http.ResponseWriter
+	request       *http.Request
+	authenticated bool
+	replaced      bool
+}
+
+func (w *authErrorWriter) WriteHeader(statusCode int) {
+	if w.authenticated || statusCode < http.StatusBadRequest {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+
+	w.replaced = true
+	errorResponse := &common.ErrorResponse{Code: common.EndpointError{StatusCode: statusCode}.ErrorCode(), Message: http.StatusText(statusCode)}
+	if statusCode == http.StatusUnauthorized {
+		errorResponse.Message = "A valid bearer token is required"
+	}
+
+	writeErrorResponse(w.ResponseWriter, w.request, statusCode, errorResponse)
+}
+
+func (w *authErrorWriter) Write(data []byte) (int, error) {
+	if w.replaced {
+		// the middleware's body was replaced by the json ErrorResponse
+		return len(data), nil
+	}
+
+	return w.ResponseWriter.Write(data)
+}
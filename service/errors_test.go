@@ -0,0 +1,78 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package service
+
+import (
+	"encoding/json"
+	"intel/isecl/go-trust-agent/v4/common"
+	"intel/isecl/go-trust-agent/v4/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWebServiceErrorResponses makes sure that the errors that are not returned by the
+// endpoints (authentication, unknown routes and methods) are also json ErrorResponses.
+func TestWebServiceErrorResponses(t *testing.T) {
+	service, err := newWebService(&WebParameters{Port: 1}, &config.Metrics{}, &config.RateLimits{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := service.(*trustAgentWebService).router
+
+	tests := []struct {
+		method     string
+		path       string
+		statusCode int
+		code       common.ErrorCode
+	}{
+		{method: "GET", path: "/v2/aik", statusCode: http.StatusUnauthorized, code: common.ErrorCodeUnauthorized},
+		{method: "GET", path: "/v2/does-not-exist", statusCode: http.StatusNotFound, code: common.ErrorCodeNotFound},
+		{method: "DELETE", path: "/v2/version", statusCode: http.StatusMethodNotAllowed, code: common.ErrorCodeInvalidRequest},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, test.path, nil)
+		request.Header.Set(requestIDHeader, "test-request-id")
+		recorder := httptest.NewRecorder()
+
+		router.ServeHTTP(recorder, request)
+
+		assert.Equal(t, test.statusCode, recorder.Code, test.path)
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"), test.path)
+
+		var errorResponse common.ErrorResponse
+		err = json.Unmarshal(recorder.Body.Bytes(), &errorResponse)
+		if err != nil {
+			t.Fatalf("%s %s did not return a json ErrorResponse: %v", test.method, test.path, err)
+		}
+
+		assert.Equal(t, test.code, errorResponse.Code, test.path)
+		assert.NotEmpty(t, errorResponse.Message, test.path)
+		assert.Equal(t, "test-request-id", errorResponse.RequestID, test.path)
+	}
+}
+
+func TestWithJSONErrorsAuthenticated(t *testing.T) {
+	// an authentication middleware that passes the request to the next handler
+	authMiddleware := func(next http.Handler) http.Handler {
+		return next
+	}
+
+	handler := withJSONErrors(authMiddleware)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// errors of authenticated requests are written by the endpoints
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("endpoint body"))
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/v2/tag", nil))
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.Equal(t, "endpoint body", recorder.Body.String())
+}
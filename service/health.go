@@ -0,0 +1,76 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"intel/isecl/go-trust-agent/v4/common"
+	"net/http"
+	"time"
+
+	"github.com/intel-secl/intel-secl/v4/pkg/lib/common/log/message"
+	"github.com/pkg/errors"
+)
+
+// getLiveness returns 200 while the service is able to handle requests.
+func getLiveness() endpointHandler {
+	return func(httpWriter http.ResponseWriter, httpRequest *http.Request) error {
+		log := common.GetLogger(httpRequest.Context())
+		log.Trace("resource/health:getLiveness() Entering")
+		defer log.Trace("resource/health:getLiveness() Leaving")
+
+		return writeHealthStatus(httpWriter, http.StatusOK, &common.HealthStatus{Status: common.HealthStatusOK, CheckTime: time.Now()})
+	}
+}
+
+// getReadiness returns 200 when the Trust-Agent has been provisioned and can attest the
+// host (see RequestHandler.GetReadiness) and its TLS certificate is valid, otherwise 503
+// with the checks that failed.
+func getReadiness(requestHandler common.RequestHandler, renewer *certificateRenewer) endpointHandler {
+	return func(httpWriter http.ResponseWriter, httpRequest *http.Request) error {
+		log := common.GetLogger(httpRequest.Context())
+		log.Trace("resource/health:getReadiness() Entering")
+		defer log.Trace("resource/health:getReadiness() Leaving")
+
+		healthStatus := requestHandler.GetReadiness(httpRequest.Context())
+		healthStatus.AddCheck(common.HealthCheckTLSCertificate, checkTLSCertificate(renewer))
+
+		statusCode := http.StatusOK
+		if !healthStatus.IsReady() {
+			statusCode = http.StatusServiceUnavailable
+		}
+
+		return writeHealthStatus(httpWriter, statusCode, healthStatus)
+	}
+}
+
+// checkTLSCertificate returns an error when the service's TLS certificate could not be
+// read or has expired (a certificate that is 'expiring' can still be used).
+func checkTLSCertificate(renewer *certificateRenewer) error {
+	tlsStatus := renewer.Status()
+	if tlsStatus.Status != tlsCertificateExpired {
+		return nil
+	}
+
+	if tlsStatus.NotAfter == nil {
+		return errors.New(tlsStatus.LastError)
+	}
+
+	return errors.Errorf("The TLS certificate expired %s", tlsStatus.NotAfter.String())
+}
+
+func writeHealthStatus(httpWriter http.ResponseWriter, statusCode int, healthStatus *common.HealthStatus) error {
+	healthJSON, err := json.Marshal(healthStatus)
+	if err != nil {
+		log.WithError(err).Errorf("resource/health:writeHealthStatus() %s - There was an error marshaling the health status", message.AppRuntimeErr)
+		return &common.EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError}
+	}
+
+	httpWriter.Header().Set("Content-Type", "application/json")
+	httpWriter.WriteHeader(statusCode)
+	_, _ = bytes.NewBuffer(healthJSON).WriteTo(httpWriter)
+	return nil
+}
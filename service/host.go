@@ -17,6 +17,7 @@ import (
 
 func getPlatformInfo(requestHandler common.RequestHandler) endpointHandler {
 	return func(httpWriter http.ResponseWriter, httpRequest *http.Request) error {
+		log := common.GetLogger(httpRequest.Context())
 		log.Trace("resource/host:getPlatformInfo() Entering")
 		defer log.Trace("resource/host:getPlatformInfo() Leaving")
 
@@ -29,7 +30,7 @@ func getPlatformInfo(requestHandler common.RequestHandler) endpointHandler {
 			return &common.EndpointError{Message: "Invalid content-type", StatusCode: http.StatusBadRequest}
 		}
 
-		hostInfo, err := requestHandler.GetHostInfo()
+		hostInfo, err := requestHandler.GetHostInfo(httpRequest.Context())
 		if err != nil {
 			log.WithError(err).Errorf("resource/host:getPlatformInfo() %s - There was an error reading %s", message.AppRuntimeErr, constants.PlatformInfoFilePath)
 			return &common.EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError}
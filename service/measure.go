@@ -19,6 +19,8 @@ import (
 // Uses /opt/tbootxml/bin/measure to measure the supplied manifest
 func getApplicationMeasurement(requestHandler common.RequestHandler) endpointHandler {
 	return func(httpWriter http.ResponseWriter, httpRequest *http.Request) error {
+		log := common.GetLogger(httpRequest.Context())
+		secLog := common.GetSecurityLogger(httpRequest.Context())
 		log.Trace("resource/measure:getApplicationMeasurement() Entering")
 		defer log.Trace("resource/measure:getApplicationMeasurement() Leaving")
 
@@ -46,7 +48,7 @@ func getApplicationMeasurement(requestHandler common.RequestHandler) endpointHan
 			return &common.EndpointError{Message: "Error: Invalid XML format", StatusCode: http.StatusBadRequest}
 		}
 
-		measurement, err := requestHandler.GetApplicationMeasurement(&manifest)
+		measurement, err := requestHandler.GetApplicationMeasurement(httpRequest.Context(), &manifest)
 		if err != nil {
 			log.WithError(err).Errorf("resource/measure:getApplicationMeasurement() %s - Error getting measurement", message.AppRuntimeErr)
 			return err
@@ -0,0 +1,256 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package service
+
+import (
+	"context"
+	"fmt"
+	"intel/isecl/go-trust-agent/v4/common"
+	"intel/isecl/go-trust-agent/v4/constants"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "The number of http requests handled by the Trust-Agent by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "The time spent handling http requests by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	natsRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "nats_requests_total",
+		Help:      "The number of nats requests handled by the Trust-Agent by subject (ex. 'quote-request') and status code.",
+	}, []string{"subject", "code"})
+
+	natsRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "nats_request_duration_seconds",
+		Help:      "The time spent handling nats requests by subject.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"subject"})
+)
+
+// newMetricsRegistry returns the registry served by /v2/metrics (and the optional
+// TA_METRICS_PORT), which contains the go/process metrics, the RequestHandler's metrics
+// and the service specific 'collectors'.
+func newMetricsRegistry(requestHandler common.RequestHandler, collectors ...prometheus.Collector) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewGoCollector())
+	registry.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	registry.MustRegister(common.MetricsCollectors()...)
+	registry.MustRegister(newTpmBrokerCollector(requestHandler))
+	registry.MustRegister(collectors...)
+	return registry
+}
+
+// newMetricsHandler returns the http.Handler that writes the registry's metrics.
+func newMetricsHandler(registry *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{ErrorLog: log})
+}
+
+func getMetrics(registry *prometheus.Registry) endpointHandler {
+	metricsHandler := newMetricsHandler(registry)
+	return func(httpWriter http.ResponseWriter, httpRequest *http.Request) error {
+		log := common.GetLogger(httpRequest.Context())
+		log.Trace("resource/metrics:getMetrics() Entering")
+		defer log.Trace("resource/metrics:getMetrics() Leaving")
+
+		metricsHandler.ServeHTTP(httpWriter, httpRequest)
+		return nil
+	}
+}
+
+// newMetricsServer returns an http server that provides the metrics on
+// http://127.0.0.1:<port>/metrics without authentication (so that a local prometheus
+// agent does not need a token).  nil is returned when the port is not configured.
+func newMetricsServer(port int, registry *prometheus.Registry) *http.Server {
+	if port == 0 {
+		return nil
+	}
+
+	serveMux := http.NewServeMux()
+	serveMux.Handle("/metrics", newMetricsHandler(registry))
+
+	return &http.Server{
+		Addr:              fmt.Sprintf("127.0.0.1:%d", port),
+		Handler:           serveMux,
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+}
+
+func startMetricsServer(server *http.Server) {
+	if server == nil {
+		return
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Errorf("resource/metrics:startMetricsServer() Failed to start the metrics server on %s", server.Addr)
+		}
+	}()
+	log.Infof("resource/metrics:startMetricsServer() Metrics are available at http://%s/metrics", server.Addr)
+}
+
+func stopMetricsServer(server *http.Server) {
+	if server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.WithError(err).Info("resource/metrics:stopMetricsServer() Failed to gracefully shutdown the metrics server")
+	}
+}
+
+// newHttpMetricsMiddleware counts and times the requests handled by the router's routes
+// (labeled with the route's path template, ex. '/v2/tpm/quote').
+func newHttpMetricsMiddleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := "unknown"
+			if currentRoute := mux.CurrentRoute(r); currentRoute != nil {
+				if pathTemplate, err := currentRoute.GetPathTemplate(); err == nil {
+					route = pathTemplate
+				}
+			}
+
+			start := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			httpRequestsTotal.WithLabelValues(route, r.Method, strconv.Itoa(recorder.statusCode)).Inc()
+			httpRequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (recorder *statusRecorder) WriteHeader(statusCode int) {
+	recorder.statusCode = statusCode
+	recorder.ResponseWriter.WriteHeader(statusCode)
+}
+
+type natsRequestKey struct{}
+
+type natsRequest struct {
+	subject string
+	start   time.Time
+}
+
+// withNatsRequest adds the request type of the nats subject (ex. 'quote-request' from
+// 'trust-agent.<host-id>.quote-request') and the start time to the context, so that the
+// request can be observed when the reply is published.
+func withNatsRequest(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, natsRequestKey{}, natsRequest{subject: natsRequestType(subject), start: time.Now()})
+}
+
+// natsRequestType returns the last token of a Trust-Agent subject (see taModel.CreateSubject).
+func natsRequestType(subject string) string {
+	return subject[strings.LastIndex(subject, ".")+1:]
+}
+
+// observeNatsRequest records the nats request in 'ctx' (see withNatsRequest).
+func observeNatsRequest(ctx context.Context, statusCode int) {
+	request, ok := ctx.Value(natsRequestKey{}).(natsRequest)
+	if !ok {
+		return
+	}
+
+	natsRequestsTotal.WithLabelValues(request.subject, strconv.Itoa(statusCode)).Inc()
+	natsRequestDuration.WithLabelValues(request.subject).Observe(time.Since(request.start).Seconds())
+}
+
+// tpmBrokerCollector reports the TpmBroker's queue and request counters.
+type tpmBrokerCollector struct {
+	requestHandler common.RequestHandler
+	queueDepth     *prometheus.Desc
+	queueCapacity  *prometheus.Desc
+	requests       *prometheus.Desc
+}
+
+func newTpmBrokerCollector(requestHandler common.RequestHandler) *tpmBrokerCollector {
+	return &tpmBrokerCollector{
+		requestHandler: requestHandler,
+		queueDepth: prometheus.NewDesc(prometheus.BuildFQName(constants.MetricsNamespace, "", "tpm_queue_depth"),
+			"The number of tpm operations waiting in the queue.", nil, nil),
+		queueCapacity: prometheus.NewDesc(prometheus.BuildFQName(constants.MetricsNamespace, "", "tpm_queue_capacity"),
+			"The maximum number of tpm operations that can wait in the queue (TA_TPM_QUEUE_SIZE).", nil, nil),
+		requests: prometheus.NewDesc(prometheus.BuildFQName(constants.MetricsNamespace, "", "tpm_requests_total"),
+			"The number of tpm operations submitted to the queue by result (completed, failed, rejected, timed_out, cancelled).", []string{"result"}, nil),
+	}
+}
+
+func (collector *tpmBrokerCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- collector.queueDepth
+	descs <- collector.queueCapacity
+	descs <- collector.requests
+}
+
+func (collector *tpmBrokerCollector) Collect(metrics chan<- prometheus.Metric) {
+	tpmMetrics := collector.requestHandler.GetTpmMetrics()
+
+	metrics <- prometheus.MustNewConstMetric(collector.queueDepth, prometheus.GaugeValue, float64(tpmMetrics.QueueDepth))
+	metrics <- prometheus.MustNewConstMetric(collector.queueCapacity, prometheus.GaugeValue, float64(tpmMetrics.QueueCapacity))
+	metrics <- prometheus.MustNewConstMetric(collector.requests, prometheus.CounterValue, float64(tpmMetrics.Completed), "completed")
+	metrics <- prometheus.MustNewConstMetric(collector.requests, prometheus.CounterValue, float64(tpmMetrics.Failed), "failed")
+	metrics <- prometheus.MustNewConstMetric(collector.requests, prometheus.CounterValue, float64(tpmMetrics.Rejected), "rejected")
+	metrics <- prometheus.MustNewConstMetric(collector.requests, prometheus.CounterValue, float64(tpmMetrics.TimedOut), "timed_out")
+	metrics <- prometheus.MustNewConstMetric(collector.requests, prometheus.CounterValue, float64(tpmMetrics.Cancelled), "cancelled")
+}
+
+// newCertificateExpiryGauge reports the time when a certificate (ex. 'tls') expires
+// (zero when the certificate could not be read).
+func newCertificateExpiryGauge(certificate string, notAfter func() (time.Time, error)) prometheus.Collector {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   constants.MetricsNamespace,
+		Name:        constants.MetricsCertificateExpiry,
+		Help:        "The time (unix seconds) when the certificate expires.",
+		ConstLabels: prometheus.Labels{"certificate": certificate},
+	}, func() float64 {
+		expiry, err := notAfter()
+		if err != nil {
+			log.WithError(err).Debugf("resource/metrics:newCertificateExpiryGauge() Could not read the %s certificate's expiry", certificate)
+			return 0
+		}
+		return float64(expiry.Unix())
+	})
+}
+
+// newTLSRenewalTokenExpiryGauge reports the time when the TLS renewal token expires (zero when
+// the token was not downloaded or its expiry is unknown).
+func newTLSRenewalTokenExpiryGauge(renewer *certificateRenewer) prometheus.Collector {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      constants.MetricsTLSRenewalTokenExpiry,
+		Help:      "The time (unix seconds) when the token used to renew the TLS certificate expires.",
+	}, func() float64 {
+		if renewer.tokenExpiry.IsZero() {
+			return 0
+		}
+		return float64(renewer.tokenExpiry.Unix())
+	})
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package service
+
+import (
+	"context"
+	"intel/isecl/go-trust-agent/v4/common"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHttpMetricsMiddleware(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(newHttpMetricsMiddleware())
+
+	subRouter := router.PathPrefix("/v2/").Subrouter()
+	subRouter.HandleFunc("/tag", errorHandler(func(w http.ResponseWriter, r *http.Request) error {
+		return &common.EndpointError{Message: "The asset tag nv index does not exist", StatusCode: http.StatusNotFound, Code: common.ErrorCodeTagIndexMissing}
+	})).Methods("GET")
+	subRouter.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("v4.2.0"))
+	}).Methods("GET")
+
+	notFound := httpRequestsTotal.WithLabelValues("/v2/tag", "GET", "404")
+	ok := httpRequestsTotal.WithLabelValues("/v2/version", "GET", "200")
+	notFoundCount := testutil.ToFloat64(notFound)
+	okCount := testutil.ToFloat64(ok)
+
+	for _, path := range []string{"/v2/tag", "/v2/version", "/v2/version"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	if count := testutil.ToFloat64(notFound) - notFoundCount; count != 1 {
+		t.Errorf("Expected 1 '/v2/tag' 404 request, got %v", count)
+	}
+
+	if count := testutil.ToFloat64(ok) - okCount; count != 2 {
+		t.Errorf("Expected 2 '/v2/version' 200 requests, got %v", count)
+	}
+}
+
+func TestNatsRequestMetrics(t *testing.T) {
+	timeout := natsRequestsTotal.WithLabelValues("quote-request", "503")
+	timeoutCount := testutil.ToFloat64(timeout)
+
+	ctx := withNatsRequest(context.Background(), "trust-agent.00ecd3ab-9af4-e711-906e-001560a04062.quote-request")
+	observeNatsRequest(ctx, http.StatusServiceUnavailable)
+
+	// requests without the nats context are ignored
+	observeNatsRequest(context.Background(), http.StatusServiceUnavailable)
+
+	if count := testutil.ToFloat64(timeout) - timeoutCount; count != 1 {
+		t.Errorf("Expected 1 'quote-request' 503 request, got %v", count)
+	}
+}
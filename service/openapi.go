@@ -0,0 +1,371 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"intel/isecl/go-trust-agent/v4/common"
+	"intel/isecl/go-trust-agent/v4/constants"
+	"intel/isecl/go-trust-agent/v4/util"
+	"net/http"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	taModel "github.com/intel-secl/intel-secl/v4/pkg/model/ta"
+)
+
+const (
+	contentTypeJSON   = "application/json"
+	contentTypeXML    = "application/xml"
+	contentTypeText   = "text/plain"
+	contentTypeBinary = "application/octet-stream"
+	contentTypePEM    = "application/x-pem-file"
+)
+
+// openAPIOperation describes a route of the web service in the OpenAPI document returned
+// by GET /v2/openapi.json.  The request/response schemas are generated from the (Go)
+// types of the request/response bodies (see openAPISchemas).
+type openAPIOperation struct {
+	method              string
+	path                string // relative to /v2
+	operationID         string
+	summary             string
+	permission          string      // empty for routes that don't require authentication
+	requestType         interface{} // nil when the request doesn't have a body
+	requestContentType  string
+	responseStatus      int
+	responseType        interface{} // nil for binary/text responses
+	responseContentType string
+	errorStatuses       []int // 401/429 (authenticated routes) and 500 are added by newOpenAPIDocument
+}
+
+// openAPIOperations are the routes of the web service: newWebService registers the handler
+// of each operation (by operationID) with the operation's method, path and permission.
+var openAPIOperations = []openAPIOperation{
+	{method: "GET", path: "/version", operationID: "getVersion", summary: "Returns the Trust-Agent's version and build information.",
+		responseStatus: http.StatusOK, responseContentType: contentTypeText},
+	{method: "GET", path: "/health/live", operationID: "getLiveness", summary: "Returns 200 while the service is able to handle requests.",
+		responseStatus: http.StatusOK, responseType: common.HealthStatus{}, responseContentType: contentTypeJSON},
+	{method: "GET", path: "/health/ready", operationID: "getReadiness", summary: "Returns whether the Trust-Agent has been provisioned and can attest the host.",
+		responseStatus: http.StatusOK, responseType: common.HealthStatus{}, responseContentType: contentTypeJSON, errorStatuses: []int{http.StatusServiceUnavailable}},
+	{method: "GET", path: "/openapi.json", operationID: "getOpenAPIDocument", summary: "Returns this OpenAPI document.",
+		responseStatus: http.StatusOK, responseContentType: contentTypeJSON},
+	{method: "GET", path: "/aik", operationID: "getAik", summary: "Returns the (DER encoded) AIK certificate.", permission: getAIKPerm,
+		responseStatus: http.StatusOK, responseContentType: contentTypeBinary, errorStatuses: []int{http.StatusBadRequest, http.StatusNotFound}},
+	{method: "GET", path: "/aik/ca", operationID: "getAikCa", summary: "Returns the (DER encoded) privacy-ca certificate that issued the AIK.", permission: getAIKCAPerm,
+		responseStatus: http.StatusOK, responseContentType: contentTypeBinary, errorStatuses: []int{http.StatusNotFound}},
+	{method: "GET", path: "/ek-certificate", operationID: "getEndorsementKeyCertificates", summary: "Returns the certificate chains of the TPM's EKs.", permission: getEkCertificatePerm,
+		responseStatus: http.StatusOK, responseType: []common.EndorsementKeyCertificate{}, responseContentType: contentTypeJSON, errorStatuses: []int{http.StatusServiceUnavailable}},
+	{method: "GET", path: "/host", operationID: "getHostInfo", summary: "Returns the host's platform-info.", permission: getHostInfoPerm,
+		responseStatus: http.StatusOK, responseType: taModel.HostInfo{}, responseContentType: contentTypeJSON, errorStatuses: []int{http.StatusBadRequest}},
+	{method: "POST", path: "/tpm/quote", operationID: "getTpmQuote", summary: "Returns a TPM quote signed by the AIK.", permission: postQuotePerm,
+		requestType: taModel.TpmQuoteRequest{}, requestContentType: contentTypeJSON,
+		responseStatus: http.StatusOK, responseType: common.TpmQuoteResponse{}, responseContentType: contentTypeXML,
+		errorStatuses: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusServiceUnavailable}},
+	{method: "GET", path: "/tpm/pcrs", operationID: "getTpmPcrs", summary: "Returns the PCR values of the active PCR banks.", permission: getPcrsPerm,
+		responseStatus: http.StatusOK, responseType: common.TpmPcrs{}, responseContentType: contentTypeJSON, errorStatuses: []int{http.StatusServiceUnavailable}},
+	{method: "GET", path: "/binding-key-certificate", operationID: "getBindingKeyCertificate", summary: "Returns the WLA binding key certificate.", permission: getBindingKeyPerm,
+		responseStatus: http.StatusOK, responseContentType: contentTypePEM, errorStatuses: []int{http.StatusBadRequest, http.StatusNotFound}},
+	{method: "POST", path: "/tag", operationID: "setAssetTag", summary: "Writes the asset tag to the TPM.", permission: postDeployTagPerm,
+		requestType: taModel.TagWriteRequest{}, requestContentType: contentTypeJSON,
+		responseStatus: http.StatusOK, errorStatuses: []int{http.StatusBadRequest, http.StatusConflict, http.StatusServiceUnavailable}},
+	{method: "GET", path: "/tag", operationID: "getAssetTag", summary: "Returns the asset tag in the TPM.", permission: getTagPerm,
+		responseStatus: http.StatusOK, responseType: common.AssetTag{}, responseContentType: contentTypeJSON, errorStatuses: []int{http.StatusNotFound, http.StatusServiceUnavailable}},
+	{method: "DELETE", path: "/tag", operationID: "clearAssetTag", summary: "Clears the asset tag in the TPM.", permission: deleteDeployTagPerm,
+		responseStatus: http.StatusNoContent, errorStatuses: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusServiceUnavailable}},
+	{method: "GET", path: "/tag/history", operationID: "getAssetTagHistory", summary: "Returns the asset tag audit trail.", permission: getTagPerm,
+		responseStatus: http.StatusOK, responseType: []common.AssetTagHistoryRecord{}, responseContentType: contentTypeJSON},
+	{method: "POST", path: "/host/application-measurement", operationID: "getApplicationMeasurement", summary: "Measures the files in the manifest.", permission: postAppMeasurementPerm,
+		requestType: taModel.Manifest{}, requestContentType: contentTypeXML,
+		responseStatus: http.StatusOK, responseType: taModel.Measurement{}, responseContentType: contentTypeXML,
+		errorStatuses: []int{http.StatusBadRequest, http.StatusServiceUnavailable}},
+	{method: "POST", path: "/deploy/manifest", operationID: "deployManifest", summary: "Saves the manifest in /opt/trustagent/var.", permission: postDeployManifestPerm,
+		requestType: taModel.Manifest{}, requestContentType: contentTypeXML,
+		responseStatus: http.StatusOK, errorStatuses: []int{http.StatusBadRequest}},
+	{method: "GET", path: "/tls-certificate", operationID: "getTLSCertificateStatus", summary: "Returns the expiry and renewal status of the TLS certificate.", permission: getTLSCertificatePerm,
+		responseStatus: http.StatusOK, responseType: TLSCertificateStatus{}, responseContentType: contentTypeJSON},
+	{method: "GET", path: "/metrics", operationID: "getMetrics", summary: "Returns the Trust-Agent's metrics in the Prometheus text format.", permission: getMetricsPerm,
+		responseStatus: http.StatusOK, responseContentType: contentTypeText},
+}
+
+// newOpenAPIDocument returns the OpenAPI 3.1 document of the web service's routes.  The
+// security scheme of the authenticated routes depends on 'authMode' (TA_AUTH_MODE): a bearer
+// token, or a client certificate ('mutualTLS' requires OpenAPI 3.1).
+func newOpenAPIDocument(authMode string) map[string]interface{} {
+	securitySchemeName := "bearerAuth"
+	securityScheme := map[string]string{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"}
+	if authMode == constants.AuthModeMTLS {
+		securitySchemeName = "mutualTLS"
+		securityScheme = map[string]string{"type": "mutualTLS"}
+	}
+
+	schemas := newOpenAPISchemas()
+	errorSchema := schemas.schemaOf(reflect.TypeOf(common.ErrorResponse{}), contentTypeJSON)
+
+	paths := make(map[string]interface{})
+	for _, operation := range openAPIOperations {
+		responses := map[string]interface{}{
+			strconv.Itoa(operation.responseStatus): openAPIResponse(http.StatusText(operation.responseStatus), operation.responseContentType,
+				schemas.bodySchema(operation.responseType, operation.responseContentType)),
+		}
+
+		// the authenticated routes are rate limited (see newRateLimitMiddleware)
+		errorStatuses := operation.errorStatuses
+		if operation.permission != "" {
+			errorStatuses = append([]int{http.StatusUnauthorized}, errorStatuses...)
+			errorStatuses = append(errorStatuses, http.StatusTooManyRequests)
+		}
+		errorStatuses = append(errorStatuses, http.StatusInternalServerError)
+		for _, errorStatus := range errorStatuses {
+			if errorStatus == http.StatusServiceUnavailable && operation.path == "/health/ready" {
+				// the readiness checks are returned instead of an ErrorResponse
+				responses[strconv.Itoa(errorStatus)] = responses[strconv.Itoa(operation.responseStatus)]
+				continue
+			}
+			responses[strconv.Itoa(errorStatus)] = openAPIResponse(http.StatusText(errorStatus), contentTypeJSON, errorSchema)
+		}
+
+		spec := map[string]interface{}{
+			"operationId": operation.operationID,
+			"summary":     operation.summary,
+			"responses":   responses,
+		}
+
+		if operation.permission != "" {
+			spec["description"] = "Requires the '" + operation.permission + "' permission."
+			spec["security"] = []map[string][]string{{securitySchemeName: {}}}
+		} else {
+			spec["security"] = []map[string][]string{}
+		}
+
+		if operation.requestType != nil {
+			spec["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					operation.requestContentType: map[string]interface{}{
+						"schema": schemas.bodySchema(operation.requestType, operation.requestContentType),
+					},
+				},
+			}
+		}
+
+		pathItem, ok := paths[operation.path].(map[string]interface{})
+		if !ok {
+			pathItem = make(map[string]interface{})
+			paths[operation.path] = pathItem
+		}
+		pathItem[strings.ToLower(operation.method)] = spec
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.1.0",
+		"info": map[string]interface{}{
+			"title":       "Trust Agent",
+			"description": "The Trust-Agent's REST API (clients authenticate with a bearer token, or a client certificate when TA_AUTH_MODE is 'mtls').",
+			"version":     util.Version,
+		},
+		"servers": []map[string]interface{}{
+			{"url": "https://{host}:{port}/v2", "variables": map[string]interface{}{
+				"host": map[string]string{"default": "localhost"},
+				"port": map[string]string{"default": "1443"},
+			}},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas.components,
+			"securitySchemes": map[string]interface{}{
+				securitySchemeName: securityScheme,
+			},
+		},
+	}
+}
+
+func openAPIResponse(description string, contentType string, schema map[string]interface{}) map[string]interface{} {
+	response := map[string]interface{}{
+		"description": description,
+	}
+
+	if contentType != "" {
+		response["content"] = map[string]interface{}{
+			contentType: map[string]interface{}{"schema": schema},
+		}
+	}
+
+	return response
+}
+
+// openAPISchemas generates the schemas of request/response types from their json (or xml)
+// tags.  Named struct types are added to the document's components and referenced.
+type openAPISchemas struct {
+	components map[string]interface{}
+	types      map[string]reflect.Type
+}
+
+func newOpenAPISchemas() *openAPISchemas {
+	return &openAPISchemas{
+		components: make(map[string]interface{}),
+		types:      make(map[string]reflect.Type),
+	}
+}
+
+// bodySchema returns the schema of a request/response body ('string' when the body does
+// not have a type, ex. binary or text bodies).
+func (schemas *openAPISchemas) bodySchema(body interface{}, contentType string) map[string]interface{} {
+	if body == nil {
+		if contentType == contentTypeBinary {
+			return map[string]interface{}{"type": "string", "format": "binary"}
+		}
+		return map[string]interface{}{"type": "string"}
+	}
+
+	return schemas.schemaOf(reflect.TypeOf(body), contentType)
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	xmlNameType = reflect.TypeOf(xml.Name{})
+)
+
+func (schemas *openAPISchemas) schemaOf(t reflect.Type, contentType string) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Ptr:
+		return schemas.schemaOf(t.Elem(), contentType)
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return map[string]interface{}{"type": "string", "format": "byte"}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemas.schemaOf(t.Elem(), contentType)}
+	case t.Kind() == reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemas.schemaOf(t.Elem(), contentType)}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		name := schemas.componentName(t)
+		if _, ok := schemas.components[name]; !ok {
+			// add a placeholder before generating the properties of recursive types
+			schemas.components[name] = nil
+			schemas.components[name] = schemas.structSchema(t, contentType)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	case t.Kind() == reflect.Struct:
+		return schemas.structSchema(t, contentType)
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// componentName returns the name of the type in the document's components (qualified
+// with its package when another package has a type with the same name).
+func (schemas *openAPISchemas) componentName(t reflect.Type) string {
+	name := t.Name()
+	if existing, ok := schemas.types[name]; ok && existing != t {
+		name = path.Base(t.PkgPath()) + "." + name
+	}
+
+	schemas.types[name] = t
+	return name
+}
+
+func (schemas *openAPISchemas) structSchema(t reflect.Type, contentType string) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	schema := map[string]interface{}{"type": "object"}
+
+	schemas.addProperties(t, contentType, schema, properties, &required)
+
+	schema["properties"] = properties
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	return schema
+}
+
+func (schemas *openAPISchemas) addProperties(t reflect.Type, contentType string, schema map[string]interface{}, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		tag := field.Tag.Get("json")
+		if contentType == contentTypeXML {
+			tag = field.Tag.Get("xml")
+		}
+
+		options := strings.Split(tag, ",")
+		name := options[0]
+
+		// embedded structs are flattened (like encoding/json and encoding/xml)
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			schemas.addProperties(field.Type, contentType, schema, properties, required)
+			continue
+		}
+
+		if field.PkgPath != "" || name == "-" {
+			continue
+		}
+
+		if field.Type == xmlNameType {
+			if contentType == contentTypeXML && name != "" {
+				schema["xml"] = map[string]string{"name": name}
+			}
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		property := schemas.schemaOf(field.Type, contentType)
+		omitEmpty := false
+		for _, option := range options[1:] {
+			switch option {
+			case "omitempty":
+				omitEmpty = true
+			case "string":
+				property = map[string]interface{}{"type": "string"}
+			case "attr":
+				property = copySchema(property)
+				property["xml"] = map[string]bool{"attribute": true}
+			}
+		}
+
+		properties[name] = property
+		if !omitEmpty && field.Type.Kind() != reflect.Ptr {
+			*required = append(*required, name)
+		}
+	}
+}
+
+func copySchema(schema map[string]interface{}) map[string]interface{} {
+	schemaCopy := make(map[string]interface{}, len(schema)+1)
+	for key, value := range schema {
+		schemaCopy[key] = value
+	}
+	return schemaCopy
+}
+
+// getOpenAPIDocument returns the (json) OpenAPI document generated by newOpenAPIDocument.
+func getOpenAPIDocument(openAPIJSON []byte) endpointHandler {
+	return func(httpWriter http.ResponseWriter, httpRequest *http.Request) error {
+		log := common.GetLogger(httpRequest.Context())
+		log.Trace("resource/openapi:getOpenAPIDocument() Entering")
+		defer log.Trace("resource/openapi:getOpenAPIDocument() Leaving")
+
+		httpWriter.Header().Set("Content-Type", contentTypeJSON)
+		httpWriter.WriteHeader(http.StatusOK)
+		_, _ = bytes.NewBuffer(openAPIJSON).WriteTo(httpWriter)
+		return nil
+	}
+}
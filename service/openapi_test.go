@@ -0,0 +1,513 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"intel/isecl/go-trust-agent/v4/common"
+	"intel/isecl/go-trust-agent/v4/config"
+	"intel/isecl/go-trust-agent/v4/constants"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	taModel "github.com/intel-secl/intel-secl/v4/pkg/model/ta"
+	"github.com/pkg/errors"
+)
+
+// expectedRoutes are the routes of the web service (written out rather than derived from
+// openAPIOperations, so that adding, removing or changing a route fails the tests until the
+// list is updated).
+var expectedRoutes = []struct {
+	method     string
+	path       string
+	permission string
+}{
+	{"GET", "/v2/version", ""},
+	{"GET", "/v2/health/live", ""},
+	{"GET", "/v2/health/ready", ""},
+	{"GET", "/v2/openapi.json", ""},
+	{"GET", "/v2/aik", "aik:retrieve"},
+	{"GET", "/v2/aik/ca", "aik_ca:retrieve"},
+	{"GET", "/v2/ek-certificate", "ek_certificate:retrieve"},
+	{"GET", "/v2/host", "host_info:retrieve"},
+	{"POST", "/v2/tpm/quote", "quote:create"},
+	{"GET", "/v2/tpm/pcrs", "pcrs:retrieve"},
+	{"GET", "/v2/binding-key-certificate", "binding_key:retrieve"},
+	{"POST", "/v2/tag", "deploy_tag:create"},
+	{"GET", "/v2/tag", "tag:retrieve"},
+	{"DELETE", "/v2/tag", "deploy_tag:delete"},
+	{"GET", "/v2/tag/history", "tag:retrieve"},
+	{"POST", "/v2/host/application-measurement", "application_measurement:create"},
+	{"POST", "/v2/deploy/manifest", "deploy_manifest:create"},
+	{"GET", "/v2/tls-certificate", "tls_certificate:retrieve"},
+	{"GET", "/v2/metrics", "metrics:retrieve"},
+}
+
+// TestWebServiceRoutes makes sure that the router and the OpenAPI document contain the
+// expected routes (and no others) with the expected permissions.
+func TestWebServiceRoutes(t *testing.T) {
+	service, err := newWebService(&WebParameters{Port: 1}, &config.Metrics{}, &config.RateLimits{}, &permissionsRequestHandler{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := service.(*trustAgentWebService).router
+	routes := make(map[string]bool)
+	err = router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		methods, err := route.GetMethods()
+		if err != nil {
+			// subrouters (ex. '/v2/') do not have methods
+			return nil
+		}
+
+		pathTemplate, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+
+		for _, method := range methods {
+			routes[method+" "+pathTemplate] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	document := getTestOpenAPIDocument(t, router)
+	paths := document["paths"].(map[string]interface{})
+
+	operations := 0
+	for _, pathItem := range paths {
+		operations += len(pathItem.(map[string]interface{}))
+	}
+	if len(routes) != len(expectedRoutes) || operations != len(expectedRoutes) {
+		t.Errorf("Expected %d routes, the router has %d and the OpenAPI document %d", len(expectedRoutes), len(routes), operations)
+	}
+
+	for _, expected := range expectedRoutes {
+		if !routes[expected.method+" "+expected.path] {
+			t.Errorf("The router does not have the route '%s %s'", expected.method, expected.path)
+		}
+
+		pathItem, _ := paths[strings.TrimPrefix(expected.path, "/v2")].(map[string]interface{})
+		spec, ok := pathItem[strings.ToLower(expected.method)].(map[string]interface{})
+		if !ok {
+			t.Errorf("The OpenAPI document does not contain '%s %s'", expected.method, expected.path)
+			continue
+		}
+
+		description, _ := spec["description"].(string)
+		if expected.permission == "" && description != "" {
+			t.Errorf("Expected '%s %s' not to require a permission, got '%s'", expected.method, expected.path, description)
+		} else if expected.permission != "" && description != "Requires the '"+expected.permission+"' permission." {
+			t.Errorf("Expected '%s %s' to require the '%s' permission, got '%s'", expected.method, expected.path, expected.permission, description)
+		}
+	}
+
+	schemas := document["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	for _, name := range []string{"HostInfo", "Manifest", "Measurement", "TpmQuoteResponse", "ErrorResponse"} {
+		if _, ok := schemas[name]; !ok {
+			t.Errorf("The OpenAPI document does not contain the '%s' schema", name)
+		}
+	}
+
+	securitySchemes := document["components"].(map[string]interface{})["securitySchemes"].(map[string]interface{})
+	if _, ok := securitySchemes["bearerAuth"]; !ok || len(securitySchemes) != 1 {
+		t.Errorf("Expected the 'bearerAuth' security scheme, got %+v", securitySchemes)
+	}
+}
+
+// permissionsRequestHandler only implements the RequestHandler methods that are not called
+// from the endpoints' goroutine (ex. the metrics collectors), the other methods panic.
+type permissionsRequestHandler struct {
+	common.RequestHandler
+}
+
+func (handler *permissionsRequestHandler) GetTpmMetrics() common.TpmBrokerMetrics {
+	return common.TpmBrokerMetrics{}
+}
+
+// TestOpenAPIPermissions makes sure that the routes require the permission in the OpenAPI
+// document (and no other permission) and that the security scheme matches TA_AUTH_MODE.
+func TestOpenAPIPermissions(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "openapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// each client has a single permission (its subject)
+	permissions := make(map[string]bool)
+	policy := "clients:\n"
+	for _, operation := range openAPIOperations {
+		if operation.permission != "" && !permissions[operation.permission] {
+			permissions[operation.permission] = true
+			policy += fmt.Sprintf("- subject: CN=%s\n  permissions: [\"%s\"]\n", operation.permission, operation.permission)
+		}
+	}
+
+	policyFile := filepath.Join(tmpDir, "client-certificate-policy.yml")
+	err = ioutil.WriteFile(policyFile, []byte(policy), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service, err := newWebService(&WebParameters{WebService: config.WebService{Port: 1, AuthMode: constants.AuthModeMTLS}, ClientCertificatePolicyFile: policyFile},
+		&config.Metrics{}, &config.RateLimits{}, &permissionsRequestHandler{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := service.(*trustAgentWebService).router
+	for _, operation := range openAPIOperations {
+		if operation.permission == "" {
+			continue
+		}
+
+		for permission := range permissions {
+			request := httptest.NewRequest(operation.method, "/v2"+operation.path, nil)
+			client := &x509.Certificate{Subject: pkix.Name{CommonName: permission}}
+			request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{client}}}
+
+			// the handlers fail (the RequestHandler is not implemented) after the permission was checked
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+			if permission == operation.permission && recorder.Code == http.StatusUnauthorized {
+				t.Errorf("%s %s should be allowed with the '%s' permission", operation.method, operation.path, permission)
+			} else if permission != operation.permission && recorder.Code != http.StatusUnauthorized {
+				t.Errorf("%s %s should not be allowed with the '%s' permission (status %d)", operation.method, operation.path, permission, recorder.Code)
+			}
+		}
+	}
+
+	document := getTestOpenAPIDocument(t, router)
+	securitySchemes := document["components"].(map[string]interface{})["securitySchemes"].(map[string]interface{})
+	if _, ok := securitySchemes["mutualTLS"]; !ok || len(securitySchemes) != 1 {
+		t.Errorf("Expected the 'mutualTLS' security scheme, got %+v", securitySchemes)
+	}
+
+	aik := document["paths"].(map[string]interface{})["/aik"].(map[string]interface{})["get"].(map[string]interface{})
+	if security := fmt.Sprint(aik["security"]); security != "[map[mutualTLS:[]]]" {
+		t.Errorf("Expected '/aik' to require 'mutualTLS', got %s", security)
+	}
+}
+
+func TestOpenAPISchemas(t *testing.T) {
+	type item struct {
+		Name string `json:"name"`
+	}
+
+	type request struct {
+		item
+		ID       int               `json:"id,string"`
+		Comment  string            `json:"comment,omitempty"`
+		Items    []item            `json:"items"`
+		Labels   map[string]string `json:"labels"`
+		Data     []byte            `json:"data"`
+		internal string
+	}
+
+	schemas := newOpenAPISchemas()
+	ref := schemas.bodySchema(request{}, contentTypeJSON)
+	if ref["$ref"] != "#/components/schemas/request" {
+		t.Fatalf("Expected a reference to the 'request' schema, got %+v", ref)
+	}
+
+	schema := schemas.components["request"].(map[string]interface{})
+	properties := schema["properties"].(map[string]interface{})
+	for _, name := range []string{"name", "id", "comment", "items", "labels", "data"} {
+		if _, ok := properties[name]; !ok {
+			t.Errorf("Expected the '%s' property in %+v", name, properties)
+		}
+	}
+
+	if len(properties) != 6 {
+		t.Errorf("Expected 6 properties, got %+v", properties)
+	}
+
+	if properties["id"].(map[string]interface{})["type"] != "string" {
+		t.Errorf("Expected 'id' to be a string, got %+v", properties["id"])
+	}
+
+	required := schema["required"].([]string)
+	for _, name := range required {
+		if name == "comment" {
+			t.Errorf("Expected 'comment' (omitempty) to be optional")
+		}
+	}
+}
+
+// statusRequestHandler returns 'err' from the RequestHandler's methods (or a valid response
+// when 'err' is nil).
+type statusRequestHandler struct {
+	permissionsRequestHandler
+	err error
+}
+
+func (handler *statusRequestHandler) GetTpmQuote(ctx context.Context, quoteRequest *taModel.TpmQuoteRequest) (*common.TpmQuoteResponse, error) {
+	if handler.err != nil {
+		return nil, handler.err
+	}
+	return &common.TpmQuoteResponse{}, nil
+}
+
+func (handler *statusRequestHandler) GetHostInfo(ctx context.Context) (*taModel.HostInfo, error) {
+	if handler.err != nil {
+		return nil, handler.err
+	}
+	return &taModel.HostInfo{}, nil
+}
+
+func (handler *statusRequestHandler) GetAikDerBytes(ctx context.Context) ([]byte, error) {
+	if handler.err != nil {
+		return nil, handler.err
+	}
+	return []byte{0x30}, nil
+}
+
+func (handler *statusRequestHandler) GetAikCaDerBytes(ctx context.Context) ([]byte, error) {
+	if handler.err != nil {
+		return nil, handler.err
+	}
+	return []byte{0x30}, nil
+}
+
+func (handler *statusRequestHandler) GetEndorsementKeyCertificates(ctx context.Context) ([]common.EndorsementKeyCertificate, error) {
+	if handler.err != nil {
+		return nil, handler.err
+	}
+	return []common.EndorsementKeyCertificate{}, nil
+}
+
+func (handler *statusRequestHandler) DeployAssetTag(ctx context.Context, tagWriteRequest *taModel.TagWriteRequest, caller string) error {
+	return handler.err
+}
+
+func (handler *statusRequestHandler) GetBindingCertificateDerBytes(ctx context.Context) ([]byte, error) {
+	if handler.err != nil {
+		return nil, handler.err
+	}
+	return []byte("-----BEGIN CERTIFICATE-----"), nil
+}
+
+func (handler *statusRequestHandler) DeploySoftwareManifest(ctx context.Context, manifest *taModel.Manifest) error {
+	return handler.err
+}
+
+func (handler *statusRequestHandler) GetApplicationMeasurement(ctx context.Context, manifest *taModel.Manifest) (*taModel.Measurement, error) {
+	if handler.err != nil {
+		return nil, handler.err
+	}
+	return &taModel.Measurement{}, nil
+}
+
+func (handler *statusRequestHandler) GetTpmPcrs(ctx context.Context) (*common.TpmPcrs, error) {
+	if handler.err != nil {
+		return nil, handler.err
+	}
+	return &common.TpmPcrs{}, nil
+}
+
+func (handler *statusRequestHandler) GetAssetTag(ctx context.Context) (*common.AssetTag, error) {
+	if handler.err != nil {
+		return nil, handler.err
+	}
+	return &common.AssetTag{}, nil
+}
+
+func (handler *statusRequestHandler) ClearAssetTag(ctx context.Context, caller string) error {
+	return handler.err
+}
+
+func (handler *statusRequestHandler) GetAssetTagHistory(ctx context.Context) ([]common.AssetTagHistoryRecord, error) {
+	if handler.err != nil {
+		return nil, handler.err
+	}
+	return []common.AssetTagHistoryRecord{}, nil
+}
+
+func (handler *statusRequestHandler) GetReadiness(ctx context.Context) *common.HealthStatus {
+	healthStatus := &common.HealthStatus{}
+	healthStatus.AddCheck(common.HealthCheckTpm, handler.err)
+	return healthStatus
+}
+
+// TestOpenAPIResponseStatuses sends requests to each route (valid and invalid requests,
+// unauthenticated and rate limited requests and requests that fail with the errors of the
+// RequestHandler's implementation) and makes sure that the status of each response is
+// declared in the OpenAPI document.
+func TestOpenAPIResponseStatuses(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "openapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	policy := "clients:\n- subject: CN=Admin\n  permissions: ["
+	for i, route := range expectedRoutes {
+		if route.permission != "" {
+			policy += fmt.Sprintf("\"%s\"", route.permission)
+			if i < len(expectedRoutes)-1 {
+				policy += ", "
+			}
+		}
+	}
+	policy += "]\n"
+
+	policyFile := filepath.Join(tmpDir, "client-certificate-policy.yml")
+	err = ioutil.WriteFile(policyFile, []byte(policy), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	webParameters := &WebParameters{
+		WebService:                  config.WebService{Port: 1, AuthMode: constants.AuthModeMTLS},
+		ClientCertificatePolicyFile: policyFile,
+		TLSCertFilePath:             filepath.Join(tmpDir, "tls-cert.pem"),
+	}
+
+	// the errors returned by the implementation of each RequestHandler method (see the
+	// EndpointErrors in the common package), in addition to unexpected errors (500)
+	tpmUnavailable := &common.EndpointError{Message: "The TPM is busy", StatusCode: http.StatusServiceUnavailable, Code: common.ErrorCodeTpmUnavailable}
+	tpmTimeout := &common.EndpointError{Message: "Timed out", StatusCode: http.StatusServiceUnavailable, Code: common.ErrorCodeTimeout}
+	aikMissing := &common.EndpointError{Message: "No AIK", StatusCode: http.StatusNotFound, Code: common.ErrorCodeAikMissing}
+	invalidManifest := &common.EndpointError{Message: "Invalid manifest", StatusCode: http.StatusBadRequest, Code: common.ErrorCodeInvalidManifest}
+	tagIndexMissing := &common.EndpointError{Message: "No tag index", StatusCode: http.StatusInternalServerError, Code: common.ErrorCodeTagIndexMissing}
+	handlerErrors := map[string][]error{
+		"getReadiness":                  {errors.New("the TPM could not be opened")},
+		"getAik":                        {aikMissing},
+		"getAikCa":                      {&common.EndpointError{Message: "No privacy-ca", StatusCode: http.StatusNotFound, Code: common.ErrorCodePrivacyCaMissing}},
+		"getEndorsementKeyCertificates": {tpmUnavailable, tpmTimeout},
+		"getTpmQuote": {
+			&common.EndpointError{Message: "No nonce", StatusCode: http.StatusBadRequest},
+			aikMissing,
+			&common.EndpointError{Message: "Renewing the AIK", StatusCode: http.StatusServiceUnavailable, Code: common.ErrorCodeAikRenewalInProgress},
+			tpmUnavailable, tpmTimeout,
+		},
+		"getTpmPcrs":               {tpmUnavailable, tpmTimeout},
+		"getBindingKeyCertificate": {&common.EndpointError{Message: "No binding key", StatusCode: http.StatusNotFound, Code: common.ErrorCodeBindingKeyMissing}},
+		"setAssetTag": {
+			&common.EndpointError{Message: "Invalid tag", StatusCode: http.StatusBadRequest, Code: common.ErrorCodeInvalidTag},
+			&common.EndpointError{Message: "Another host", StatusCode: http.StatusConflict, Code: common.ErrorCodeHardwareUUIDMismatch},
+			&common.EndpointError{Message: "Legacy index", StatusCode: http.StatusConflict, Code: common.ErrorCodeTagIndexUnsupported},
+			tagIndexMissing, tpmUnavailable, tpmTimeout,
+		},
+		"getAssetTag":               {tpmUnavailable, tpmTimeout},
+		"clearAssetTag":             {tagIndexMissing, tpmUnavailable, tpmTimeout},
+		"getApplicationMeasurement": {invalidManifest, &common.EndpointError{Message: "Timed out", StatusCode: http.StatusServiceUnavailable, Code: common.ErrorCodeTimeout}},
+		"deployManifest":            {invalidManifest},
+	}
+
+	// the bodies of valid requests and of requests that the endpoints reject
+	manifest := `<Manifest xmlns="lib:wml:manifests:1.0" Label="test" Uuid="7a569dad-2d82-49e4-9156-069b0065b262" DigestAlg="SHA384"></Manifest>`
+	type requestBody struct {
+		contentType string
+		body        string
+	}
+	validBodies := map[string]requestBody{
+		"getTpmQuote":               {"application/json", `{"nonce": "dGVzdA==", "pcrs": [0], "pcrbanks": ["SHA256"]}`},
+		"setAssetTag":               {"application/json", `{"tag": "dGFn", "hardware_uuid": "7a569dad-2d82-49e4-9156-069b0065b262"}`},
+		"getApplicationMeasurement": {"application/xml", manifest},
+		"deployManifest":            {"application/xml", manifest},
+	}
+	invalidBodies := []requestBody{{"text/plain", "test"}, {"application/json", "{"}, {"application/xml", "<"}}
+
+	for _, rateLimits := range []config.RateLimits{{}, {
+		Quote:                  config.RateLimit{RequestsPerSecond: 0.001, Burst: 1},
+		ApplicationMeasurement: config.RateLimit{RequestsPerSecond: 0.001, Burst: 1},
+		Default:                config.RateLimit{RequestsPerSecond: 0.001, Burst: 1},
+	}} {
+		requestHandler := &statusRequestHandler{}
+		service, err := newWebService(webParameters, &config.Metrics{}, &rateLimits, requestHandler)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		router := service.(*trustAgentWebService).router
+		paths := getTestOpenAPIDocument(t, router)["paths"].(map[string]interface{})
+
+		for _, operation := range openAPIOperations {
+			spec := paths[operation.path].(map[string]interface{})[strings.ToLower(operation.method)].(map[string]interface{})
+			responses := spec["responses"].(map[string]interface{})
+
+			send := func(name string, body requestBody, authenticated bool, err error) {
+				requestHandler.err = err
+
+				request := httptest.NewRequest(operation.method, "/v2"+operation.path, strings.NewReader(body.body))
+				if body.contentType != "" {
+					request.Header.Set("Content-Type", body.contentType)
+				}
+				if authenticated {
+					client := &x509.Certificate{Subject: pkix.Name{CommonName: "Admin"}}
+					request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{client}}}
+				}
+
+				recorder := httptest.NewRecorder()
+				router.ServeHTTP(recorder, request)
+				if _, ok := responses[strconv.Itoa(recorder.Code)]; !ok {
+					t.Errorf("%s %s (%s) returned %d, which is not declared in the OpenAPI document", operation.method, operation.path, name, recorder.Code)
+				}
+			}
+
+			valid := validBodies[operation.operationID]
+			if rateLimits.Default.RequestsPerSecond > 0 {
+				if operation.permission != "" {
+					send("valid request", valid, true, nil)
+					send("rate limited", valid, true, nil)
+				}
+				continue
+			}
+
+			send("valid request", valid, true, nil)
+			send("unexpected error", valid, true, errors.New("unexpected error"))
+			for _, err := range handlerErrors[operation.operationID] {
+				send(err.Error(), valid, true, err)
+			}
+
+			if operation.requestType != nil {
+				for _, invalid := range invalidBodies {
+					send("invalid request body "+invalid.contentType, invalid, true, nil)
+				}
+			}
+
+			if operation.permission != "" {
+				send("unauthenticated", valid, false, nil)
+			}
+		}
+	}
+}
+
+func getTestOpenAPIDocument(t *testing.T, router *mux.Router) map[string]interface{} {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/v2/openapi.json", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected '/v2/openapi.json' to return 200, got %d", recorder.Code)
+	}
+
+	var document map[string]interface{}
+	err := json.Unmarshal(recorder.Body.Bytes(), &document)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if document["openapi"] != "3.1.0" {
+		t.Errorf("Expected an OpenAPI 3.1.0 document, got '%v'", document["openapi"])
+	}
+
+	return document
+}
@@ -3,11 +3,16 @@ package service
 import (
 	"golang.org/x/net/proxy"
 
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"intel/isecl/go-trust-agent/v4/common"
+	"intel/isecl/go-trust-agent/v4/config"
+	"intel/isecl/go-trust-agent/v4/constants"
 	"intel/isecl/go-trust-agent/v4/util"
+	"net/http"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,18 +22,36 @@ import (
 	taModel "github.com/intel-secl/intel-secl/v4/pkg/model/ta"
 
 	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-func newOutboundService(natsParameters *NatsParameters, handler common.RequestHandler) (TrustAgentService, error) {
+func newOutboundService(natsParameters *NatsParameters, metricsParameters *config.Metrics, rateLimits *config.RateLimits, handler common.RequestHandler) (TrustAgentService, error) {
 
 	if natsParameters.HostID == "" {
 		return nil, errors.New("The configuration does not have a 'nats-host-id'.")
 	}
 
-	return &trustAgentOutboundService{
+	subscriber := &trustAgentOutboundService{
 		handler:        handler,
 		natsParameters: *natsParameters,
-	}, nil
+		rateLimiter:    newRateLimiter(*rateLimits),
+	}
+
+	natsConnected := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "nats_connected",
+		Help:      "1 when the Trust-Agent is connected to a nats server, otherwise 0.",
+	}, func() float64 {
+		if subscriber.natsConnection != nil && subscriber.natsConnection.Conn.IsConnected() {
+			return 1
+		}
+		return 0
+	})
+
+	metricsRegistry := newMetricsRegistry(handler, natsRequestsTotal, natsRequestDuration, natsConnected)
+	subscriber.metricsServer = newMetricsServer(metricsParameters.Port, metricsRegistry)
+
+	return subscriber, nil
 
 }
 
@@ -36,6 +59,8 @@ type trustAgentOutboundService struct {
 	natsConnection *nats.EncodedConn
 	handler        common.RequestHandler
 	natsParameters NatsParameters
+	metricsServer  *http.Server
+	rateLimiter    *rateLimiter
 }
 
 func (subscriber *trustAgentOutboundService) Start() error {
@@ -97,153 +122,340 @@ func (subscriber *trustAgentOutboundService) Start() error {
 		log.WithError(err).Error("NATs failed to create encoded connection")
 	}
 
+	// the metrics server is started after natsConnection is assigned since the
+	// 'nats_connected' gauge reads it from the server's goroutines
+	startMetricsServer(subscriber.metricsServer)
+
 	// subscribe to quote-request messages
 	quoteSubject := taModel.CreateSubject(subscriber.natsParameters.HostID, taModel.NatsQuoteRequest)
-	_, err = subscriber.natsConnection.Subscribe(quoteSubject, func(subject string, reply string,
-		quoteRequest *taModel.TpmQuoteRequest) error {
+	_, err = subscriber.natsConnection.Subscribe(quoteSubject, subscriber.rateLimited(func(m *nats.Msg) error {
 		defer recoverFunc()
+		ctx, cancel := subscriber.newRequestContext(m)
+		defer cancel()
 
-		quoteResponse, err := subscriber.handler.GetTpmQuote(quoteRequest)
+		var quoteRequest taModel.TpmQuoteRequest
+		err := subscriber.decode(m, &quoteRequest)
 		if err != nil {
-			log.WithError(err).Error("Failed to handle quote-request")
-			return err
+			common.GetLogger(ctx).WithError(err).Error("Failed to decode quote-request")
+			return subscriber.publishError(ctx, m.Reply, err)
 		}
 
-		return subscriber.natsConnection.Publish(reply, quoteResponse)
-	})
+		quoteResponse, err := subscriber.handler.GetTpmQuote(ctx, &quoteRequest)
+		if err != nil {
+			common.GetLogger(ctx).WithError(err).Error("Failed to handle quote-request")
+			return subscriber.publishError(ctx, m.Reply, err)
+		}
+
+		return subscriber.publish(ctx, m.Reply, quoteResponse)
+	}))
 	if err != nil {
 		return errors.Wrapf(err, "NATs client failed to create subscription to quote-request messages")
 	}
 
 	//subscribe to host-info request messages
 	hostInfoSubject := taModel.CreateSubject(subscriber.natsParameters.HostID, taModel.NatsHostInfoRequest)
-	_, err = subscriber.natsConnection.Subscribe(hostInfoSubject, func(m *nats.Msg) error {
+	_, err = subscriber.natsConnection.Subscribe(hostInfoSubject, subscriber.rateLimited(func(m *nats.Msg) error {
 		defer recoverFunc()
+		ctx, cancel := subscriber.newRequestContext(m)
+		defer cancel()
 
-		hostInfo, err := subscriber.handler.GetHostInfo()
+		hostInfo, err := subscriber.handler.GetHostInfo(ctx)
 		if err != nil {
-			log.WithError(err).Error("Failed to handle host-info")
-			return err
+			common.GetLogger(ctx).WithError(err).Error("Failed to handle host-info")
+			return subscriber.publishError(ctx, m.Reply, err)
 		}
 
-		return subscriber.natsConnection.Publish(m.Reply, hostInfo)
-	})
+		return subscriber.publish(ctx, m.Reply, hostInfo)
+	}))
 	if err != nil {
 		return errors.Wrapf(err, "NATs client failed to create subscription to host-info messages")
 	}
 
 	// subscribe to aik request messages
 	aikSubject := taModel.CreateSubject(subscriber.natsParameters.HostID, taModel.NatsAikRequest)
-	_, err = subscriber.natsConnection.Subscribe(aikSubject, func(m *nats.Msg) error {
+	_, err = subscriber.natsConnection.Subscribe(aikSubject, subscriber.rateLimited(func(m *nats.Msg) error {
 		defer recoverFunc()
+		ctx, cancel := subscriber.newRequestContext(m)
+		defer cancel()
 
-		aik, err := subscriber.handler.GetAikDerBytes()
+		aik, err := subscriber.handler.GetAikDerBytes(ctx)
 		if err != nil {
-			log.WithError(err).Error("Failed to handle aik-request")
-			return err
+			common.GetLogger(ctx).WithError(err).Error("Failed to handle aik-request")
+			return subscriber.publishError(ctx, m.Reply, err)
 		}
 
-		return subscriber.natsConnection.Publish(m.Reply, aik)
-	})
+		return subscriber.publish(ctx, m.Reply, aik)
+	}))
 	if err != nil {
 		return errors.Wrapf(err, "NATs client failed to create subscription to aik-request messages")
 	}
 
+	// subscribe to aik ca request messages
+	aikCaSubject := taModel.CreateSubject(subscriber.natsParameters.HostID, constants.NatsAikCaRequest)
+	_, err = subscriber.natsConnection.Subscribe(aikCaSubject, subscriber.rateLimited(func(m *nats.Msg) error {
+		defer recoverFunc()
+		ctx, cancel := subscriber.newRequestContext(m)
+		defer cancel()
+
+		aikCa, err := subscriber.handler.GetAikCaDerBytes(ctx)
+		if err != nil {
+			common.GetLogger(ctx).WithError(err).Error("Failed to handle aik-ca-request")
+			return subscriber.publishError(ctx, m.Reply, err)
+		}
+
+		return subscriber.publish(ctx, m.Reply, aikCa)
+	}))
+	if err != nil {
+		return errors.Wrapf(err, "NATs client failed to create subscription to aik-ca-request messages")
+	}
+
 	// subscribe to deploy asset tag request messages
 	deployTagSubject := taModel.CreateSubject(subscriber.natsParameters.HostID, taModel.NatsDeployAssetTagRequest)
-	_, err = subscriber.natsConnection.Subscribe(deployTagSubject, func(subject string, reply string, tagWriteRequest *taModel.TagWriteRequest) error {
+	_, err = subscriber.natsConnection.Subscribe(deployTagSubject, subscriber.rateLimited(func(m *nats.Msg) error {
 		defer recoverFunc()
+		ctx, cancel := subscriber.newRequestContext(m)
+		defer cancel()
 
-		err := subscriber.handler.DeployAssetTag(tagWriteRequest)
+		var tagWriteRequest taModel.TagWriteRequest
+		err := subscriber.decode(m, &tagWriteRequest)
 		if err != nil {
-			log.WithError(err).Error("Failed to handle deploy-asset-tag")
-			return err
+			common.GetLogger(ctx).WithError(err).Error("Failed to decode deploy-asset-tag")
+			return subscriber.publishError(ctx, m.Reply, err)
 		}
 
-		return subscriber.natsConnection.Publish(reply, "")
-	})
+		err = subscriber.handler.DeployAssetTag(ctx, &tagWriteRequest, natsCaller)
+		if err != nil {
+			common.GetLogger(ctx).WithError(err).Error("Failed to handle deploy-asset-tag")
+			return subscriber.publishError(ctx, m.Reply, err)
+		}
+
+		return subscriber.publish(ctx, m.Reply, "")
+	}))
 	if err != nil {
 		return errors.Wrapf(err, "NATs client failed to create subscription to deploy-asset-tag messages")
 	}
 
 	// subscribe to binding key request messages
 	bkSubject := taModel.CreateSubject(subscriber.natsParameters.HostID, taModel.NatsBkRequest)
-	_, err = subscriber.natsConnection.Subscribe(bkSubject, func(m *nats.Msg) error {
+	_, err = subscriber.natsConnection.Subscribe(bkSubject, subscriber.rateLimited(func(m *nats.Msg) error {
 		defer recoverFunc()
+		ctx, cancel := subscriber.newRequestContext(m)
+		defer cancel()
 
-		bk, err := subscriber.handler.GetBindingCertificateDerBytes()
+		bk, err := subscriber.handler.GetBindingCertificateDerBytes(ctx)
 		if err != nil {
-			log.WithError(err).Error("Failed to handle get-binding-certificate")
-			return err
+			common.GetLogger(ctx).WithError(err).Error("Failed to handle get-binding-certificate")
+			return subscriber.publishError(ctx, m.Reply, err)
 		}
 
-		return subscriber.natsConnection.Publish(m.Reply, bk)
-	})
+		return subscriber.publish(ctx, m.Reply, bk)
+	}))
 	if err != nil {
 		return errors.Wrapf(err, "NATs client failed to create subscription to binding-key-request messages")
 	}
 
 	// subscribe to deploy manifest request messages
 	deployManifestSubject := taModel.CreateSubject(subscriber.natsParameters.HostID, taModel.NatsDeployManifestRequest)
-	_, err = subscriber.natsConnection.Subscribe(deployManifestSubject, func(subject string, reply string, manifest *taModel.Manifest) error {
+	_, err = subscriber.natsConnection.Subscribe(deployManifestSubject, subscriber.rateLimited(func(m *nats.Msg) error {
 		defer recoverFunc()
+		ctx, cancel := subscriber.newRequestContext(m)
+		defer cancel()
 
-		err = subscriber.handler.DeploySoftwareManifest(manifest)
+		var manifest taModel.Manifest
+		err := subscriber.decode(m, &manifest)
 		if err != nil {
-			log.WithError(err).Error("Failed to handle deploy-manifest")
-			return err
+			common.GetLogger(ctx).WithError(err).Error("Failed to decode deploy-manifest")
+			return subscriber.publishError(ctx, m.Reply, err)
 		}
 
-		return subscriber.natsConnection.Publish(reply, "")
-	})
+		err = subscriber.handler.DeploySoftwareManifest(ctx, &manifest)
+		if err != nil {
+			common.GetLogger(ctx).WithError(err).Error("Failed to handle deploy-manifest")
+			return subscriber.publishError(ctx, m.Reply, err)
+		}
+
+		return subscriber.publish(ctx, m.Reply, "")
+	}))
 	if err != nil {
 		return errors.Wrapf(err, "NATs client failed to create subscription to deploy-manifest messages")
 	}
 
 	// subscribe to application measurement request messages
 	applicationMeasurementSubject := taModel.CreateSubject(subscriber.natsParameters.HostID, taModel.NatsApplicationMeasurementRequest)
-	_, err = subscriber.natsConnection.Subscribe(applicationMeasurementSubject, func(subject string, reply string, manifest *taModel.Manifest) error {
+	_, err = subscriber.natsConnection.Subscribe(applicationMeasurementSubject, subscriber.rateLimited(func(m *nats.Msg) error {
 		defer recoverFunc()
+		ctx, cancel := subscriber.newRequestContext(m)
+		defer cancel()
 
-		measurement, err := subscriber.handler.GetApplicationMeasurement(manifest)
+		var manifest taModel.Manifest
+		err := subscriber.decode(m, &manifest)
 		if err != nil {
-			log.WithError(err).Error("Failed to handle application-measurement-request")
-			return err
+			common.GetLogger(ctx).WithError(err).Error("Failed to decode application-measurement-request")
+			return subscriber.publishError(ctx, m.Reply, err)
 		}
 
-		return subscriber.natsConnection.Publish(reply, measurement)
-	})
+		measurement, err := subscriber.handler.GetApplicationMeasurement(ctx, &manifest)
+		if err != nil {
+			common.GetLogger(ctx).WithError(err).Error("Failed to handle application-measurement-request")
+			return subscriber.publishError(ctx, m.Reply, err)
+		}
+
+		return subscriber.publish(ctx, m.Reply, measurement)
+	}))
 	if err != nil {
 		return err
 	}
 
 	// subscribe to version requests
 	versionSubject := taModel.CreateSubject(subscriber.natsParameters.HostID, taModel.NatsVersionRequest)
-	_, err = subscriber.natsConnection.Subscribe(versionSubject, func(m *nats.Msg) error {
+	_, err = subscriber.natsConnection.Subscribe(versionSubject, subscriber.rateLimited(func(m *nats.Msg) error {
 		defer recoverFunc()
+		ctx, cancel := subscriber.newRequestContext(m)
+		defer cancel()
 
 		versionInfo, err := util.GetVersionInfo()
 		if err != nil {
-			log.WithError(err).Error("Failed to handle version-request")
+			common.GetLogger(ctx).WithError(err).Error("Failed to handle version-request")
 		}
 
-		return subscriber.natsConnection.Publish(m.Reply, versionInfo)
-	})
+		return subscriber.publish(ctx, m.Reply, versionInfo)
+	}))
 	if err != nil {
 		return errors.Wrapf(err, "NATs client failed to create subscription to version messages")
 	}
 
+	// subscribe to readiness requests (the same checks as GET /v2/health/ready, except
+	// the TLS certificate that is not used in outbound mode)
+	readySubject := taModel.CreateSubject(subscriber.natsParameters.HostID, constants.NatsReadyRequest)
+	_, err = subscriber.natsConnection.Subscribe(readySubject, subscriber.rateLimited(func(m *nats.Msg) error {
+		defer recoverFunc()
+		ctx, cancel := subscriber.newRequestContext(m)
+		defer cancel()
+
+		healthStatus := subscriber.handler.GetReadiness(ctx)
+		if !healthStatus.IsReady() {
+			return subscriber.publishStatus(ctx, m.Reply, http.StatusServiceUnavailable, healthStatus)
+		}
+
+		return subscriber.publish(ctx, m.Reply, healthStatus)
+	}))
+	if err != nil {
+		return errors.Wrapf(err, "NATs client failed to create subscription to ready-request messages")
+	}
+
 	if conn.IsConnected() {
 		log.Infof("Outbound Trust-Agent %q connected to %q", subscriber.natsParameters.HostID, subscriber.natsConnection.Conn.ConnectedAddr())
 	}
 	return nil
 }
 
+// Reload does nothing: the outbound service does not serve a TLS certificate and the NATS
+// credentials are read when connecting.
+func (subscriber *trustAgentOutboundService) Reload() error {
+	log.Info("resource/outbound_service:Reload() There is nothing to reload in outbound mode")
+	return nil
+}
+
 func (subscriber *trustAgentOutboundService) Stop() error {
-	subscriber.natsConnection.Close()
+	stopMetricsServer(subscriber.metricsServer)
+	if subscriber.natsConnection != nil {
+		// nil when Start failed to connect
+		subscriber.natsConnection.Close()
+	}
+	return nil
+}
+
+// newRequestContext returns the context of a NATS request, which contains the X-Request-ID
+// from the message's headers (or a new id when the requester did not provide one) and
+// expires after the request timeout (TA_NATS_REQUEST_TIMEOUT).  The request is observed
+// by the nats metrics when the reply is published.
+func (subscriber *trustAgentOutboundService) newRequestContext(m *nats.Msg) (context.Context, context.CancelFunc) {
+	requestID := m.Header.Get(requestIDHeader)
+	if !common.IsValidRequestID(requestID) {
+		requestID = common.NewRequestID()
+	}
+
+	timeout := subscriber.natsParameters.RequestTimeout
+	if timeout <= 0 {
+		timeout = constants.DefaultNatsRequestTimeout
+	}
+
+	ctx := withNatsRequest(common.WithRequestID(context.Background(), requestID), m.Subject)
+	return context.WithTimeout(ctx, timeout)
+}
+
+// decode unmarshals the request in the message's data (using the encoded connection's
+// 'json' encoder).
+func (subscriber *trustAgentOutboundService) decode(m *nats.Msg, v interface{}) error {
+	err := subscriber.natsConnection.Enc.Decode(m.Subject, m.Data, v)
+	if err != nil {
+		return &common.EndpointError{Message: "Invalid request: " + err.Error(), StatusCode: http.StatusBadRequest}
+	}
+
 	return nil
 }
 
+// publish replies to a request with 'v' (encoded the same way as EncodedConn.Publish).
+func (subscriber *trustAgentOutboundService) publish(ctx context.Context, reply string, v interface{}) error {
+	return subscriber.publishStatus(ctx, reply, http.StatusOK, v)
+}
+
+// publishStatus replies with 'v' and adds the status header when the status is not 200
+// (ex. 503 when the Trust-Agent is not ready).
+func (subscriber *trustAgentOutboundService) publishStatus(ctx context.Context, reply string, statusCode int, v interface{}) error {
+	data, err := subscriber.natsConnection.Enc.Encode(reply, v)
+	if err != nil {
+		return errors.Wrap(err, "Failed to encode reply")
+	}
+
+	var header nats.Header
+	if statusCode != http.StatusOK {
+		header = nats.Header{}
+		header.Set(constants.NatsStatusHeader, strconv.Itoa(statusCode))
+	}
+
+	observeNatsRequest(ctx, statusCode)
+	return subscriber.publishMsg(ctx, reply, data, header)
+}
+
+// publishError replies to a request that failed with an empty body and the status and
+// error code in the message's headers.  The body is not a json ErrorResponse since HVS
+// decodes the reply into the subject's response (ex. TpmQuoteResponse): an empty body fails
+// to decode instead of being read as an empty response.
+func (subscriber *trustAgentOutboundService) publishError(ctx context.Context, reply string, err error) error {
+	statusCode, errorResponse := common.NewErrorResponse(err, common.GetRequestID(ctx))
+	observeNatsRequest(ctx, statusCode)
+	if reply == "" {
+		return err
+	}
+
+	header := nats.Header{}
+	header.Set(constants.NatsStatusHeader, strconv.Itoa(statusCode))
+	header.Set(constants.NatsErrorCodeHeader, string(errorResponse.Code))
+	return subscriber.publishMsg(ctx, reply, nil, header)
+}
+
+// publishMsg sends the reply with the request's X-Request-ID header.  Headers require
+// NATS server v2.2+, so the reply is sent without headers when they are not supported.
+func (subscriber *trustAgentOutboundService) publishMsg(ctx context.Context, reply string, data []byte, header nats.Header) error {
+	msg := nats.NewMsg(reply)
+	for key, values := range header {
+		msg.Header[key] = values
+	}
+	msg.Header.Set(requestIDHeader, common.GetRequestID(ctx))
+	msg.Data = data
+
+	err := subscriber.natsConnection.Conn.PublishMsg(msg)
+	if err == nats.ErrHeadersNotSupported {
+		err = subscriber.natsConnection.Conn.Publish(reply, data)
+	}
+	if err != nil {
+		common.GetLogger(ctx).WithError(err).Error("Failed to publish reply")
+	}
+
+	return err
+}
+
 func recoverFunc() {
 	if err := recover(); err != nil {
 		log.Errorf("Panic occurred: %+v\n%s", err, string(debug.Stack()))
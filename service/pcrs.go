@@ -0,0 +1,47 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"intel/isecl/go-trust-agent/v4/common"
+	"net/http"
+
+	"github.com/intel-secl/intel-secl/v4/pkg/lib/common/log/message"
+	"github.com/pkg/errors"
+)
+
+// getTpmPcrs returns the (briefly cached) PCR values of the active PCR banks.  It is
+// intended for health probes that do not need a nonce/quote.
+func getTpmPcrs(requestHandler common.RequestHandler) endpointHandler {
+	return func(httpWriter http.ResponseWriter, httpRequest *http.Request) error {
+		log := common.GetLogger(httpRequest.Context())
+		log.Trace("resource/pcrs:getTpmPcrs() Entering")
+		defer log.Trace("resource/pcrs:getTpmPcrs() Leaving")
+
+		log.Debugf("resource/pcrs:getTpmPcrs() Request: %s", httpRequest.URL.Path)
+
+		tpmPcrs, err := requestHandler.GetTpmPcrs(httpRequest.Context())
+		if endpointError, ok := errors.Cause(err).(*common.EndpointError); ok {
+			log.WithError(err).Errorf("resource/pcrs:getTpmPcrs() %s - Error reading pcrs", message.AppRuntimeErr)
+			return endpointError
+		} else if err != nil {
+			log.WithError(err).Errorf("resource/pcrs:getTpmPcrs() %s - Error reading pcrs", message.AppRuntimeErr)
+			return &common.EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError}
+		}
+
+		tpmPcrsJSON, err := json.Marshal(tpmPcrs)
+		if err != nil {
+			log.WithError(err).Errorf("resource/pcrs:getTpmPcrs() %s - There was an error marshaling pcrs", message.AppRuntimeErr)
+			return &common.EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError}
+		}
+
+		httpWriter.Header().Set("Content-Type", "application/json")
+		httpWriter.WriteHeader(http.StatusOK)
+		_, _ = bytes.NewBuffer(tpmPcrsJSON).WriteTo(httpWriter)
+		return nil
+	}
+}
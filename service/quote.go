@@ -15,10 +15,12 @@ import (
 
 	"github.com/intel-secl/intel-secl/v4/pkg/lib/common/log/message"
 	taModel "github.com/intel-secl/intel-secl/v4/pkg/model/ta"
+	"github.com/pkg/errors"
 )
 
 func getTpmQuote(requestHandler common.RequestHandler) endpointHandler {
 	return func(httpWriter http.ResponseWriter, httpRequest *http.Request) error {
+		log := common.GetLogger(httpRequest.Context())
 		log.Trace("resource/quote:getTpmQuote() Entering")
 		defer log.Trace("resource/quote:getTpmQuote() Leaving")
 
@@ -47,8 +49,11 @@ func getTpmQuote(requestHandler common.RequestHandler) endpointHandler {
 
 		}
 
-		tpmQuoteResponse, err := requestHandler.GetTpmQuote(&tpmQuoteRequest)
-		if err != nil {
+		tpmQuoteResponse, err := requestHandler.GetTpmQuote(httpRequest.Context(), &tpmQuoteRequest)
+		if endpointError, ok := errors.Cause(err).(*common.EndpointError); ok {
+			log.WithError(err).Errorf("resource/quote:getTpmQuote() %s - Invalid tpm quote request", message.InvalidInputBadParam)
+			return endpointError
+		} else if err != nil {
 			log.WithError(err).Errorf("resource/quote:getTpmQuote() %s - There was an error collecting the tpm quote", message.AppRuntimeErr)
 			return &common.EndpointError{Message: "There was an error collecting the tpm quote", StatusCode: http.StatusInternalServerError}
 		}
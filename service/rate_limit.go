@@ -0,0 +1,222 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package service
+
+import (
+	"intel/isecl/go-trust-agent/v4/common"
+	"intel/isecl/go-trust-agent/v4/config"
+	"intel/isecl/go-trust-agent/v4/constants"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/intel-secl/intel-secl/v4/pkg/lib/common/log/message"
+	taModel "github.com/intel-secl/intel-secl/v4/pkg/model/ta"
+	"github.com/nats-io/nats.go"
+)
+
+// natsCaller is the caller of NATS requests, which do not identify the requester (the
+// NATS server authorizes HVS to publish to the Trust-Agent's subjects).
+const natsCaller = "nats"
+
+// natsRequestCaller returns the caller of a NATS request for the rate limits.  nats.go
+// clients reply to '_INBOX.<connection>.<request>', so the requests of each connection (ex.
+// each HVS instance) are limited as a caller.  Requests with other reply subjects share the
+// 'nats' caller, i.e. their per-caller limits apply to the subject.
+func natsRequestCaller(reply string) string {
+	tokens := strings.Split(strings.TrimPrefix(reply, nats.InboxPrefix), ".")
+	if !strings.HasPrefix(reply, nats.InboxPrefix) || len(tokens) != 2 {
+		return natsCaller
+	}
+
+	return natsCaller + ":" + tokens[0]
+}
+
+// rateLimiter applies the configured RateLimit of a route (ex. '/v2/tpm/quote') or NATS
+// request (ex. 'quote-request') to each caller (token bucket and max-in-flight per JWT
+// subject) and to the route (max-in-flight of all callers).
+type rateLimiter struct {
+	limits config.RateLimits
+	now    func() time.Time
+
+	mutex   sync.Mutex
+	routes  map[string]int                // requests in flight by route
+	callers map[string]*callerRateLimiter // by route and caller
+}
+
+type callerRateLimiter struct {
+	tokens   float64
+	updated  time.Time
+	inFlight int
+}
+
+func newRateLimiter(limits config.RateLimits) *rateLimiter {
+	return &rateLimiter{
+		limits:  limits,
+		now:     time.Now,
+		routes:  make(map[string]int),
+		callers: make(map[string]*callerRateLimiter),
+	}
+}
+
+// getRateLimit returns the limits of a web route or NATS request type.
+func (limiter *rateLimiter) getRateLimit(route string) config.RateLimit {
+	switch route {
+	case "/v2/tpm/quote", taModel.NatsQuoteRequest:
+		return limiter.limits.Quote
+	case "/v2/host/application-measurement", taModel.NatsApplicationMeasurementRequest:
+		return limiter.limits.ApplicationMeasurement
+	default:
+		return limiter.limits.Default
+	}
+}
+
+// acquire returns an error (429) when the caller exceeded the route's limits, otherwise the
+// request is counted as 'in flight' until 'release' is called.  'retryAfter' is the time
+// until the caller's next token when the request was rate limited.
+func (limiter *rateLimiter) acquire(route string, caller string) (release func(), retryAfter time.Duration, err error) {
+	rateLimit := limiter.getRateLimit(route)
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := limiter.now()
+	key := route + " " + caller
+	callerLimiter, ok := limiter.callers[key]
+	if !ok {
+		limiter.cleanup()
+		callerLimiter = &callerRateLimiter{
+			tokens:  float64(burst(rateLimit)),
+			updated: now,
+		}
+		limiter.callers[key] = callerLimiter
+	}
+
+	if rateLimit.MaxInFlight > 0 && limiter.routes[route] >= rateLimit.MaxInFlight {
+		return nil, time.Second, &common.EndpointError{Message: "Too many concurrent requests, please retry the request", StatusCode: http.StatusTooManyRequests, Code: common.ErrorCodeRateLimited}
+	}
+
+	if rateLimit.MaxInFlightPerSubject > 0 && callerLimiter.inFlight >= rateLimit.MaxInFlightPerSubject {
+		return nil, time.Second, &common.EndpointError{Message: "Too many concurrent requests from the caller, please retry the request", StatusCode: http.StatusTooManyRequests, Code: common.ErrorCodeRateLimited}
+	}
+
+	if rateLimit.RequestsPerSecond > 0 {
+		callerLimiter.tokens = math.Min(float64(burst(rateLimit)), callerLimiter.tokens+now.Sub(callerLimiter.updated).Seconds()*rateLimit.RequestsPerSecond)
+		callerLimiter.updated = now
+		if callerLimiter.tokens < 1 {
+			retryAfter = time.Duration((1 - callerLimiter.tokens) / rateLimit.RequestsPerSecond * float64(time.Second))
+			return nil, retryAfter, &common.EndpointError{Message: "Too many requests, please retry the request later", StatusCode: http.StatusTooManyRequests, Code: common.ErrorCodeRateLimited}
+		}
+		callerLimiter.tokens--
+	}
+
+	limiter.routes[route]++
+	callerLimiter.inFlight++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			limiter.mutex.Lock()
+			defer limiter.mutex.Unlock()
+			limiter.routes[route]--
+			callerLimiter.inFlight--
+		})
+	}, 0, nil
+}
+
+// cleanup removes the callers that have been idle for a minute (their token buckets are
+// refilled), so that the map of callers doesn't grow without bounds.
+func (limiter *rateLimiter) cleanup() {
+	if len(limiter.callers) < constants.RateLimitCleanupThreshold {
+		return
+	}
+
+	now := limiter.now()
+	for key, callerLimiter := range limiter.callers {
+		if callerLimiter.inFlight == 0 && now.Sub(callerLimiter.updated) > time.Minute {
+			delete(limiter.callers, key)
+		}
+	}
+}
+
+// burst returns the size of the token bucket (at least one request, or the number of
+// requests per second when Burst is not configured).
+func burst(rateLimit config.RateLimit) int {
+	if rateLimit.Burst > 0 {
+		return rateLimit.Burst
+	}
+
+	return int(math.Max(1, math.Ceil(rateLimit.RequestsPerSecond)))
+}
+
+// newRateLimitMiddleware limits the requests of the authenticated callers (the middleware
+// must be added after the authentication middleware, see getCaller) to the router's routes.
+func newRateLimitMiddleware(limiter *rateLimiter) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := r.URL.Path
+			if currentRoute := mux.CurrentRoute(r); currentRoute != nil {
+				if pathTemplate, err := currentRoute.GetPathTemplate(); err == nil {
+					route = pathTemplate
+				}
+			}
+
+			caller := getCaller(r)
+			release, retryAfter, err := limiter.acquire(route, caller)
+			if err != nil {
+				secLog.Warnf("resource/rate_limit:newRateLimitMiddleware() %s - Rate limited %s %s from '%s'", message.PerformanceProblem, r.Method, route, caller)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				statusCode, errorResponse := common.NewErrorResponse(err, getRequestID(r))
+				writeErrorResponse(w, r, statusCode, errorResponse)
+				return
+			}
+			defer release()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimited applies the rate limits of the NATS request type (ex. 'quote-request' from
+// 'trust-agent.<host-id>.quote-request') before calling the subscription's handler.  The
+// nats client delivers the messages of a subscription one at a time, so when the request
+// type has a MaxInFlight limit the handler runs in a goroutine (otherwise requests could not
+// be concurrent and the limit would never apply).  acquire rejects the requests above the
+// limit, which bounds the number of goroutines.  Request types without a MaxInFlight limit
+// are handled by the subscription's goroutine, one at a time.  TPM operations are still
+// serialized by the TpmBroker.
+func (subscriber *trustAgentOutboundService) rateLimited(handler func(m *nats.Msg) error) func(m *nats.Msg) error {
+	return func(m *nats.Msg) error {
+		requestType := natsRequestType(m.Subject)
+		caller := natsRequestCaller(m.Reply)
+		release, _, err := subscriber.rateLimiter.acquire(requestType, caller)
+		if err != nil {
+			ctx, cancel := subscriber.newRequestContext(m)
+			defer cancel()
+			common.GetSecurityLogger(ctx).Warnf("resource/rate_limit:rateLimited() %s - Rate limited NATS %s request from '%s'", message.PerformanceProblem, requestType, caller)
+			return subscriber.publishError(ctx, m.Reply, err)
+		}
+
+		handle := func() {
+			defer release()
+			err := handler(m)
+			if err != nil {
+				log.WithError(err).Debugf("resource/rate_limit:rateLimited() The NATS %s request failed", requestType)
+			}
+		}
+
+		if subscriber.rateLimiter.getRateLimit(requestType).MaxInFlight > 0 {
+			go handle()
+		} else {
+			handle()
+		}
+
+		return nil
+	}
+}
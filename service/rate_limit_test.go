@@ -0,0 +1,210 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package service
+
+import (
+	"encoding/json"
+	"intel/isecl/go-trust-agent/v4/common"
+	"intel/isecl/go-trust-agent/v4/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	taModel "github.com/intel-secl/intel-secl/v4/pkg/model/ta"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	now := time.Now()
+	limiter := newRateLimiter(config.RateLimits{
+		Quote: config.RateLimit{RequestsPerSecond: 2, Burst: 3},
+	})
+	limiter.now = func() time.Time { return now }
+
+	// the burst is allowed, then the caller must wait for a new token (1/2 second)
+	for i := 0; i < 3; i++ {
+		release, _, err := limiter.acquire("/v2/tpm/quote", "jwt:hvs")
+		if err != nil {
+			t.Fatalf("Request %d should not have been rate limited: %+v", i, err)
+		}
+		release()
+	}
+
+	_, retryAfter, err := limiter.acquire("/v2/tpm/quote", "jwt:hvs")
+	if endpointError, ok := err.(*common.EndpointError); !ok || endpointError.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected a 429 error, got %+v", err)
+	}
+	if retryAfter != 500*time.Millisecond {
+		t.Errorf("Expected to retry after 500ms, got %s", retryAfter)
+	}
+
+	// other callers and routes have their own limits
+	if _, _, err := limiter.acquire("/v2/tpm/quote", "jwt:wls"); err != nil {
+		t.Errorf("Another caller should not have been rate limited: %+v", err)
+	}
+	if _, _, err := limiter.acquire("/v2/aik", "jwt:hvs"); err != nil {
+		t.Errorf("Another route should not have been rate limited: %+v", err)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if _, _, err := limiter.acquire("/v2/tpm/quote", "jwt:hvs"); err != nil {
+		t.Errorf("The request should have been allowed after the token was refilled: %+v", err)
+	}
+}
+
+func TestRateLimiterMaxInFlight(t *testing.T) {
+	limiter := newRateLimiter(config.RateLimits{
+		ApplicationMeasurement: config.RateLimit{MaxInFlight: 2, MaxInFlightPerSubject: 1},
+	})
+
+	release, _, err := limiter.acquire(taModel.NatsApplicationMeasurementRequest, "jwt:hvs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := limiter.acquire(taModel.NatsApplicationMeasurementRequest, "jwt:hvs"); err == nil {
+		t.Error("Expected the caller's second concurrent request to be rejected")
+	}
+
+	if _, _, err := limiter.acquire(taModel.NatsApplicationMeasurementRequest, "jwt:wls"); err != nil {
+		t.Errorf("Expected another caller's request to be allowed: %+v", err)
+	}
+
+	if _, _, err := limiter.acquire(taModel.NatsApplicationMeasurementRequest, "jwt:wla"); err == nil {
+		t.Error("Expected the route's third concurrent request to be rejected")
+	}
+
+	// releasing more than once does not change the count
+	release()
+	release()
+	if _, _, err := limiter.acquire(taModel.NatsApplicationMeasurementRequest, "jwt:hvs"); err != nil {
+		t.Errorf("Expected the caller's request to be allowed after the first completed: %+v", err)
+	}
+	if _, _, err := limiter.acquire(taModel.NatsApplicationMeasurementRequest, "jwt:wla"); err == nil {
+		t.Error("Expected the route's third concurrent request to be rejected")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	router := mux.NewRouter()
+	subRouter := router.PathPrefix("/v2/").Subrouter()
+	subRouter.Use(newRateLimitMiddleware(newRateLimiter(config.RateLimits{
+		Quote: config.RateLimit{RequestsPerSecond: 0.5, Burst: 1},
+	})))
+	subRouter.HandleFunc("/tpm/quote", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}).Methods("POST")
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("POST", "/v2/tpm/quote", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the first request to succeed, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("POST", "/v2/tpm/quote", nil))
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected the second request to be rate limited, got %d", recorder.Code)
+	}
+
+	if recorder.Header().Get("Retry-After") != "2" {
+		t.Errorf("Expected 'Retry-After: 2', got '%s'", recorder.Header().Get("Retry-After"))
+	}
+
+	var errorResponse common.ErrorResponse
+	err := json.Unmarshal(recorder.Body.Bytes(), &errorResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if errorResponse.Code != common.ErrorCodeRateLimited {
+		t.Errorf("Expected the %s error code, got %+v", common.ErrorCodeRateLimited, errorResponse)
+	}
+}
+
+func TestNatsRequestCaller(t *testing.T) {
+	tests := map[string]string{
+		"_INBOX.hvs1connection.42":  "nats:hvs1connection",
+		"_INBOX.hvs1connection.43":  "nats:hvs1connection",
+		"_INBOX.oldstylerequest":    natsCaller,
+		"custom.reply.subject.name": natsCaller,
+		"":                          natsCaller,
+	}
+
+	for reply, expected := range tests {
+		if caller := natsRequestCaller(reply); caller != expected {
+			t.Errorf("Expected caller '%s' for reply '%s', got '%s'", expected, reply, caller)
+		}
+	}
+}
+
+// TestRateLimitedNatsRequests makes sure that NATS requests are handled concurrently, so that
+// the MaxInFlight limits apply to the subscriptions.
+func TestRateLimitedNatsRequests(t *testing.T) {
+	subscriber := trustAgentOutboundService{
+		rateLimiter: newRateLimiter(config.RateLimits{
+			Quote: config.RateLimit{MaxInFlight: 2, MaxInFlightPerSubject: 1},
+		}),
+	}
+
+	handled := make(chan string, 3)
+	done := make(chan struct{})
+	handler := subscriber.rateLimited(func(m *nats.Msg) error {
+		handled <- m.Reply
+		<-done
+		return nil
+	})
+
+	subject := taModel.CreateSubject("host-id", taModel.NatsQuoteRequest)
+	for _, reply := range []string{"_INBOX.hvs1.1", "_INBOX.hvs2.1"} {
+		if err := handler(&nats.Msg{Subject: subject, Reply: reply}); err != nil {
+			t.Fatalf("Expected the request from '%s' to be handled: %+v", reply, err)
+		}
+	}
+
+	// both requests are in flight
+	for i := 0; i < 2; i++ {
+		select {
+		case <-handled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("The requests were not handled concurrently")
+		}
+	}
+
+	// requests without a reply are not answered (see publishError)
+	if err := handler(&nats.Msg{Subject: subject}); err == nil {
+		t.Error("Expected the subject's third concurrent request to be rejected")
+	}
+
+	close(done)
+}
+
+// TestRateLimitedNatsRequestsWithoutMaxInFlight makes sure that the requests of subjects
+// without a MaxInFlight limit are handled by the subscription (no goroutine per request).
+func TestRateLimitedNatsRequestsWithoutMaxInFlight(t *testing.T) {
+	subscriber := trustAgentOutboundService{
+		rateLimiter: newRateLimiter(config.RateLimits{}),
+	}
+
+	handled := 0
+	handler := subscriber.rateLimited(func(m *nats.Msg) error {
+		handled++
+		return errors.New("handler error")
+	})
+
+	subject := taModel.CreateSubject("host-id", taModel.NatsVersionRequest)
+	for i := 1; i <= 3; i++ {
+		if err := handler(&nats.Msg{Subject: subject, Reply: "_INBOX.hvs1.1"}); err != nil || handled != i {
+			t.Fatalf("Expected request %d to be handled before rateLimited returned (handled %d, err %v)", i, handled, err)
+		}
+	}
+
+	if inFlight := subscriber.rateLimiter.routes[taModel.NatsVersionRequest]; inFlight != 0 {
+		t.Fatalf("Expected no requests in flight, got %d", inFlight)
+	}
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package service
+
+import (
+	"context"
+	"fmt"
+	"intel/isecl/go-trust-agent/v4/common"
+	"intel/isecl/go-trust-agent/v4/constants"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/handlers"
+	"github.com/intel-secl/intel-secl/v4/pkg/lib/common/log/message"
+)
+
+// requestIDHeader correlates requests (and their log entries) between HVS/WLS and the
+// Trust-Agent.  The header is also used in NATS requests/replies.
+const requestIDHeader = "X-Request-ID"
+
+// newRequestIDHandler uses the X-Request-ID provided by the client (or creates a new
+// one) as the request's id.  The id is added to the request's context (see
+// common.GetLogger), returned in the response's X-Request-ID header and included in the
+// http access log.
+func newRequestIDHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if requestID != "" && !common.IsValidRequestID(requestID) {
+			secLog.Warnf("resource/request_id:newRequestIDHandler() %s - Ignoring invalid %s from %s", message.InvalidInputBadParam, requestIDHeader, r.RemoteAddr)
+			requestID = ""
+		}
+
+		if requestID == "" {
+			requestID = common.NewRequestID()
+		}
+
+		// the (shared) header is also read by the access log after the request completes
+		r.Header.Set(requestIDHeader, requestID)
+		w.Header().Set(requestIDHeader, requestID)
+		next.ServeHTTP(w, r.WithContext(common.WithRequestID(r.Context(), requestID)))
+	})
+}
+
+// newRequestTimeoutHandler adds the deadline (TA_SERVER_REQUEST_TIMEOUT) to the request's
+// context, which is also cancelled when the client disconnects.  RequestHandlers stop
+// waiting for the TPM (and kill 'measure') when the context is done.
+func newRequestTimeoutHandler(timeout time.Duration, next http.Handler) http.Handler {
+	if timeout <= 0 {
+		timeout = constants.DefaultRequestTimeout
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// getRequestID returns the id of the request (see newRequestIDHandler).
+func getRequestID(r *http.Request) string {
+	if requestID := common.GetRequestID(r.Context()); requestID != "" {
+		return requestID
+	}
+
+	if requestID := r.Header.Get(requestIDHeader); common.IsValidRequestID(requestID) {
+		return requestID
+	}
+
+	return common.NewRequestID()
+}
+
+// writeAccessLog is a handlers.LogFormatter that writes entries in the Apache 'combined'
+// format (like handlers.CombinedLoggingHandler) followed by the request's id.
+func writeAccessLog(writer io.Writer, params handlers.LogFormatterParams) {
+	host, _, err := net.SplitHostPort(params.Request.RemoteAddr)
+	if err != nil {
+		host = params.Request.RemoteAddr
+	}
+
+	uri := params.Request.RequestURI
+	if uri == "" {
+		uri = params.URL.RequestURI()
+	}
+
+	_, _ = fmt.Fprintf(writer, "%s - - [%s] %s %d %d %s %s %s=%s\n",
+		host,
+		params.TimeStamp.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(params.Request.Method+" "+uri+" "+params.Request.Proto),
+		params.StatusCode,
+		params.Size,
+		strconv.Quote(params.Request.Referer()),
+		strconv.Quote(params.Request.UserAgent()),
+		common.RequestIDField,
+		params.Request.Header.Get(requestIDHeader))
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"intel/isecl/go-trust-agent/v4/common"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+)
+
+func TestRequestIDHandler(t *testing.T) {
+	var handlerRequestID string
+	router := mux.NewRouter()
+	router.HandleFunc("/v2/tag", errorHandler(func(w http.ResponseWriter, r *http.Request) error {
+		handlerRequestID = common.GetRequestID(r.Context())
+		return &common.EndpointError{Message: "The asset tag nv index does not exist", StatusCode: http.StatusNotFound, Code: common.ErrorCodeTagIndexMissing}
+	})).Methods("GET")
+
+	var accessLog bytes.Buffer
+	handler := handlers.CustomLoggingHandler(&accessLog, newRequestIDHandler(router), writeAccessLog)
+
+	tests := []struct {
+		name              string
+		requestID         string
+		expectedRequestID string
+	}{
+		{"client request id", "hvs-4f0c9a2e", "hvs-4f0c9a2e"},
+		{"no request id", "", ""},
+		{"invalid request id", "bad id\nwith newline", ""},
+	}
+
+	for _, test := range tests {
+		accessLog.Reset()
+		request := httptest.NewRequest("GET", "/v2/tag", nil)
+		if test.requestID != "" {
+			request.Header.Set(requestIDHeader, test.requestID)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		responseRequestID := recorder.Header().Get(requestIDHeader)
+		if test.expectedRequestID != "" {
+			if responseRequestID != test.expectedRequestID {
+				t.Errorf("%s: expected request id '%s', got '%s'", test.name, test.expectedRequestID, responseRequestID)
+			}
+		} else if !common.IsValidRequestID(responseRequestID) || responseRequestID == test.requestID {
+			t.Errorf("%s: expected a new request id, got '%s'", test.name, responseRequestID)
+		}
+
+		if handlerRequestID != responseRequestID {
+			t.Errorf("%s: the handler's request id '%s' does not match the response's '%s'", test.name, handlerRequestID, responseRequestID)
+		}
+
+		var errorResponse common.ErrorResponse
+		err := json.Unmarshal(recorder.Body.Bytes(), &errorResponse)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if recorder.Code != http.StatusNotFound || errorResponse.Code != common.ErrorCodeTagIndexMissing || errorResponse.RequestID != responseRequestID {
+			t.Errorf("%s: unexpected error response %d %+v", test.name, recorder.Code, errorResponse)
+		}
+
+		if !strings.Contains(accessLog.String(), `"GET /v2/tag HTTP/1.1" 404`) || !strings.HasSuffix(accessLog.String(), common.RequestIDField+"="+responseRequestID+"\n") {
+			t.Errorf("%s: unexpected access log entry %q", test.name, accessLog.String())
+		}
+	}
+}
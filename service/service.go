@@ -13,6 +13,7 @@ import (
 type TrustAgentService interface {
 	Start() error
 	Stop() error
+	Reload() error // when the service receives SIGHUP (ex. 'systemctl reload tagent')
 }
 
 type NatsParameters struct {
@@ -23,16 +24,24 @@ type NatsParameters struct {
 
 type WebParameters struct {
 	config.WebService
-	TLSCertFilePath           string
-	TLSKeyFilePath            string
-	TrustedJWTSigningCertsDir string
-	TrustedCaCertsDir         string
+	TLSCertFilePath             string
+	TLSKeyFilePath              string
+	TrustedJWTSigningCertsDir   string
+	TrustedCaCertsDir           string
+	ClientCertificatePolicyFile string
+	CmsBaseURL                  string
+	TLSCertCN                   string
+	TLSCertSAN                  string
+	TLSRenewalToken             string
+	TLSCertRenewalDays          int
 }
 
 type ServiceParameters struct {
 	Mode           string
 	Web            WebParameters
 	Nats           NatsParameters
+	Metrics        config.Metrics
+	RateLimits     config.RateLimits
 	RequestHandler common.RequestHandler
 }
 
@@ -46,7 +55,7 @@ func NewTrustAgentService(parameters *ServiceParameters) (TrustAgentService, err
 
 	if strings.ToLower(parameters.Mode) == constants.CommunicationModeOutbound {
 
-		service, err = newOutboundService(&parameters.Nats, parameters.RequestHandler)
+		service, err = newOutboundService(&parameters.Nats, &parameters.Metrics, &parameters.RateLimits, parameters.RequestHandler)
 		if err != nil {
 			return nil, errors.Wrapf(err, "Error creating the HVS subscriber")
 		}
@@ -58,7 +67,7 @@ func NewTrustAgentService(parameters *ServiceParameters) (TrustAgentService, err
 	} else if parameters.Mode == "" || strings.ToLower(parameters.Mode) == constants.CommunicationModeHttp {
 
 		// create and start webservice
-		service, err = newWebService(&parameters.Web, parameters.RequestHandler)
+		service, err = newWebService(&parameters.Web, &parameters.Metrics, &parameters.RateLimits, parameters.RequestHandler)
 		if err != nil {
 			return nil, errors.Wrapf(err, "Error while creating trustagent service")
 		}
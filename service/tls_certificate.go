@@ -0,0 +1,37 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"intel/isecl/go-trust-agent/v4/common"
+	"net/http"
+
+	"github.com/intel-secl/intel-secl/v4/pkg/lib/common/log/message"
+)
+
+// getTLSCertificateStatus returns the expiry of the service's TLS certificate and the
+// status of automatic renewal (see certificateRenewer).
+func getTLSCertificateStatus(renewer *certificateRenewer) endpointHandler {
+	return func(httpWriter http.ResponseWriter, httpRequest *http.Request) error {
+		log := common.GetLogger(httpRequest.Context())
+		log.Trace("resource/tls_certificate:getTLSCertificateStatus() Entering")
+		defer log.Trace("resource/tls_certificate:getTLSCertificateStatus() Leaving")
+
+		log.Debugf("resource/tls_certificate:getTLSCertificateStatus() Request: %s", httpRequest.URL.Path)
+
+		statusJSON, err := json.Marshal(renewer.Status())
+		if err != nil {
+			log.WithError(err).Errorf("resource/tls_certificate:getTLSCertificateStatus() %s - There was an error marshaling the tls certificate status", message.AppRuntimeErr)
+			return &common.EndpointError{Message: "Error processing request", StatusCode: http.StatusInternalServerError}
+		}
+
+		httpWriter.Header().Set("Content-Type", "application/json")
+		httpWriter.WriteHeader(http.StatusOK)
+		_, _ = bytes.NewBuffer(statusJSON).WriteTo(httpWriter)
+		return nil
+	}
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// certificateReloader provides the web service's TLS certificate (tls.Config.GetCertificate)
+// and reloads it from the certificate/key files when they change (ex. after 'tagent setup
+// update-certificates') or when the service receives SIGHUP (see trustAgentWebService.Reload).
+// A new certificate is only used when the key pair is valid, otherwise the current certificate
+// continues to be served.  An expired certificate is accepted when the service starts so that it
+// can be renewed (see certificateRenewer) or replaced without restarting the service.
+type certificateReloader struct {
+	certFilePath string
+	keyFilePath  string
+
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+	modTime     time.Time // of the files that were last loaded
+	done        chan struct{}
+}
+
+func newCertificateReloader(certFilePath string, keyFilePath string) (*certificateReloader, error) {
+	reloader := certificateReloader{
+		certFilePath: certFilePath,
+		keyFilePath:  keyFilePath,
+	}
+
+	err := reloader.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return &reloader, nil
+}
+
+func (reloader *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.mutex.RLock()
+	defer reloader.mutex.RUnlock()
+	return reloader.certificate, nil
+}
+
+// reload loads and validates the certificate and key and swaps them with the current certificate.
+func (reloader *certificateReloader) reload() error {
+	log.Trace("resource/tls_reloader:reload() Entering")
+	defer log.Trace("resource/tls_reloader:reload() Leaving")
+
+	modTime, err := reloader.filesModTime()
+	if err != nil {
+		return err
+	}
+
+	// LoadX509KeyPair fails if the private key does not match the certificate
+	certificate, err := tls.LoadX509KeyPair(reloader.certFilePath, reloader.keyFilePath)
+	if err != nil {
+		return errors.Wrapf(err, "Error loading the TLS certificate %s and key %s", reloader.certFilePath, reloader.keyFilePath)
+	}
+
+	certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return errors.Wrapf(err, "Error parsing the TLS certificate %s", reloader.certFilePath)
+	}
+
+	now := time.Now()
+	if now.Before(certificate.Leaf.NotBefore) || now.After(certificate.Leaf.NotAfter) {
+		err = errors.Errorf("The TLS certificate %s is not valid (not before %s, not after %s)", reloader.certFilePath, certificate.Leaf.NotBefore.Format(time.RFC3339), certificate.Leaf.NotAfter.Format(time.RFC3339))
+
+		reloader.mutex.RLock()
+		loaded := reloader.certificate != nil
+		reloader.mutex.RUnlock()
+		if loaded {
+			return err
+		}
+
+		secLog.WithError(err).Error("resource/tls_reloader:reload() Starting with an invalid TLS certificate, clients will reject connections until it is renewed or replaced")
+	}
+
+	reloader.mutex.Lock()
+	reloader.certificate = &certificate
+	reloader.modTime = modTime
+	reloader.mutex.Unlock()
+
+	secLog.Infof("resource/tls_reloader:reload() Loaded TLS certificate %s (serial %s, expires %s)", reloader.certFilePath, certificate.Leaf.SerialNumber.String(), certificate.Leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// filesModTime returns the most recent modification time of the certificate and key files.
+func (reloader *certificateReloader) filesModTime() (time.Time, error) {
+	var modTime time.Time
+
+	for _, filePath := range []string{reloader.certFilePath, reloader.keyFilePath} {
+		fileInfo, err := os.Stat(filePath)
+		if err != nil {
+			return time.Time{}, errors.Wrapf(err, "Error reading %s", filePath)
+		}
+
+		if fileInfo.ModTime().After(modTime) {
+			modTime = fileInfo.ModTime()
+		}
+	}
+
+	return modTime, nil
+}
+
+// isModified returns true when the certificate or key file changed since they were loaded.
+func (reloader *certificateReloader) isModified() bool {
+	modTime, err := reloader.filesModTime()
+	if err != nil {
+		log.WithError(err).Warn("resource/tls_reloader:isModified() Error checking the TLS certificate")
+		return false
+	}
+
+	reloader.mutex.RLock()
+	defer reloader.mutex.RUnlock()
+	return !modTime.Equal(reloader.modTime)
+}
+
+// watch reloads the certificate when the files change (checked every 'interval'), until stop
+// is called.
+func (reloader *certificateReloader) watch(interval time.Duration) {
+	reloader.done = make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-reloader.done:
+				return
+			case <-ticker.C:
+				if !reloader.isModified() {
+					continue
+				}
+			}
+
+			// a failed reload (ex. the key was written before the certificate) is retried at
+			// the next interval since the loaded modification time is not updated
+			err := reloader.reload()
+			if err != nil {
+				secLog.WithError(err).Error("resource/tls_reloader:watch() The TLS certificate was not reloaded, continuing to use the current certificate")
+			}
+		}
+	}()
+}
+
+func (reloader *certificateReloader) stop() {
+	if reloader.done != nil {
+		close(reloader.done)
+	}
+}
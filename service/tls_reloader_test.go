@@ -0,0 +1,195 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestCertificateFiles(t *testing.T) (string, string, func()) {
+	tmpDir, err := ioutil.TempDir("", "tls-reloader")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(tmpDir, "tls-cert.pem"), filepath.Join(tmpDir, "tls-key.pem"), func() { os.RemoveAll(tmpDir) }
+}
+
+// setModTime moves the modification time of the files forward so that the reloader sees a change,
+// even when the files were rewritten within the file system's timestamp resolution.
+func setModTime(t *testing.T, modTime time.Time, filePaths ...string) {
+	for _, filePath := range filePaths {
+		err := os.Chtimes(filePath, modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCertificateReloaderReload(t *testing.T) {
+	certFilePath, keyFilePath, cleanup := newTestCertificateFiles(t)
+	defer cleanup()
+
+	writeTestTLSCertificate(t, certFilePath, keyFilePath, 24*time.Hour)
+
+	reloader, err := newCertificateReloader(certFilePath, keyFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, _ := reloader.GetCertificate(nil)
+	if current == nil || current.Leaf == nil {
+		t.Fatal("The certificate was not loaded")
+	}
+
+	if reloader.isModified() {
+		t.Fatal("The files should not be modified after loading them")
+	}
+
+	writeTestTLSCertificate(t, certFilePath, keyFilePath, 48*time.Hour)
+	setModTime(t, time.Now().Add(time.Minute), certFilePath, keyFilePath)
+
+	if !reloader.isModified() {
+		t.Fatal("The files should be modified after rewriting them")
+	}
+
+	err = reloader.reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, _ := reloader.GetCertificate(nil)
+	if !reloaded.Leaf.NotAfter.After(current.Leaf.NotAfter) {
+		t.Fatal("The new certificate was not loaded")
+	}
+
+	if reloader.isModified() {
+		t.Fatal("The files should not be modified after reloading them")
+	}
+}
+
+func TestCertificateReloaderStartsWithExpiredCertificate(t *testing.T) {
+	certFilePath, keyFilePath, cleanup := newTestCertificateFiles(t)
+	defer cleanup()
+
+	writeTestTLSCertificate(t, certFilePath, keyFilePath, -time.Minute)
+
+	reloader, err := newCertificateReloader(certFilePath, keyFilePath)
+	if err != nil {
+		t.Fatalf("The service should start with an expired certificate: %v", err)
+	}
+
+	expired, _ := reloader.GetCertificate(nil)
+	if expired == nil || time.Now().Before(expired.Leaf.NotAfter) {
+		t.Fatal("The expired certificate was not loaded")
+	}
+
+	// a valid certificate replaces the expired certificate
+	writeTestTLSCertificate(t, certFilePath, keyFilePath, 24*time.Hour)
+	err = reloader.reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renewed, _ := reloader.GetCertificate(nil)
+	if !time.Now().Before(renewed.Leaf.NotAfter) {
+		t.Fatal("The renewed certificate was not loaded")
+	}
+}
+
+func TestCertificateReloaderKeepsCurrentCertificate(t *testing.T) {
+	certFilePath, keyFilePath, cleanup := newTestCertificateFiles(t)
+	defer cleanup()
+
+	writeTestTLSCertificate(t, certFilePath, keyFilePath, 24*time.Hour)
+
+	reloader, err := newCertificateReloader(certFilePath, keyFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, _ := reloader.GetCertificate(nil)
+
+	// an expired certificate does not replace a loaded certificate
+	writeTestTLSCertificate(t, certFilePath, keyFilePath, -time.Minute)
+	setModTime(t, time.Now().Add(time.Minute), certFilePath, keyFilePath)
+
+	err = reloader.reload()
+	if err == nil {
+		t.Fatal("Reloading an expired certificate should fail")
+	}
+
+	if certificate, _ := reloader.GetCertificate(nil); certificate != current {
+		t.Fatal("The current certificate was replaced by an expired certificate")
+	}
+
+	// a failed reload is retried since the modification time was not updated
+	if !reloader.isModified() {
+		t.Fatal("The files should still be modified after a failed reload")
+	}
+
+	// a key that does not match the certificate is not loaded
+	otherCertFilePath, otherKeyFilePath, otherCleanup := newTestCertificateFiles(t)
+	defer otherCleanup()
+
+	writeTestTLSCertificate(t, otherCertFilePath, otherKeyFilePath, 24*time.Hour)
+	keyPem, err := ioutil.ReadFile(otherKeyFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestTLSCertificate(t, certFilePath, keyFilePath, 24*time.Hour)
+	err = ioutil.WriteFile(keyFilePath, keyPem, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = reloader.reload()
+	if err == nil {
+		t.Fatal("Reloading a certificate with a mismatched key should fail")
+	}
+
+	if certificate, _ := reloader.GetCertificate(nil); certificate != current {
+		t.Fatal("The current certificate was replaced by a certificate with a mismatched key")
+	}
+}
+
+func TestTrustAgentServiceReload(t *testing.T) {
+	certFilePath, keyFilePath, cleanup := newTestCertificateFiles(t)
+	defer cleanup()
+
+	// the web service has not been started (no certificate reloader)
+	webService := &trustAgentWebService{}
+	if err := webService.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestTLSCertificate(t, certFilePath, keyFilePath, 24*time.Hour)
+	reloader, err := newCertificateReloader(certFilePath, keyFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, _ := reloader.GetCertificate(nil)
+	writeTestTLSCertificate(t, certFilePath, keyFilePath, 48*time.Hour)
+
+	webService.certificateReloader = reloader
+	if err := webService.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if reloaded, _ := reloader.GetCertificate(nil); reloaded == current {
+		t.Fatal("The certificate was not reloaded")
+	}
+
+	outboundService := &trustAgentOutboundService{}
+	if err := outboundService.Reload(); err != nil {
+		t.Fatal(err)
+	}
+}
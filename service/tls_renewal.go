@@ -0,0 +1,280 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"intel/isecl/go-trust-agent/v4/constants"
+	"intel/isecl/go-trust-agent/v4/util"
+	"intel/isecl/lib/common/v4/setup"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intel-secl/intel-secl/v4/pkg/lib/common/crypt"
+	"github.com/pkg/errors"
+)
+
+const (
+	tlsCertificateValid    = "valid"
+	tlsCertificateExpiring = "expiring"
+	tlsCertificateExpired  = "expired"
+)
+
+// TLSCertificateStatus is returned by GET /v2/tls-certificate.
+type TLSCertificateStatus struct {
+	Status         string     `json:"status"`
+	SerialNumber   string     `json:"serial_number,omitempty"`
+	NotAfter       *time.Time `json:"not_after,omitempty"`
+	RenewalEnabled bool       `json:"renewal_enabled"`
+	RenewalTime    *time.Time `json:"renewal_time,omitempty"`
+	LastAttempt    *time.Time `json:"last_attempt,omitempty"`
+	LastRenewal    *time.Time `json:"last_renewal,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	TokenExpiry    *time.Time `json:"token_expiry,omitempty"`
+}
+
+// certificateRenewer requests a new TLS certificate from CMS (using the token downloaded by
+// 'tagent setup download-tls-renewal-token') when the current certificate expires within
+// 'renewalThreshold'.  The new key/certificate replace tls-key.pem/tls-cert.pem and are loaded
+// by the certificateReloader.
+//
+// The token is not refreshed by the service (AAS requires an administrator's bearer token to
+// create it): a warning is logged when it expires within 'renewalThreshold' and its expiry is
+// reported by the tls_renewal_token_expiry_timestamp_seconds metric, so that the token can be
+// downloaded again (and the service restarted) before renewals fail.
+type certificateRenewer struct {
+	certFilePath      string
+	keyFilePath       string
+	cmsBaseURL        string
+	commonName        string
+	sanList           string
+	trustedCaCertsDir string
+	token             string
+	tokenExpiry       time.Time // zero when the token is not a jwt with an 'exp' claim
+	renewalThreshold  time.Duration
+
+	// called after the certificate files were replaced
+	onRenewal func() error
+
+	mutex  sync.Mutex
+	status TLSCertificateStatus
+	done   chan struct{}
+}
+
+func newCertificateRenewer(webParameters *WebParameters) *certificateRenewer {
+	renewer := certificateRenewer{
+		certFilePath:      webParameters.TLSCertFilePath,
+		keyFilePath:       webParameters.TLSKeyFilePath,
+		cmsBaseURL:        webParameters.CmsBaseURL,
+		commonName:        webParameters.TLSCertCN,
+		sanList:           webParameters.TLSCertSAN,
+		trustedCaCertsDir: webParameters.TrustedCaCertsDir,
+		token:             webParameters.TLSRenewalToken,
+		renewalThreshold:  time.Duration(webParameters.TLSCertRenewalDays) * 24 * time.Hour,
+	}
+
+	renewer.status.RenewalEnabled = renewer.renewalThreshold > 0 && renewer.cmsBaseURL != "" && renewer.token != ""
+
+	if renewer.token != "" {
+		tokenExpiry, err := getTokenExpiry(renewer.token)
+		if err != nil {
+			log.WithError(err).Warn("resource/tls_renewal:newCertificateRenewer() Could not read the expiry of the TLS renewal token")
+		} else {
+			renewer.tokenExpiry = tokenExpiry
+			renewer.status.TokenExpiry = &renewer.tokenExpiry
+		}
+	}
+
+	return &renewer
+}
+
+// getTokenExpiry returns the 'exp' claim of a jwt.  The token is not verified (it is only
+// verified by CMS when the certificate is renewed).
+func getTokenExpiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, errors.New("The token is not a jwt")
+	}
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "Error decoding the token's claims")
+	}
+
+	var claims struct {
+		ExpiresAt int64 `json:"exp"`
+	}
+	err = json.Unmarshal(claimsJSON, &claims)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "Error parsing the token's claims")
+	}
+
+	if claims.ExpiresAt == 0 {
+		return time.Time{}, errors.New("The token does not have an 'exp' claim")
+	}
+
+	return time.Unix(claims.ExpiresAt, 0), nil
+}
+
+// checkTokenExpiry logs a warning when the renewal token expires within the renewal threshold
+// (and an error once it has expired).
+func (renewer *certificateRenewer) checkTokenExpiry() {
+	if renewer.tokenExpiry.IsZero() {
+		return
+	}
+
+	remaining := time.Until(renewer.tokenExpiry)
+	if remaining <= 0 {
+		secLog.Errorf("resource/tls_renewal:checkTokenExpiry() The TLS renewal token expired on %s, run 'tagent setup download-tls-renewal-token' and restart the service", renewer.tokenExpiry.Format(time.RFC3339))
+	} else if remaining < renewer.renewalThreshold {
+		log.Warnf("resource/tls_renewal:checkTokenExpiry() The TLS renewal token expires on %s, run 'tagent setup download-tls-renewal-token' and restart the service", renewer.tokenExpiry.Format(time.RFC3339))
+	}
+}
+
+// Status returns the TLS certificate's expiry and the result of the last renewal.
+func (renewer *certificateRenewer) Status() TLSCertificateStatus {
+	certificate, err := crypt.GetCertFromPemFile(renewer.certFilePath)
+
+	renewer.mutex.Lock()
+	defer renewer.mutex.Unlock()
+
+	status := renewer.status
+	if err != nil {
+		status.Status = tlsCertificateExpired
+		status.LastError = err.Error()
+		return status
+	}
+
+	renewalTime := certificate.NotAfter.Add(-renewer.renewalThreshold)
+	status.SerialNumber = certificate.SerialNumber.String()
+	status.NotAfter = &certificate.NotAfter
+	if status.RenewalEnabled {
+		status.RenewalTime = &renewalTime
+	}
+
+	now := time.Now()
+	if now.After(certificate.NotAfter) {
+		status.Status = tlsCertificateExpired
+	} else if now.After(renewalTime) {
+		status.Status = tlsCertificateExpiring
+	} else {
+		status.Status = tlsCertificateValid
+	}
+
+	return status
+}
+
+// checkAndRenew renews the TLS certificate when it is missing, invalid or expires
+// within the renewal threshold.
+func (renewer *certificateRenewer) checkAndRenew() error {
+	log.Trace("resource/tls_renewal:checkAndRenew() Entering")
+	defer log.Trace("resource/tls_renewal:checkAndRenew() Leaving")
+
+	renewer.checkTokenExpiry()
+
+	certificate, err := crypt.GetCertFromPemFile(renewer.certFilePath)
+	if err != nil {
+		log.WithError(err).Warn("resource/tls_renewal:checkAndRenew() The TLS certificate could not be read and will be renewed")
+	} else if time.Now().Before(certificate.NotAfter.Add(-renewer.renewalThreshold)) {
+		log.Debugf("resource/tls_renewal:checkAndRenew() The TLS certificate expires %s, renewal is not required", certificate.NotAfter.Format(time.RFC3339))
+		return nil
+	}
+
+	err = renewer.renew()
+
+	now := time.Now()
+	renewer.mutex.Lock()
+	renewer.status.LastAttempt = &now
+	if err != nil {
+		renewer.status.LastError = err.Error()
+	} else {
+		renewer.status.LastRenewal = &now
+		renewer.status.LastError = ""
+	}
+	renewer.mutex.Unlock()
+
+	if err != nil {
+		secLog.WithError(err).Error("resource/tls_renewal:checkAndRenew() Failed to renew the TLS certificate")
+	}
+
+	return err
+}
+
+// renew requests a new key/certificate from CMS and replaces the TLS key and certificate files.
+func (renewer *certificateRenewer) renew() error {
+	log.Trace("resource/tls_renewal:renew() Entering")
+	defer log.Trace("resource/tls_renewal:renew() Leaving")
+
+	keyDer, certPem, err := setup.GetCertificateFromCMS("TLS", constants.DefaultKeyAlgorithm, constants.DefaultKeyAlgorithmLength,
+		renewer.cmsBaseURL, pkix.Name{CommonName: renewer.commonName}, renewer.sanList, renewer.trustedCaCertsDir, renewer.token)
+	if err != nil {
+		return errors.Wrap(err, "Error requesting the TLS certificate from CMS")
+	} else if len(keyDer) == 0 || len(certPem) == 0 {
+		// GetCertificateFromCMS does not return an error when a file in the ca directory cannot be parsed
+		return errors.Errorf("The TLS certificate was not received from CMS (see the certificates in %s)", renewer.trustedCaCertsDir)
+	}
+
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "PKCS8 PRIVATE KEY", Bytes: keyDer})
+
+	// make sure the files will be loaded before replacing the current certificate
+	_, err = tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		return errors.Wrap(err, "The TLS certificate from CMS does not match the private key")
+	}
+
+	err = util.WriteFileAtomic(renewer.keyFilePath, keyPem, 0640)
+	if err != nil {
+		return err
+	}
+
+	err = util.WriteFileAtomic(renewer.certFilePath, certPem, 0644)
+	if err != nil {
+		return err
+	}
+
+	certificate, err := crypt.GetCertFromPemFile(renewer.certFilePath)
+	if err != nil {
+		return errors.Wrap(err, "Error reading the renewed TLS certificate")
+	}
+
+	secLog.Infof("resource/tls_renewal:renew() Renewed the TLS certificate %s (serial %s, expires %s)", renewer.certFilePath, certificate.SerialNumber.String(), certificate.NotAfter.Format(time.RFC3339))
+
+	if renewer.onRenewal != nil {
+		return renewer.onRenewal()
+	}
+
+	return nil
+}
+
+// start checks the certificate every 'interval' until stop is called.
+func (renewer *certificateRenewer) start(interval time.Duration) {
+	renewer.done = make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-renewer.done:
+				return
+			case <-ticker.C:
+				// errors are logged and recorded in the status, the renewal is retried at the next interval
+				_ = renewer.checkAndRenew()
+			}
+		}
+	}()
+}
+
+func (renewer *certificateRenewer) stop() {
+	if renewer.done != nil {
+		close(renewer.done)
+	}
+}
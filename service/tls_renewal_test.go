@@ -0,0 +1,284 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const testRenewalToken = "renewal-token"
+
+// newTestCMS returns a CMS stand-in that signs TLS CSRs with a test CA.
+func newTestCMS(t *testing.T, caDir string) *httptest.Server {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "CMS Signing CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caCert, err := x509.ParseCertificate(caDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serialNumber := int64(100)
+	cms := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/certificates" || r.URL.Query().Get("certType") != "TLS" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer "+testRenewalToken {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		block, _ := pem.Decode(body)
+		if block == nil {
+			http.Error(w, "invalid csr", http.StatusBadRequest)
+			return
+		}
+
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil || csr.CheckSignature() != nil {
+			http.Error(w, "invalid csr", http.StatusBadRequest)
+			return
+		}
+
+		serialNumber++
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serialNumber),
+			Subject:      csr.Subject,
+			DNSNames:     csr.DNSNames,
+			IPAddresses:  csr.IPAddresses,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, csr.PublicKey, caKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		_ = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+		_ = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: caDer})
+	}))
+
+	err = ioutil.WriteFile(filepath.Join(caDir, "cms.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cms.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cms
+}
+
+// writeTestTLSCertificate writes a self-signed TLS key/certificate that expires after 'validity'.
+func writeTestTLSCertificate(t *testing.T, certFilePath string, keyFilePath string, validity time.Duration) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Trust Agent TLS Certificate"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validity),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(certFilePath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(keyFilePath, pem.EncodeToMemory(&pem.Block{Type: "PKCS8 PRIVATE KEY", Bytes: keyDer}), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertificateRenewer(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tls-renewal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	caDir := filepath.Join(tmpDir, "cacerts")
+	err = os.Mkdir(caDir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cms := newTestCMS(t, caDir)
+	defer cms.Close()
+
+	webParameters := WebParameters{
+		TLSCertFilePath:    filepath.Join(tmpDir, "tls-cert.pem"),
+		TLSKeyFilePath:     filepath.Join(tmpDir, "tls-key.pem"),
+		TrustedCaCertsDir:  caDir,
+		CmsBaseURL:         cms.URL,
+		TLSCertCN:          "Trust Agent TLS Certificate",
+		TLSCertSAN:         "127.0.0.1,localhost",
+		TLSRenewalToken:    testRenewalToken,
+		TLSCertRenewalDays: 30,
+	}
+
+	// the certificate does not expire within 30 days and is not renewed
+	writeTestTLSCertificate(t, webParameters.TLSCertFilePath, webParameters.TLSKeyFilePath, 60*24*time.Hour)
+
+	renewer := newCertificateRenewer(&webParameters)
+	if !renewer.status.RenewalEnabled {
+		t.Fatal("Expected TLS certificate renewal to be enabled")
+	}
+
+	err = renewer.checkAndRenew()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := renewer.Status()
+	if status.Status != tlsCertificateValid || status.SerialNumber != "1" || status.LastAttempt != nil {
+		t.Fatalf("Unexpected status before renewal: %+v", status)
+	}
+
+	// the certificate expires within 30 days and is renewed
+	writeTestTLSCertificate(t, webParameters.TLSCertFilePath, webParameters.TLSKeyFilePath, 24*time.Hour)
+
+	reloader, err := newCertificateReloader(webParameters.TLSCertFilePath, webParameters.TLSKeyFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewer.onRenewal = reloader.reload
+
+	if renewer.Status().Status != tlsCertificateExpiring {
+		t.Fatal("Expected the TLS certificate to be expiring")
+	}
+
+	err = renewer.checkAndRenew()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status = renewer.Status()
+	if status.Status != tlsCertificateValid || status.SerialNumber != "101" || status.LastRenewal == nil || status.LastError != "" {
+		t.Fatalf("Unexpected status after renewal: %+v", status)
+	}
+
+	certificate, _ := reloader.GetCertificate(nil)
+	if certificate.Leaf.SerialNumber.Int64() != 101 || certificate.Leaf.Subject.CommonName != webParameters.TLSCertCN {
+		t.Fatal("The renewed TLS certificate was not reloaded")
+	}
+
+	// the renewal fails (and the current certificate is kept) when CMS rejects the token
+	writeTestTLSCertificate(t, webParameters.TLSCertFilePath, webParameters.TLSKeyFilePath, 24*time.Hour)
+	webParameters.TLSRenewalToken = "expired-token"
+	renewer = newCertificateRenewer(&webParameters)
+
+	err = renewer.checkAndRenew()
+	if err == nil {
+		t.Fatal("Expected the renewal to fail with an invalid token")
+	}
+
+	status = renewer.Status()
+	if status.Status != tlsCertificateExpiring || status.SerialNumber != "1" || status.LastAttempt == nil || status.LastError == "" {
+		t.Fatalf("Unexpected status after a failed renewal: %+v", status)
+	}
+}
+
+// newTestJWT returns an (unsigned) jwt with the 'exp' claim.
+func newTestJWT(expiresAt time.Time) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS384","typ":"JWT"}`))
+	claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"sub":"host","exp":%d}`, expiresAt.Unix())))
+	return header + "." + claims + ".signature"
+}
+
+func TestTLSRenewalTokenExpiry(t *testing.T) {
+	expiresAt := time.Now().Add(10 * 24 * time.Hour).Truncate(time.Second)
+
+	tokenExpiry, err := getTokenExpiry(newTestJWT(expiresAt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tokenExpiry.Equal(expiresAt) {
+		t.Fatalf("Expected the token to expire at %s, got %s", expiresAt, tokenExpiry)
+	}
+
+	for _, token := range []string{testRenewalToken, "a.b.c", "a." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"host"}`)) + ".c"} {
+		_, err = getTokenExpiry(token)
+		if err == nil {
+			t.Fatalf("Expected an error for token %q", token)
+		}
+	}
+
+	webParameters := WebParameters{
+		CmsBaseURL:         "https://cms.server.com:8445/cms/v1",
+		TLSRenewalToken:    newTestJWT(expiresAt),
+		TLSCertRenewalDays: 30,
+	}
+
+	renewer := newCertificateRenewer(&webParameters)
+	status := renewer.Status()
+	if status.TokenExpiry == nil || !status.TokenExpiry.Equal(expiresAt) {
+		t.Fatalf("Unexpected token expiry in the status: %+v", status)
+	}
+
+	if expiry := testutil.ToFloat64(newTLSRenewalTokenExpiryGauge(renewer)); expiry != float64(expiresAt.Unix()) {
+		t.Fatalf("Unexpected token expiry metric %f", expiry)
+	}
+
+	// the expiry is unknown when the token is not a jwt
+	webParameters.TLSRenewalToken = testRenewalToken
+	renewer = newCertificateRenewer(&webParameters)
+	if renewer.Status().TokenExpiry != nil || testutil.ToFloat64(newTLSRenewalTokenExpiryGauge(renewer)) != 0 {
+		t.Fatal("Expected the token expiry to be unknown")
+	}
+}
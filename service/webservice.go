@@ -7,6 +7,8 @@ package service
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"runtime/debug"
 
@@ -17,7 +19,6 @@ import (
 	stdlog "log"
 	"net/http"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/gorilla/handlers"
@@ -37,18 +38,26 @@ const (
 	getAIKPerm             = "aik:retrieve"
 	getAIKCAPerm           = "aik_ca:retrieve"
 	getBindingKeyPerm      = "binding_key:retrieve"
-	getDAAPerm             = "daa:retrieve"
+	getEkCertificatePerm   = "ek_certificate:retrieve"
 	getHostInfoPerm        = "host_info:retrieve"
+	getPcrsPerm            = "pcrs:retrieve"
+	getTagPerm             = "tag:retrieve"
 	postDeployManifestPerm = "deploy_manifest:create"
 	postAppMeasurementPerm = "application_measurement:create"
 	postDeployTagPerm      = "deploy_tag:create"
+	deleteDeployTagPerm    = "deploy_tag:delete"
 	postQuotePerm          = "quote:create"
+	getTLSCertificatePerm  = "tls_certificate:retrieve"
+	getMetricsPerm         = "metrics:retrieve"
 )
 
 type trustAgentWebService struct {
-	webParameters WebParameters
-	router        *mux.Router
-	server        *http.Server
+	webParameters       WebParameters
+	router              *mux.Router
+	server              *http.Server
+	certificateReloader *certificateReloader
+	certificateRenewer  *certificateRenewer
+	metricsServer       *http.Server
 }
 
 type privilegeError struct {
@@ -65,7 +74,7 @@ func (e privilegeError) Error() string {
 var cacheTime, _ = time.ParseDuration(constants.JWTCertsCacheTime)
 var seclog = commLog.GetSecurityLogger()
 
-func newWebService(webParameters *WebParameters, requestHandler common.RequestHandler) (TrustAgentService, error) {
+func newWebService(webParameters *WebParameters, metricsParameters *config.Metrics, rateLimits *config.RateLimits, requestHandler common.RequestHandler) (TrustAgentService, error) {
 	log.Trace("resource/service:NewTrustAgentHttpService() Entering")
 	defer log.Trace("resource/service:NewTrustAgentHttpService() Leaving")
 
@@ -74,28 +83,92 @@ func newWebService(webParameters *WebParameters, requestHandler common.RequestHa
 	}
 
 	trustAgentService := trustAgentWebService{
-		webParameters: *webParameters,
+		webParameters:      *webParameters,
+		certificateRenewer: newCertificateRenewer(webParameters),
 	}
 
 	// Register routes...
 	trustAgentService.router = mux.NewRouter()
 	// ISECL-8715 - Prevent potential open redirects to external URLs
 	trustAgentService.router.SkipClean(true)
+	trustAgentService.router.NotFoundHandler = notFoundHandler()
+	trustAgentService.router.MethodNotAllowedHandler = methodNotAllowedHandler()
+	trustAgentService.router.Use(newHttpMetricsMiddleware())
+
+	metricsRegistry := newMetricsRegistry(requestHandler, httpRequestsTotal, httpRequestDuration,
+		newCertificateExpiryGauge("tls", func() (time.Time, error) {
+			certificate, err := crypt.GetCertFromPemFile(trustAgentService.webParameters.TLSCertFilePath)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return certificate.NotAfter, nil
+		}),
+		newTLSRenewalTokenExpiryGauge(trustAgentService.certificateRenewer))
+	trustAgentService.metricsServer = newMetricsServer(metricsParameters.Port, metricsRegistry)
+
+	openAPIJSON, err := json.Marshal(newOpenAPIDocument(webParameters.AuthMode))
+	if err != nil {
+		return nil, errors.Wrap(err, "Error creating the OpenAPI document")
+	}
+
+	// the handlers of openAPIOperations (by operation id), which provides the method, path
+	// and permission of each route
+	handlers := map[string]endpointHandler{
+		"getVersion":                    getVersion(),
+		"getLiveness":                   getLiveness(),
+		"getReadiness":                  getReadiness(requestHandler, trustAgentService.certificateRenewer),
+		"getOpenAPIDocument":            getOpenAPIDocument(openAPIJSON),
+		"getAik":                        getAik(requestHandler),
+		"getAikCa":                      getAikCa(requestHandler),
+		"getEndorsementKeyCertificates": getEndorsementKeyCertificates(requestHandler),
+		"getHostInfo":                   getPlatformInfo(requestHandler),
+		"getTpmQuote":                   getTpmQuote(requestHandler),
+		"getTpmPcrs":                    getTpmPcrs(requestHandler),
+		"getBindingKeyCertificate":      getBindingKeyCertificate(requestHandler),
+		"setAssetTag":                   setAssetTag(requestHandler),
+		"getAssetTag":                   getAssetTag(requestHandler),
+		"clearAssetTag":                 clearAssetTag(requestHandler),
+		"getAssetTagHistory":            getAssetTagHistory(requestHandler),
+		"getApplicationMeasurement":     getApplicationMeasurement(requestHandler),
+		"deployManifest":                deployManifest(requestHandler),
+		"getTLSCertificateStatus":       getTLSCertificateStatus(trustAgentService.certificateRenewer),
+		"getMetrics":                    getMetrics(metricsRegistry),
+	}
 
 	noAuthRouter := trustAgentService.router.PathPrefix("/v2/").Subrouter()
-	noAuthRouter.HandleFunc("/version", errorHandler(getVersion())).Methods("GET")
 
 	// use permission-based access control for webservices
 	authRouter := trustAgentService.router.PathPrefix("/v2/").Subrouter()
-	authRouter.Use(middleware.NewTokenAuth(webParameters.TrustedJWTSigningCertsDir, webParameters.TrustedCaCertsDir, fnGetJwtCerts, cacheTime))
+	if webParameters.AuthMode == constants.AuthModeMTLS {
+		policy, err := loadClientCertificatePolicy(webParameters.ClientCertificatePolicyFile)
+		if err != nil {
+			return nil, err
+		}
+		authRouter.Use(newClientCertificateAuth(policy))
+	} else {
+		authRouter.Use(withJSONErrors(middleware.NewTokenAuth(webParameters.TrustedJWTSigningCertsDir, webParameters.TrustedCaCertsDir, fnGetJwtCerts, cacheTime)))
+	}
+
+	// limit the requests of each (authenticated) caller
+	authRouter.Use(newRateLimitMiddleware(newRateLimiter(*rateLimits)))
+
+	for _, operation := range openAPIOperations {
+		handler, ok := handlers[operation.operationID]
+		if !ok {
+			return nil, errors.Errorf("The web service does not have a handler for operation '%s'", operation.operationID)
+		}
+		delete(handlers, operation.operationID)
+
+		if operation.permission == "" {
+			noAuthRouter.HandleFunc(operation.path, errorHandler(handler)).Methods(operation.method)
+		} else {
+			authRouter.HandleFunc(operation.path, errorHandler(requiresPermission(handler, []string{operation.permission}))).Methods(operation.method)
+		}
+	}
 
-	authRouter.HandleFunc("/aik", errorHandler(requiresPermission(getAik(requestHandler), []string{getAIKPerm}))).Methods("GET")
-	authRouter.HandleFunc("/host", errorHandler(requiresPermission(getPlatformInfo(requestHandler), []string{getHostInfoPerm}))).Methods("GET")
-	authRouter.HandleFunc("/tpm/quote", errorHandler(requiresPermission(getTpmQuote(requestHandler), []string{postQuotePerm}))).Methods("POST")
-	authRouter.HandleFunc("/binding-key-certificate", errorHandler(requiresPermission(getBindingKeyCertificate(requestHandler), []string{getBindingKeyPerm}))).Methods("GET")
-	authRouter.HandleFunc("/tag", errorHandler(requiresPermission(setAssetTag(requestHandler), []string{postDeployTagPerm}))).Methods("POST")
-	authRouter.HandleFunc("/host/application-measurement", errorHandler(requiresPermission(getApplicationMeasurement(requestHandler), []string{postAppMeasurementPerm}))).Methods("POST")
-	authRouter.HandleFunc("/deploy/manifest", errorHandler(requiresPermission(deployManifest(requestHandler), []string{postDeployManifestPerm}))).Methods("POST")
+	for operationID := range handlers {
+		return nil, errors.Errorf("The handler of '%s' is not in openAPIOperations", operationID)
+	}
 
 	return &trustAgentService, nil
 }
@@ -112,6 +185,44 @@ func (service *trustAgentWebService) Start() error {
 			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
 	}
 
+	// request client certificates issued by the trusted CAs (they are required by the
+	// authRouter's middleware, not by '/version', '/health' and '/openapi.json')
+	if service.webParameters.AuthMode == constants.AuthModeMTLS {
+		caCerts, err := crypt.GetCertsFromDir(service.webParameters.TrustedCaCertsDir)
+		if err != nil {
+			return errors.Wrapf(err, "Error reading the trusted CA certificates from %s", service.webParameters.TrustedCaCertsDir)
+		}
+
+		tlsconfig.ClientCAs = x509.NewCertPool()
+		for i := range caCerts {
+			tlsconfig.ClientCAs.AddCert(&caCerts[i])
+		}
+		tlsconfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	// renew an expired (or expiring) certificate before it is loaded
+	if service.certificateRenewer.status.RenewalEnabled {
+		_ = service.certificateRenewer.checkAndRenew()
+	} else {
+		log.Infof("resource/service:Start() Automatic TLS certificate renewal is disabled (see %s and 'tagent setup download-tls-renewal-token')", constants.EnvTLSCertRenewalDays)
+	}
+
+	// serve the TLS certificate via GetCertificate so that it can be renewed without
+	// restarting the service
+	certificateReloader, err := newCertificateReloader(service.webParameters.TLSCertFilePath, service.webParameters.TLSKeyFilePath)
+	if err != nil {
+		secLog.WithError(err).Errorf("resource/service:Start() %s", message.TLSConnectFailed)
+		return errors.Wrap(err, "Failed to load the TLS certificate")
+	}
+	tlsconfig.GetCertificate = certificateReloader.GetCertificate
+	certificateReloader.watch(constants.TLSCertReloadInterval)
+	service.certificateReloader = certificateReloader
+
+	if service.certificateRenewer.status.RenewalEnabled {
+		service.certificateRenewer.onRenewal = certificateReloader.reload
+		service.certificateRenewer.start(constants.TLSCertRenewalCheckInterval)
+	}
+
 	httpWriter := os.Stderr
 	if httpLogFile, err := os.OpenFile(constants.HttpLogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640); err != nil {
 		secLog.WithError(err).Errorf("resource/service:Start() %s Failed to open http log file: %s\n", message.AppRuntimeErr, err.Error())
@@ -129,7 +240,7 @@ func (service *trustAgentWebService) Start() error {
 	httpLog := stdlog.New(httpWriter, "", 0)
 	service.server = &http.Server{
 		Addr:              fmt.Sprintf(":%d", service.webParameters.Port),
-		Handler:           handlers.RecoveryHandler(handlers.RecoveryLogger(httpLog), handlers.PrintRecoveryStack(true))(handlers.CombinedLoggingHandler(os.Stderr, service.router)),
+		Handler:           handlers.RecoveryHandler(handlers.RecoveryLogger(httpLog), handlers.PrintRecoveryStack(true))(handlers.CustomLoggingHandler(os.Stderr, newRequestIDHandler(newRequestTimeoutHandler(service.webParameters.RequestTimeout, service.router)), writeAccessLog)),
 		ErrorLog:          httpLog,
 		TLSConfig:         tlsconfig,
 		ReadTimeout:       service.webParameters.ReadTimeout,
@@ -139,9 +250,11 @@ func (service *trustAgentWebService) Start() error {
 		MaxHeaderBytes:    service.webParameters.MaxHeaderBytes,
 	}
 
+	startMetricsServer(service.metricsServer)
+
 	// dispatch web server go routine
 	go func() {
-		if err := service.server.ListenAndServeTLS(service.webParameters.TLSCertFilePath, service.webParameters.TLSKeyFilePath); err != nil {
+		if err := service.server.ListenAndServeTLS("", ""); err != nil {
 			secLog.Errorf("tasks/service:Start() %s", message.TLSConnectFailed)
 			secLog.WithError(err).Fatalf("server:startServer() Failed to start HTTPS server: %s\n", err.Error())
 			log.Tracef("%+v", err)
@@ -154,7 +267,24 @@ func (service *trustAgentWebService) Start() error {
 	return nil
 }
 
+// Reload reloads the TLS certificate (see certificateReloader).
+func (service *trustAgentWebService) Reload() error {
+	if service.certificateReloader == nil {
+		return nil
+	}
+
+	secLog.Info("resource/webservice:Reload() Reloading the TLS certificate")
+	return service.certificateReloader.reload()
+}
+
 func (service *trustAgentWebService) Stop() error {
+	if service.certificateReloader != nil {
+		service.certificateReloader.stop()
+	}
+
+	service.certificateRenewer.stop()
+	stopMetricsServer(service.metricsServer)
+
 	if service.server != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
@@ -173,14 +303,10 @@ func requiresPermission(eh endpointHandler, permissionNames []string) endpointHa
 	log.Trace("resource/service:requiresPermission() Entering")
 	defer log.Trace("resource/service:requiresPermission() Leaving")
 	return func(w http.ResponseWriter, r *http.Request) error {
+		secLog := common.GetSecurityLogger(r.Context())
+		w.Header().Add("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
 		privileges, err := commContext.GetUserPermissions(r)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Header().Add("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
-			_, writeErr := w.Write([]byte("Could not get user roles from http context"))
-			if writeErr != nil {
-				log.WithError(writeErr).Warn("resource/service:requiresPermission() Error while writing response")
-			}
 			secLog.Errorf("resource/service:requiresPermission() %s Roles: %v | Context: %v", message.AuthenticationFailed, permissionNames, r.Context())
 			return errors.Wrap(err, "resource/service:requiresPermission() Could not get user roles from http context")
 		}
@@ -189,16 +315,30 @@ func requiresPermission(eh endpointHandler, permissionNames []string) endpointHa
 		_, foundMatchingPermission := auth.ValidatePermissionAndGetPermissionsContext(privileges, reqPermissions,
 			true)
 		if !foundMatchingPermission {
-			w.WriteHeader(http.StatusUnauthorized)
 			secLog.Errorf("resource/service:requiresPermission() %s Insufficient privileges to access %s", message.UnauthorizedAccess, r.RequestURI)
 			return &privilegeError{Message: "Insufficient privileges to access " + r.RequestURI, StatusCode: http.StatusUnauthorized}
 		}
-		w.Header().Add("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
 		secLog.Debugf("resource/service:requiresPermission() %s - %s", message.AuthorizedAccess, r.RequestURI)
 		return eh(w, r)
 	}
 }
 
+// getCaller identifies the requester (the subject of the bearer token or client certificate)
+// in audit records.
+func getCaller(httpRequest *http.Request) string {
+	if httpRequest.TLS != nil && len(httpRequest.TLS.VerifiedChains) > 0 && len(httpRequest.TLS.VerifiedChains[0]) > 0 {
+		return "cert:" + httpRequest.TLS.VerifiedChains[0][0].Subject.String()
+	}
+
+	subject, err := commContext.GetTokenSubject(httpRequest)
+	if err != nil || subject == "" {
+		log.WithError(err).Warn("resource/service:getCaller() Could not get the token subject from http context")
+		return "jwt:unknown"
+	}
+
+	return "jwt:" + subject
+}
+
 // endpointHandler is the same as http.ResponseHandler, but returns an error that can be handled by a generic
 // middleware handler
 type endpointHandler func(w http.ResponseWriter, r *http.Request) error
@@ -207,30 +347,55 @@ func errorHandler(eh endpointHandler) http.HandlerFunc {
 	log.Trace("resource/service:errorHandler() Entering")
 	defer log.Trace("resource/service:errorHandler() Leaving")
 	return func(w http.ResponseWriter, r *http.Request) {
+		log := common.GetLogger(r.Context())
 		defer func() {
 			if err := recover(); err != nil {
 				log.Errorf("Panic occurred: %+v\n%s", err, string(debug.Stack()))
-				http.Error(w, "Unknown Error", http.StatusInternalServerError)
+				writeErrorResponse(w, r, http.StatusInternalServerError, &common.ErrorResponse{Code: common.ErrorCodeInternal, Message: "Unknown Error"})
 			}
 		}()
 
 		if err := eh(w, r); err != nil {
-			if strings.TrimSpace(strings.ToLower(err.Error())) == "record not found" {
-				http.Error(w, err.Error(), http.StatusNotFound)
-				return
+			var statusCode int
+			var errorResponse *common.ErrorResponse
+			if t, ok := errors.Cause(err).(*privilegeError); ok {
+				statusCode, errorResponse = t.StatusCode, &common.ErrorResponse{Code: common.ErrorCodeUnauthorized, Message: t.Message}
+			} else {
+				statusCode, errorResponse = common.NewErrorResponse(err, getRequestID(r))
 			}
-			switch t := err.(type) {
-			case *common.EndpointError:
-				http.Error(w, t.Message, t.StatusCode)
-			case privilegeError:
-				http.Error(w, t.Message, t.StatusCode)
-			default:
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+
+			if statusCode == http.StatusInternalServerError {
+				log.WithError(err).Errorf("resource/service:errorHandler() %s - Error processing request %s", message.AppRuntimeErr, r.RequestURI)
 			}
+
+			writeErrorResponse(w, r, statusCode, errorResponse)
 		}
 	}
 }
 
+// writeErrorResponse writes the json ErrorResponse body (with the request's id) returned
+// by all endpoints when a request fails.
+func writeErrorResponse(w http.ResponseWriter, r *http.Request, statusCode int, errorResponse *common.ErrorResponse) {
+	if errorResponse.RequestID == "" {
+		errorResponse.RequestID = getRequestID(r)
+	}
+	body, err := json.Marshal(errorResponse)
+	if err != nil {
+		log.WithError(err).Error("resource/service:writeErrorResponse() Error marshaling error response")
+		http.Error(w, errorResponse.Message, statusCode)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set(requestIDHeader, errorResponse.RequestID)
+	w.WriteHeader(statusCode)
+	_, err = w.Write(body)
+	if err != nil {
+		log.WithError(err).Warn("resource/service:writeErrorResponse() Error while writing response")
+	}
+}
+
 func fnGetJwtCerts() error {
 	log.Trace("resource/service:fnGetJwtCerts() Entering")
 	defer log.Trace("resource/service:fnGetJwtCerts() Leaving")
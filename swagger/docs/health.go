@@ -0,0 +1,71 @@
+/*
+ *  Copyright (C) 2021 Intel Corporation
+ *  SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package docs
+
+import "intel/isecl/go-trust-agent/v4/common"
+
+// HealthStatusInfo response payload
+// swagger:response HealthStatusInfo
+type HealthStatusInfo struct {
+	// in:body
+	Body common.HealthStatus
+}
+
+//
+// swagger:operation GET /health/live Health getLiveness
+// ---
+// description: |
+//   Returns 200 while the Trust-Agent service is able to handle requests (used by liveness probes).
+//   This endpoint does not require authentication.
+//
+// produces:
+//   - application/json
+// responses:
+//   '200':
+//     description: The service is running.
+//     schema:
+//       "$ref": "#/responses/HealthStatusInfo"
+//
+// x-sample-call-endpoint: https://trustagent.server.com:1443/v2/health/live
+// x-sample-call-output: |
+//   {"status":"ok","check_time":"2021-09-12T10:45:03Z"}
+
+//
+// swagger:operation GET /health/ready Health getReadiness
+// ---
+// description: |
+//   Returns 200 when the Trust-Agent has been provisioned and can attest the host: the TPM can be opened, aik.pem
+//   exists and can be parsed, the asset tag index exists, platform-info and measure-log.json are present and the
+//   TLS certificate has not expired.  Otherwise, 503 is returned with the checks that failed.  The results are
+//   cached for 5 seconds.  This endpoint does not require authentication, so the reasons of the failed checks are
+//   only written to the Trust-Agent's log.
+//
+// produces:
+//   - application/json
+// responses:
+//   '200':
+//     description: The Trust-Agent is ready.
+//     schema:
+//       "$ref": "#/responses/HealthStatusInfo"
+//   '503':
+//     description: One or more of the checks failed.
+//     schema:
+//       "$ref": "#/responses/HealthStatusInfo"
+//
+// x-sample-call-endpoint: https://trustagent.server.com:1443/v2/health/ready
+// x-sample-call-output: |
+//   {
+//     "status": "failed",
+//     "check_time": "2021-09-12T10:45:03Z",
+//     "checks": [
+//       {"name": "tpm", "status": "ok"},
+//       {"name": "aik", "status": "ok"},
+//       {"name": "asset_tag_index", "status": "failed"},
+//       {"name": "platform_info", "status": "ok"},
+//       {"name": "measure_log", "status": "ok"},
+//       {"name": "tls_certificate", "status": "ok"}
+//     ]
+//   }
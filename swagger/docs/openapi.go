@@ -0,0 +1,30 @@
+/*
+ *  Copyright (C) 2021 Intel Corporation
+ *  SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package docs
+
+//
+// swagger:operation GET /openapi.json OpenAPI getOpenAPIDocument
+// ---
+// description: |
+//   Returns the OpenAPI 3.1 document of the Trust-Agent's REST API (generated from the service's routes and the
+//   Go types of the request/response bodies).  The security scheme is 'bearerAuth', or 'mutualTLS' when
+//   TA_AUTH_MODE is 'mtls'.  This endpoint does not require authentication.
+//
+// produces:
+//   - application/json
+// responses:
+//   '200':
+//     description: Successfully retrieved the OpenAPI document.
+//     content: application/json
+//
+// x-sample-call-endpoint: https://trustagent.server.com:1443/v2/openapi.json
+// x-sample-call-output: |
+//   {
+//     "openapi": "3.1.0",
+//     "info": {"title": "Trust Agent", "version": "v4.2.0", ...},
+//     "paths": {"/aik": {"get": {"operationId": "getAik", ...}}, ...},
+//     "components": {"schemas": {...}, "securitySchemes": {...}}
+//   }
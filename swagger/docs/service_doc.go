@@ -5,6 +5,8 @@
 package docs
 
 import (
+	"intel/isecl/go-trust-agent/v4/common"
+
 	taModel "github.com/intel-secl/intel-secl/v4/pkg/model/ta"
 )
 
@@ -29,6 +31,21 @@ type TagWriteRequestInfo struct {
 	Body taModel.TagWriteRequest
 }
 
+// TpmPcrsInfo response payload
+// swagger:response TpmPcrsInfo
+type TpmPcrsInfo struct {
+	// in:body
+	Body common.TpmPcrs
+}
+
+// ErrorResponseInfo is the json body returned by all endpoints when a request fails
+// (see the 'Error Responses' section of the LLD for the list of codes).
+// swagger:response ErrorResponseInfo
+type ErrorResponseInfo struct {
+	// in:body
+	Body common.ErrorResponse
+}
+
 // swagger:operation GET /host Host getHostInfo
 // ---
 // description: |
@@ -107,12 +124,40 @@ type TagWriteRequestInfo struct {
 //     description: Successfully retrieved the Attestation Identity Key for the host.
 //     schema:
 //       type: string
+//   '404':
+//     description: The AIK has not been provisioned (AIK_MISSING).
+//     schema:
+//       "$ref": "#/responses/ErrorResponseInfo"
 //
 // x-sample-call-endpoint: https://trustagent.server.com:1443/v2/aik
 // x-sample-call-output: |
 //    200 OK [5 bytes data]
 // ---
 
+// swagger:operation GET /aik/ca Host getAikCa
+// ---
+//
+// description: |
+//   Retrieves the (der encoded) privacy-ca certificate that issued the host's AIK certificate.
+//   A valid bearer token with the 'aik_ca:retrieve' permission should be provided to authorize this REST call.
+//
+// security:
+//  - bearerAuth: []
+// produces:
+//  - application/octet-stream
+// responses:
+//   '200':
+//     description: Successfully retrieved the AIK CA certificate.
+//     schema:
+//       type: string
+//   '404':
+//     description: The privacy-ca certificate has not been downloaded.
+//
+// x-sample-call-endpoint: https://trustagent.server.com:1443/v2/aik/ca
+// x-sample-call-output: |
+//    200 OK [5 bytes data]
+// ---
+
 // swagger:operation POST /tpm/quote Host getTpmQuote
 // ---
 //
@@ -143,9 +188,16 @@ type TagWriteRequestInfo struct {
 //     "$ref": "#/definitions/TpmQuoteRequest"
 // responses:
 //   '200':
-//     description: Successfully retrieved the AIK signed quote from TPM.
+//     description: |
+//       Successfully retrieved the AIK signed quote from TPM.  In addition to the quote, the response contains
+//       the TPM's clock info (clock, resetCount, restartCount and safe) and firmware version (hex) from the
+//       quote's TPMS_ATTEST structure.
 //     schema:
 //       "$ref": "#/definitions/TpmQuoteResponse"
+//   '429':
+//     description: The caller exceeded the quote rate limit (RATE_LIMITED, see the 'Retry-After' header).
+//     schema:
+//       "$ref": "#/responses/ErrorResponseInfo"
 //
 // x-sample-call-endpoint: https://trustagent.server.com:1443/v2/tpm/quote
 // x-sample-call-input: |
@@ -178,9 +230,153 @@ type TagWriteRequestInfo struct {
 //       &lt;/selectedPcrBanks&gt;
 //       &lt;isTagProvisioned&gt;true&lt;/isTagProvisioned&gt;
 //       &lt;assetTag&gt;tHgfRQED1+pYgEZpq3dZC9ONmBCZKdx10LErTZs1k/k=&lt;/assetTag&gt;
+//       &lt;clockInfo&gt;
+//           &lt;clock&gt;1096358302&lt;/clock&gt;
+//           &lt;resetCount&gt;12&lt;/resetCount&gt;
+//           &lt;restartCount&gt;0&lt;/restartCount&gt;
+//           &lt;safe&gt;true&lt;/safe&gt;
+//       &lt;/clockInfo&gt;
+//       &lt;firmwareVersion&gt;0007005500000000&lt;/firmwareVersion&gt;
 //   &lt;/tpm_quote_response&gt;
 // ---
 
+// swagger:operation GET /tpm/pcrs Host getTpmPcrs
+// ---
+// description: |
+//   Retrieves the current PCR values (hex encoded) of the TPM's active PCR banks and the time they were read.
+//   The values are cached for 10 seconds so that frequent health probes do not require a TPM quote for each request.
+//   A valid bearer token with the 'pcrs:retrieve' permission should be provided to authorize this REST call.
+//
+// security:
+//  - bearerAuth: []
+// produces:
+//  - application/json
+// responses:
+//   '200':
+//     description: Successfully retrieved the PCR values from the TPM.
+//     schema:
+//       "$ref": "#/responses/TpmPcrsInfo"
+//   '503':
+//     description: The TPM is busy or did not respond.
+//
+// x-sample-call-endpoint: https://trustagent.server.com:1443/v2/tpm/pcrs
+// x-sample-call-output: |
+//  {
+//    "read_time": "2021-06-01T10:15:31.521394883-07:00",
+//    "pcr_banks": [
+//      {
+//        "bank": "SHA256",
+//        "pcrs": [
+//          {
+//            "index": 0,
+//            "value": "b7e3ed8c7c0c8d2b4ee8e1d1e0ae10b8e5f4e2e7b27b4b8d8a6c8f6c1d4f9a21"
+//          },
+//          {
+//            "index": 1,
+//            "value": "0cd4f7a5ae1e2e4ef5d0bd1e98bfa4b5d7f61b35a8e2c0d4f8b3e59a1c2f7e40"
+//          }
+//        ]
+//      }
+//    ]
+//  }
+// ---
+
+// swagger:operation GET /ek-certificate Host getEndorsementKeyCertificates
+// ---
+//
+// description: |
+//   Retrieves the certificate chains of the TPM's RSA and ECC endorsement keys.  Each chain starts with the EK
+//   certificate, followed by its issuing certificates when the on-die CA chain is provisioned.
+//   A valid bearer token with the 'ek_certificate:retrieve' permission should be provided to authorize this REST call.
+//
+// security:
+//  - bearerAuth: []
+// produces:
+//  - application/json
+// responses:
+//   '200':
+//     description: Successfully retrieved the EK certificates.
+//     schema:
+//       type: string
+//
+// x-sample-call-endpoint: https://trustagent.server.com:1443/v2/ek-certificate
+// x-sample-call-output: |
+//  [
+//    {
+//      "key_type": "RSA",
+//      "nv_index": "0x1c00002",
+//      "chain": [
+//        {
+//          "subject": "",
+//          "issuer": "CN=Nuvoton TPM Root CA 1110,O=Nuvoton Technology Corporation,C=TW",
+//          "serial_number": "1234567890",
+//          "not_before": "2018-06-12T00:00:00Z",
+//          "not_after": "2038-06-12T00:00:00Z",
+//          "certificate": "MIIDUzCCAjugAwIBAgIE..."
+//        }
+//      ]
+//    }
+//  ]
+// ---
+
+// swagger:operation GET /tls-certificate Host getTLSCertificateStatus
+// ---
+//
+// description: |
+//   Retrieves the expiry of the Trust-Agent's TLS certificate and the status of its automatic renewal from CMS.
+//   'token_expiry' is the expiry of the renewal token downloaded by 'tagent setup download-tls-renewal-token', which
+//   is not refreshed by the Trust-Agent and must be downloaded again before it expires.
+//   A valid bearer token with the 'tls_certificate:retrieve' permission should be provided to authorize this REST call.
+//
+// security:
+//  - bearerAuth: []
+// produces:
+//  - application/json
+// responses:
+//   '200':
+//     description: Successfully retrieved the TLS certificate status.
+//     schema:
+//       type: string
+//
+// x-sample-call-endpoint: https://trustagent.server.com:1443/v2/tls-certificate
+// x-sample-call-output: |
+//  {
+//    "status": "valid",
+//    "serial_number": "1234567890",
+//    "not_after": "2022-09-12T10:45:01Z",
+//    "renewal_enabled": true,
+//    "renewal_time": "2022-08-13T10:45:01Z",
+//    "last_attempt": "2021-09-12T10:45:03Z",
+//    "last_renewal": "2021-09-12T10:45:03Z",
+//    "token_expiry": "2022-09-01T08:12:44Z"
+//  }
+// ---
+
+// swagger:operation GET /metrics Host getMetrics
+// ---
+//
+// description: |
+//   Retrieves the Trust-Agent's metrics (requests, TPM operations, quotes, event log size, certificate and TLS
+//   renewal token expiry and NATS connection state) in the Prometheus text format.
+//   A valid bearer token with the 'metrics:retrieve' permission should be provided to authorize this REST call.
+//
+// security:
+//  - bearerAuth: []
+// produces:
+//  - text/plain
+// responses:
+//   '200':
+//     description: Successfully retrieved the metrics.
+//     schema:
+//       type: string
+//
+// x-sample-call-endpoint: https://trustagent.server.com:1443/v2/metrics
+// x-sample-call-output: |
+//  # HELP trustagent_quotes_total The number of quotes returned by the Trust-Agent by pcr bank.
+//  # TYPE trustagent_quotes_total counter
+//  trustagent_quotes_total{pcr_bank="SHA256"} 42
+// ---
+
 // swagger:operation GET /binding-key-certificate Host getBindingKeyCertificate
 // ---
 // description: |
@@ -235,7 +431,9 @@ type TagWriteRequestInfo struct {
 // ---
 //
 // description: |
-//   Writes the sha256 value of the asset tag certificate on to a particular TPM's NVRAM.
+//   Writes the digest of the asset tag certificate on to a particular TPM's NVRAM.  SHA256, SHA384 and SHA512
+//   digests are supported.  The asset tag nv index is 67 bytes: a 1 byte version, the 2 byte TPM algorithm id of
+//   the digest and the digest (zero padded to 64 bytes).  Legacy 48 byte indexes only accept SHA384 digests.
 //   A valid bearer token should be provided to authorize this REST call.
 //
 // security:
@@ -254,7 +452,12 @@ type TagWriteRequestInfo struct {
 //     "$ref": "#/definitions/TagWriteRequest"
 // responses:
 //   '200':
-//     description: Successfully wrote sha256 value of the asset tag certificate on to a TPM's NVRAM.
+//     description: Successfully wrote the versioned digest of the asset tag certificate on to a TPM's NVRAM.
+//   '409':
+//     description: |
+//       The hardware_uuid in the request does not match the host's hardware UUID (unless the agent was configured with
+//       TA_SKIP_TAG_HARDWARE_UUID_CHECK=true), or the tag is not a SHA384 digest and the asset tag index has not
+//       been migrated by 'tagent setup define-tag-index'.
 //
 // x-sample-call-endpoint: https://trustagent.server.com:1443/v2/tag
 // x-sample-call-input: |
@@ -266,6 +469,106 @@ type TagWriteRequestInfo struct {
 //    200 OK No Content
 // ---
 
+// swagger:operation GET /tag Host getAssetTag
+// ---
+//
+// description: |
+//   Retrieves the asset tag (the base64 encoded sha384 hash of the asset tag certificate) from the TPM's NVRAM,
+//   whether a tag is provisioned (i.e. the nv index is not all zeros) and the size, attributes (TPMA_NV) and
+//   auth policy (hex) of the nv index.  'attributes', 'attribute_names' and 'auth_policy' are omitted when the
+//   index's public area cannot be read from /dev/tpmrm0 ('auth_policy' is also omitted when the policy is empty).
+//   A valid bearer token with the 'tag:retrieve' permission should be provided to authorize this REST call.
+//
+// security:
+//  - bearerAuth: []
+// produces:
+//  - application/json
+// responses:
+//   '200':
+//     description: Successfully retrieved the asset tag.
+//     schema:
+//       type: object
+//
+// x-sample-call-endpoint: https://trustagent.server.com:1443/v2/tag
+// x-sample-call-output: |
+//  {
+//    "tag": "EtQNTJ3Lh1sgaaCRSncyMfbgzc1q9dor4snFY+9tvbhaWQ3m8MVnr1BsbzUIepJl",
+//    "algorithm": "SHA384",
+//    "is_provisioned": true,
+//    "nv_index": {
+//      "index": "0x1c10110",
+//      "exists": true,
+//      "size": 67,
+//      "attributes": "0x20040004",
+//      "attribute_names": [
+//        "TYPE_ORDINARY",
+//        "AUTHWRITE",
+//        "AUTHREAD",
+//        "WRITTEN"
+//      ]
+//    }
+//  }
+// ---
+
+// swagger:operation DELETE /tag Host clearAssetTag
+// ---
+//
+// description: |
+//   Clears the asset tag by writing zeros to the TPM's asset tag NVRAM index (the index is not released).  Subsequent
+//   quotes will report that the host is not tag provisioned.
+//   A valid bearer token with the 'deploy_tag:delete' permission should be provided to authorize this REST call.
+//
+// security:
+//  - bearerAuth: []
+// responses:
+//   '204':
+//     description: Successfully cleared the asset tag.
+//
+// x-sample-call-endpoint: https://trustagent.server.com:1443/v2/tag
+// x-sample-call-output: |
+//    204 No Content
+// ---
+
+// swagger:operation GET /tag/history Host getAssetTagHistory
+// ---
+//
+// description: |
+//   Retrieves the asset tag audit trail (oldest first).  Each record contains the time of the change, the caller
+//   (the bearer token subject, 'nats' for NATS requests or the local user), the operation ('deploy' or 'clear'), the previous
+//   and new tag hashes and the result of the operation.
+//   A valid bearer token with the 'tag:retrieve' permission should be provided to authorize this REST call.
+//
+// security:
+//  - bearerAuth: []
+// produces:
+//  - application/json
+// responses:
+//   '200':
+//     description: Successfully retrieved the asset tag history.
+//     schema:
+//       type: string
+//
+// x-sample-call-endpoint: https://trustagent.server.com:1443/v2/tag/history
+// x-sample-call-output: |
+//  [
+//    {
+//      "timestamp": "2021-06-03T10:21:45.318574732-07:00",
+//      "caller": "jwt:hvs-user",
+//      "operation": "deploy",
+//      "hardware_uuid": "7a569dad-2d82-49e4-9156-069b0065b262",
+//      "new_tag": "tHgfRQED1+pYgEZpq3dZC9ONmBCZKdx10LErTZs1k/k=",
+//      "result": "success"
+//    },
+//    {
+//      "timestamp": "2021-06-04T08:02:11.017261991-07:00",
+//      "caller": "cli:root",
+//      "operation": "clear",
+//      "previous_tag": "tHgfRQED1+pYgEZpq3dZC9ONmBCZKdx10LErTZs1k/k=",
+//      "result": "success"
+//    }
+//  ]
+// ---
+
 // swagger:operation POST /host/application-measurement Host getApplicationMeasurement
 // ---
 //
@@ -295,6 +598,10 @@ type TagWriteRequestInfo struct {
 //     description: Successfully measured the provided application manifest.
 //     schema:
 //       type: string
+//   '429':
+//     description: The caller exceeded the application measurement rate limit (RATE_LIMITED, see the 'Retry-After' header).
+//     schema:
+//       "$ref": "#/responses/ErrorResponseInfo"
 //
 // x-sample-call-endpoint: https://trustagent.server.com:1443/v2/host/application-measurement
 // x-sample-call-input: |
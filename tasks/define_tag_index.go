@@ -7,6 +7,7 @@ package tasks
 import (
 	"fmt"
 	"intel/isecl/go-trust-agent/v4/constants"
+	"intel/isecl/go-trust-agent/v4/util"
 	"intel/isecl/lib/common/v4/setup"
 	"intel/isecl/lib/tpmprovider/v4"
 
@@ -69,12 +70,18 @@ func (task *DefineTagIndex) Run(c setup.Context) error {
 			return errors.Wrap(err, "Failed to read existing asset tag")
 		}
 
-		if existingAssetTag == nil || len(existingAssetTag) != constants.TagIndexSize {
+		// tags in the legacy (sha384 only) layout are converted to the versioned layout
+		// (see util.AssetTagIndex)
+		existingTagIndex, err := util.ParseAssetTagIndex(existingAssetTag)
+		if err != nil {
 			// we don't know what this is so just delete it.
-			log.Warn("The existing asset tag is invalid will not be migrated")
-		} else {
-			log.Info("Migrating asset tag")
-			copy(newAssetTag, existingAssetTag)
+			log.WithError(err).Warn("The existing asset tag is invalid will not be migrated")
+		} else if existingTagIndex != nil {
+			log.Infof("Migrating %s asset tag", existingTagIndex.Algorithm)
+			newAssetTag, err = existingTagIndex.Marshal()
+			if err != nil {
+				return errors.Wrap(err, "Error migrating the existing asset tag")
+			}
 		}
 
 		// delete old nvram index so that it can be recreated
@@ -0,0 +1,107 @@
+/*
+* Copyright (C) 2021 Intel Corporation
+* SPDX-License-Identifier: BSD-3-Clause
+ */
+package tasks
+
+import (
+	"fmt"
+	"github.com/intel-secl/intel-secl/v4/pkg/clients"
+	"github.com/intel-secl/intel-secl/v4/pkg/clients/aas"
+	"github.com/intel-secl/intel-secl/v4/pkg/lib/common/crypt"
+	types "github.com/intel-secl/intel-secl/v4/pkg/model/aas"
+	"github.com/pkg/errors"
+	"intel/isecl/go-trust-agent/v4/config"
+	"intel/isecl/go-trust-agent/v4/constants"
+	"intel/isecl/lib/common/v4/setup"
+)
+
+type DownloadTLSRenewalToken struct {
+	aasUrl           string
+	hostHardwareUUID string
+	cfg              *config.TrustAgentConfiguration
+}
+
+// Downloads a long-lived token from AAS that allows the service to renew its TLS
+// certificate from CMS (i.e. the CMS 'CertApprover' role limited to the TA's CN/SAN).
+func (task *DownloadTLSRenewalToken) Run(c setup.Context) error {
+	log.Trace("tasks/download_tls_renewal_token:Run() Entering")
+	defer log.Trace("tasks/download_tls_renewal_token:Run() Leaving")
+
+	var err error
+	fmt.Println("Running setup task: download-tls-renewal-token")
+
+	bearerToken, err := c.GetenvSecret("BEARER_TOKEN", "bearer token")
+	if bearerToken == "" || err != nil {
+		return errors.Errorf(" %s is not set", constants.EnvBearerToken)
+	}
+
+	if task.hostHardwareUUID == "" {
+		return errors.Errorf("Host hardware UUID must be set to download the TLS renewal token from AAS")
+	}
+
+	if task.aasUrl == "" {
+		return errors.Errorf("%s is not set", constants.EnvAASBaseURL)
+	}
+
+	if task.cfg.TLS.CertCN == "" || task.cfg.TLS.CertSAN == "" {
+		return errors.New("The TLS certificate's common name and SAN list must be configured (see 'update-service-config')")
+	}
+
+	caCerts, err := crypt.GetCertsFromDir(constants.TrustedCaCertsDir)
+	if err != nil {
+		return errors.Wrapf(err, "tasks/download_tls_renewal_token:Run() Error while reading certs from %s", constants.TrustedCaCertsDir)
+	}
+
+	client, err := clients.HTTPClientWithCA(caCerts)
+	if err != nil {
+		return errors.Wrapf(err, "tasks/download_tls_renewal_token:Run() Error while creating http client")
+	}
+
+	aasClient := aas.Client{
+		BaseURL:    task.aasUrl,
+		JWTToken:   []byte(bearerToken),
+		HTTPClient: client,
+	}
+
+	// CMS only signs CSRs whose CN and SAN list match the role's context
+	claims := make(map[string]interface{})
+	claims["roles"] = []types.RoleInfo{
+		{
+			Service: constants.CertificateServiceName,
+			Name:    constants.CertApproverRoleName,
+			Context: "CN=" + task.cfg.TLS.CertCN + ";SAN=" + task.cfg.TLS.CertSAN + ";CERTTYPE=TLS",
+		},
+	}
+
+	createCustomerClaimsReq := types.CustomClaims{
+		Subject:      task.hostHardwareUUID,
+		ValiditySecs: constants.DefaultApiTokenExpiration,
+		Claims:       claims,
+	}
+
+	renewalTokenBytes, err := aasClient.GetCustomClaimsToken(createCustomerClaimsReq)
+	if err != nil {
+		return errors.Wrap(err, "Error while getting custom claims token")
+	}
+
+	task.cfg.TLS.RenewalToken = string(renewalTokenBytes)
+	err = task.cfg.Save()
+	if err != nil {
+		return errors.Wrap(err, "Error while saving the TLS renewal token from aas into TA configuration")
+	}
+	return nil
+}
+
+// Assume task is successful if the TLS renewal token is stored in config.yml already
+func (task *DownloadTLSRenewalToken) Validate(c setup.Context) error {
+	log.Trace("tasks/download_tls_renewal_token:Validate() Entering")
+	defer log.Trace("tasks/download_tls_renewal_token:Validate() Leaving")
+
+	if task.cfg.TLS.RenewalToken == "" {
+		return errors.Errorf("TLS renewal token does not exist in TA config.yml")
+	}
+
+	log.Debug("tasks/download_tls_renewal_token:Validate() download_tls_renewal_token setup task was successful.")
+	return nil
+}
@@ -17,6 +17,7 @@ import (
 	"intel/isecl/lib/common/v4/setup"
 	"intel/isecl/lib/tpmprovider/v4"
 	"os"
+	"strconv"
 
 	"github.com/intel-secl/intel-secl/v4/pkg/clients/hvsclient"
 	"github.com/intel-secl/intel-secl/v4/pkg/lib/privacyca"
@@ -43,6 +44,10 @@ import (
 //
 // The 'aik.cer' is served via the /v2/aik endpoint and included in /tpm/quote.
 //
+// When TA_VERIFY_EK_CHAIN is 'true', the RSA EK certificate chain is verified against the TPM
+// manufacturer CAs in /opt/trustagent/configuration/endorsement-cas/ before contacting HVS
+// (see 'tagent ekcert --verify').
+//
 // Throughout this process, the TPM is being provisioned with the aik so that calls to /tpm/quote
 // will be successful.  QUOTES WILL NOT WORK IF THE TPM IS NOT PROVISIONED CORRECTLY.
 //-------------------------------------------------------------------------------------------------
@@ -51,6 +56,7 @@ type ProvisionAttestationIdentityKey struct {
 	clientFactory  hvsclient.HVSClientFactory
 	tpmFactory     tpmprovider.TpmFactory
 	ownerSecretKey string
+	verifyEkChain  bool // TA_VERIFY_EK_CHAIN
 }
 
 func (task *ProvisionAttestationIdentityKey) Run(c setup.Context) error {
@@ -59,57 +65,91 @@ func (task *ProvisionAttestationIdentityKey) Run(c setup.Context) error {
 	fmt.Println("Running setup task: provision-aik")
 	var err error
 
+	verifyEkChain, err := c.GetenvString(constants.EnvVerifyEkChain, "Verify the EK certificate chain")
+	if err == nil && verifyEkChain != "" {
+		task.verifyEkChain, err = strconv.ParseBool(verifyEkChain)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid value for %s", constants.EnvVerifyEkChain)
+		}
+	}
+
+	aikCertBytes, err := task.provisionAttestationKey()
+	if err != nil {
+		return err
+	}
+
+	err = writeAikCertificate(constants.AikCert, aikCertBytes)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// provisionAttestationKey creates the AIK in the TPM and performs the
+// privacy-ca handshake with HVS.  The (der encoded) aik certificate is returned.
+func (task *ProvisionAttestationIdentityKey) provisionAttestationKey() ([]byte, error) {
+	log.Trace("tasks/provision_aik:provisionAttestationKey() Entering")
+	defer log.Trace("tasks/provision_aik:provisionAttestationKey() Leaving")
+
 	privacyCAClient, err := task.clientFactory.PrivacyCAClient()
 	if err != nil {
-		return errors.Wrap(err, "Failed to create privacyca-client")
+		return nil, errors.Wrap(err, "Failed to create privacyca-client")
 	}
 
 	// read the EK certificate and fail if not present...
 	ekCertBytes, err := util.GetEndorsementKeyCertificateBytes(task.ownerSecretKey)
 	if err != nil {
-		return errors.Wrap(err, "Failed to get the endorsement certificate from the TPM")
+		return nil, errors.Wrap(err, "Failed to get the endorsement certificate from the TPM")
+	}
+
+	if task.verifyEkChain {
+		err = task.verifyEndorsementKeyCertificateChain()
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// generate the aik in the tpm
 	err = task.createAik()
 	if err != nil {
-		return errors.Wrap(err, "Failed to create AIK")
+		return nil, errors.Wrap(err, "Failed to create AIK")
 	}
 
 	// create an IdentityChallengeRequest and populate it with aik information
 	identityChallengeRequest := taModel.IdentityChallengePayload{}
 	err = task.populateIdentityRequest(&identityChallengeRequest.IdentityRequest)
 	if err != nil {
-		return errors.Wrap(err, "Failed to populate the identity request")
+		return nil, errors.Wrap(err, "Failed to populate the identity request")
 	}
 
 	privacyCaCert, err := util.GetPrivacyCA()
 	if err != nil {
-		return errors.Wrap(err, "Error while retrieving privacyca certificate")
+		return nil, errors.Wrap(err, "Error while retrieving privacyca certificate")
 	}
 
 	privacyca, err := privacyca.NewPrivacyCA(identityChallengeRequest.IdentityRequest)
 	if err != nil {
-		return errors.Wrap(err, "Unable to get new privacyca instance")
+		return nil, errors.Wrap(err, "Unable to get new privacyca instance")
 	}
 
 	// Get the Identity challenge request
 	identityChallengeRequest, err = privacyca.GetIdentityChallengeRequest(ekCertBytes, privacyCaCert, identityChallengeRequest.IdentityRequest)
 	if err != nil {
-		return errors.Wrap(err, "Failed to encrypt the endorsement certificate")
+		return nil, errors.Wrap(err, "Failed to encrypt the endorsement certificate")
 	}
 
 	// send the 'challenge request' to HVS and get an 'proof request' back
 	identityProofRequest, err := privacyCAClient.GetIdentityProofRequest(&identityChallengeRequest)
 	if err != nil {
-		return errors.Wrap(err, "HVS returned an error while processing the identity proof request")
+		return nil, errors.Wrap(err, "HVS returned an error while processing the identity proof request")
 	}
 
 	// pass the HVS response to the TPM to 'activate' the 'credential' and decrypt
 	// the nonce created by HVS (IdentityProofRequest 'sym_blob')
 	decrypted1, err := task.activateCredential(identityProofRequest)
 	if err != nil {
-		return errors.Wrap(err, "tasks/provision_aik:Run() Error while performing activate credential")
+		return nil, errors.Wrap(err, "tasks/provision_aik:Run() Error while performing activate credential")
 	}
 	log.Debug("tasks/provision_aik:Run() Activate credential is successful for identity challenge request")
 
@@ -118,24 +158,24 @@ func (task *ProvisionAttestationIdentityKey) Run(c setup.Context) error {
 
 	err = task.populateIdentityRequest(&identityChallengeResponse.IdentityRequest)
 	if err != nil {
-		return errors.Wrap(err, "Failed to populate the identity challenge response")
+		return nil, errors.Wrap(err, "Failed to populate the identity challenge response")
 	}
 
 	identityChallengeResponse, err = privacyca.GetIdentityChallengeRequest(decrypted1, privacyCaCert, identityChallengeResponse.IdentityRequest)
 	if err != nil {
-		return errors.Wrap(err, "Failed to retrieve the identity challenge from HVS")
+		return nil, errors.Wrap(err, "Failed to retrieve the identity challenge from HVS")
 	}
 
 	// send the decrypted nonce data back to HVS and get a 'proof request' back
 	identityProofRequest2, err := privacyCAClient.GetIdentityProofResponse(&identityChallengeResponse)
 	if err != nil {
-		return errors.Wrap(err, "HVS returned an error while processing the identity proof response")
+		return nil, errors.Wrap(err, "HVS returned an error while processing the identity proof response")
 	}
 
 	// decrypt the 'proof request' from HVS into the 'aik' cert
 	decrypted2, err := task.activateCredential(identityProofRequest2)
 	if err != nil {
-		return errors.Wrap(err, "Failed to activate credential")
+		return nil, errors.Wrap(err, "Failed to activate credential")
 	}
 
 	log.Debug("tasks/provision_aik:Run() Activate credential is successful for identity challenge response")
@@ -143,19 +183,19 @@ func (task *ProvisionAttestationIdentityKey) Run(c setup.Context) error {
 	// make sure the decrypted bytes are a valid certificates...
 	_, err = x509.ParseCertificate(decrypted2)
 	if err != nil {
-		return errors.Wrap(err, "The decrypted AIK is not a valid x509 certificate")
+		return nil, errors.Wrap(err, "The decrypted AIK is not a valid x509 certificate")
 	}
 
-	certOut, err := os.OpenFile(constants.AikCert, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
-	if err != nil {
-		return errors.Wrapf(err, "Could not create file %s", constants.AikCert)
-	}
-	defer func() {
-		err = certOut.Close()
-	}()
+	return decrypted2, nil
+}
 
-	if err := pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: decrypted2}); err != nil {
-		return errors.Wrap(err, "Could not encode the AIK to pem")
+// writeAikCertificate saves the (der encoded) aik certificate to 'aikCertPath' in pem format.  The
+// file is replaced atomically and owned by the tagent user, so that the service can read the
+// certificate when it is renewed while the service is running (see 'renew-aik').
+func writeAikCertificate(aikCertPath string, aikCertBytes []byte) error {
+	err := util.WriteFileAtomic(aikCertPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: aikCertBytes}), 0640)
+	if err != nil {
+		return errors.Wrap(err, "Could not save the AIK certificate")
 	}
 
 	return nil
@@ -173,6 +213,47 @@ func (task *ProvisionAttestationIdentityKey) Validate(c setup.Context) error {
 	return nil
 }
 
+// verifyEndorsementKeyCertificateChain fails when the RSA EK certificate chain is not issued by
+// one of the TPM manufacturer CAs in constants.EndorsementCADir (HVS would otherwise reject
+// the EK with an opaque error during the privacy-ca handshake).
+func (task *ProvisionAttestationIdentityKey) verifyEndorsementKeyCertificateChain() error {
+	log.Trace("tasks/provision_aik:verifyEndorsementKeyCertificateChain() Entering")
+	defer log.Trace("tasks/provision_aik:verifyEndorsementKeyCertificateChain() Leaving")
+
+	roots, caCount, err := util.LoadEndorsementCAs(constants.EndorsementCADir)
+	if err != nil {
+		return errors.Wrap(err, "Failed to load the TPM manufacturer CAs")
+	}
+
+	if caCount == 0 {
+		return errors.Errorf("%s is 'true' but no TPM manufacturer CAs are installed in %s", constants.EnvVerifyEkChain, constants.EndorsementCADir)
+	}
+
+	tpm, err := task.tpmFactory.NewTpmProvider()
+	if err != nil {
+		return errors.Wrap(err, "Could not create TpmProvider")
+	}
+	defer tpm.Close()
+
+	chain, err := util.GetEndorsementKeyCertificateChain(tpm, task.ownerSecretKey, tpmprovider.NV_IDX_RSA_ENDORSEMENT_CERTIFICATE)
+	if err != nil {
+		return errors.Wrap(err, "Failed to read the EK certificate chain")
+	}
+
+	if chain == nil {
+		return errors.Errorf("The TPM does not have an RSA EK Certificate at the default index 0x%x", tpmprovider.NV_IDX_RSA_ENDORSEMENT_CERTIFICATE)
+	}
+
+	manufacturerID, model, _ := util.GetTpmManufacturerInfo(chain[0])
+	trustAnchor, err := util.VerifyEndorsementKeyCertificateChain(chain, roots)
+	if err != nil {
+		return errors.Wrapf(err, "The EK certificate of the %s TPM (model '%s') is not trusted by the CAs in %s", util.GetTpmVendorName(manufacturerID), model, constants.EndorsementCADir)
+	}
+
+	log.Infof("tasks/provision_aik:verifyEndorsementKeyCertificateChain() The EK certificate chain is trusted by '%s'", trustAnchor.Subject.String())
+	return nil
+}
+
 func (task *ProvisionAttestationIdentityKey) createAik() error {
 	log.Trace("tasks/provision_aik:createAik() Entering")
 	defer log.Trace("tasks/provision_aik:createAik() Leaving")
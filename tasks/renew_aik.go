@@ -0,0 +1,117 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package tasks
+
+import (
+	"fmt"
+	"intel/isecl/go-trust-agent/v4/config"
+	"intel/isecl/go-trust-agent/v4/constants"
+	"intel/isecl/go-trust-agent/v4/util"
+	"intel/isecl/lib/common/v4/setup"
+	"intel/isecl/lib/tpmprovider/v4"
+	"os"
+	"strconv"
+
+	"github.com/intel-secl/intel-secl/v4/pkg/clients/hvsclient"
+	"github.com/pkg/errors"
+)
+
+//-------------------------------------------------------------------------------------------------
+// R E N E W   A I K
+//-------------------------------------------------------------------------------------------------
+// RenewAttestationIdentityKey replaces the AIK with a new key and certificate, ex. before the
+// current certificate expires.
+//
+// The TPM provider can only create the AIK at tpmprovider.TPM_HANDLE_AIK (CreateAik evicts the
+// current key) and only quotes with the key at that handle, so the new key cannot be created and
+// certified next to the current one and swapped in afterwards.  Renewing the AIK therefore
+// interrupts attestation and the task refuses to run unless TA_FORCE_AIK_RENEWAL is 'true':
+//
+// 1.) The privacy-ca certificate and the EK certificate are read before the TPM is changed.
+// 2.) A new AIK is created with TpmProvider.CreateAik and the privacy-ca handshake with HVS is
+// performed for the new key (see ProvisionAttestationIdentityKey).
+// 3.) The new certificate is written to aik.pem with util.WriteFileAtomic.
+//
+// Between 2.) and 3.), the service responds to quote requests with 503/AIK_RENEWAL_IN_PROGRESS
+// (see common.checkAikCertificate).  If the handshake with HVS fails, 'renew-aik' (or
+// 'provision-aik') must be run again before quotes can be created.
+//-------------------------------------------------------------------------------------------------
+
+type RenewAttestationIdentityKey struct {
+	clientFactory  hvsclient.HVSClientFactory
+	tpmFactory     tpmprovider.TpmFactory
+	ownerSecretKey string
+	cfg            *config.TrustAgentConfiguration
+	aikCertPath    string // constants.AikCert
+	force          bool   // TA_FORCE_AIK_RENEWAL
+}
+
+func (task *RenewAttestationIdentityKey) Run(c setup.Context) error {
+	log.Trace("tasks/renew_aik:Run() Entering")
+	defer log.Trace("tasks/renew_aik:Run() Leaving")
+	fmt.Println("Running setup task: renew-aik")
+
+	if _, err := os.Stat(task.aikCertPath); os.IsNotExist(err) {
+		return errors.Errorf("The AIK has not been provisioned, run 'tagent setup %s'", ProvisionAttestationIdentityKeyCommand)
+	}
+
+	force, err := c.GetenvString(constants.EnvForceAikRenewal, "Force the AIK renewal")
+	if err == nil && force != "" {
+		task.force, err = strconv.ParseBool(force)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid value for %s", constants.EnvForceAikRenewal)
+		}
+	}
+
+	if !task.force {
+		return errors.Errorf("The TPM provider can only create the AIK at handle 0x%x, so the current AIK is evicted before HVS "+
+			"certifies the new key and quotes fail until the handshake completes.  Set %s=true to renew the AIK anyway",
+			tpmprovider.TPM_HANDLE_AIK, constants.EnvForceAikRenewal)
+	}
+
+	// make sure the handshake can be started before the current AIK is evicted
+	_, err = util.GetPrivacyCA()
+	if err != nil {
+		return errors.Wrap(err, "Error while retrieving privacyca certificate")
+	}
+
+	_, err = util.GetEndorsementKeyCertificateBytes(task.ownerSecretKey)
+	if err != nil {
+		return errors.Wrap(err, "Failed to get the endorsement certificate from the TPM")
+	}
+
+	secLog.Warnf("tasks/renew_aik:Run() %s is 'true', evicting the AIK at handle 0x%x", constants.EnvForceAikRenewal, tpmprovider.TPM_HANDLE_AIK)
+
+	provisionTask := ProvisionAttestationIdentityKey{
+		clientFactory:  task.clientFactory,
+		tpmFactory:     task.tpmFactory,
+		ownerSecretKey: task.ownerSecretKey,
+	}
+
+	aikCertBytes, err := provisionTask.provisionAttestationKey()
+	if err != nil {
+		return errors.Wrapf(err, "Failed to provision the renewed AIK, run 'tagent setup %s' again", RenewAttestationIdentityKeyCommand)
+	}
+
+	err = writeAikCertificate(task.aikCertPath, aikCertBytes)
+	if err != nil {
+		return err
+	}
+
+	secLog.Infof("tasks/renew_aik:Run() The AIK was renewed, the new certificate was saved to %s", task.aikCertPath)
+	return nil
+}
+
+func (task *RenewAttestationIdentityKey) Validate(c setup.Context) error {
+	log.Trace("tasks/renew_aik:Validate() Entering")
+	defer log.Trace("tasks/renew_aik:Validate() Leaving")
+
+	if _, err := os.Stat(task.aikCertPath); os.IsNotExist(err) {
+		return errors.Wrapf(err, "The aik certificate %s does not exist", task.aikCertPath)
+	}
+
+	log.Debug("tasks/renew_aik:Validate() Renewing the AIK was successful.")
+	return nil
+}
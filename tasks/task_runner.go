@@ -36,6 +36,8 @@ const (
 	DefineTagIndexCommand                  = "define-tag-index"
 	DownloadCredentialCommand              = "download-credential"
 	DownloadApiTokenCommand                = "download-api-token"
+	RenewAttestationIdentityKeyCommand     = "renew-aik"
+	DownloadTLSRenewalTokenCommand         = "download-tls-renewal-token"
 )
 
 var log = commLog.GetDefaultLogger()
@@ -65,7 +67,8 @@ func CreateTaskRunner(setupCmd string, cfg *config.TrustAgentConfiguration) (*se
 
 	switch setupCmd {
 	case DefaultSetupCommand, ProvisionAttestationIdentityKeyCommand, ProvisionAttestationCommand,
-		DownloadPrivacyCACommand, CreateHostCommand, CreateHostUniqueFlavorCommand, GetConfiguredManifestCommand:
+		DownloadPrivacyCACommand, CreateHostCommand, CreateHostUniqueFlavorCommand, GetConfiguredManifestCommand,
+		RenewAttestationIdentityKeyCommand:
 		vsClientFactory, err = hvsclient.NewVSClientFactory(cfg.HVS.Url, util.GetBearerToken(),
 			constants.TrustedCaCertsDir)
 		if err != nil {
@@ -76,7 +79,7 @@ func CreateTaskRunner(setupCmd string, cfg *config.TrustAgentConfiguration) (*se
 	case TakeOwnershipCommand, ProvisionPrimaryKeyCommand, DefineTagIndexCommand:
 		switch setupCmd {
 		case DefaultSetupCommand, ProvisionAttestationIdentityKeyCommand, ProvisionAttestationCommand,
-			TakeOwnershipCommand, ProvisionPrimaryKeyCommand:
+			TakeOwnershipCommand, ProvisionPrimaryKeyCommand, RenewAttestationIdentityKeyCommand:
 			tpmFactory, err = tpmprovider.NewTpmFactory()
 			if err != nil {
 				return nil, errors.Wrap(err, "Could not create tpm factory")
@@ -120,6 +123,14 @@ func CreateTaskRunner(setupCmd string, cfg *config.TrustAgentConfiguration) (*se
 		ownerSecretKey: ownerSecret,
 	}
 
+	renewAttestationIdentityKeyTask := &RenewAttestationIdentityKey{
+		clientFactory:  vsClientFactory,
+		tpmFactory:     tpmFactory,
+		ownerSecretKey: ownerSecret,
+		cfg:            cfg,
+		aikCertPath:    constants.AikCert,
+	}
+
 	downloadPrivacyCATask := &DownloadPrivacyCA{
 		clientFactory: vsClientFactory,
 	}
@@ -158,9 +169,17 @@ func CreateTaskRunner(setupCmd string, cfg *config.TrustAgentConfiguration) (*se
 		tagSecretKey:   &cfg.Tpm.TagSecretKey,
 	}
 
+	hostHardwareUUID := hostinfo.NewHostInfoParser().Parse().HardwareUUID
+
 	downloadApiToken := &DownloadApiToken{
 		aasUrl:           cfg.AAS.BaseURL,
-		hostHardwareUUID: hostinfo.NewHostInfoParser().Parse().HardwareUUID,
+		hostHardwareUUID: hostHardwareUUID,
+		cfg:              cfg,
+	}
+
+	downloadTLSRenewalToken := &DownloadTLSRenewalToken{
+		aasUrl:           cfg.AAS.BaseURL,
+		hostHardwareUUID: hostHardwareUUID,
 		cfg:              cfg,
 	}
 
@@ -187,7 +206,7 @@ func CreateTaskRunner(setupCmd string, cfg *config.TrustAgentConfiguration) (*se
 		if cfg.Mode == constants.CommunicationModeOutbound {
 			runner.Tasks = append(runner.Tasks, downloadCredentialTask)
 		} else {
-			runner.Tasks = append(runner.Tasks, downloadTLSCertTask)
+			runner.Tasks = append(runner.Tasks, []setup.Task{downloadTLSCertTask, downloadTLSRenewalToken}...)
 		}
 
 	case DownloadRootCACertCommand:
@@ -208,6 +227,9 @@ func CreateTaskRunner(setupCmd string, cfg *config.TrustAgentConfiguration) (*se
 	case ProvisionPrimaryKeyCommand:
 		runner.Tasks = append(runner.Tasks, provisionPrimaryKeyTask)
 
+	case RenewAttestationIdentityKeyCommand:
+		runner.Tasks = append(runner.Tasks, renewAttestationIdentityKeyTask)
+
 	case UpdateServiceConfigCommand:
 		runner.Tasks = append(runner.Tasks, updateServiceConfigTask)
 
@@ -217,6 +239,9 @@ func CreateTaskRunner(setupCmd string, cfg *config.TrustAgentConfiguration) (*se
 	case DownloadApiTokenCommand:
 		runner.Tasks = append(runner.Tasks, downloadApiToken)
 
+	case DownloadTLSRenewalTokenCommand:
+		runner.Tasks = append(runner.Tasks, downloadTLSRenewalToken)
+
 	case DownloadCredentialCommand:
 		if cfg.Mode == constants.CommunicationModeOutbound {
 			runner.Tasks = append(runner.Tasks, downloadCredentialTask)
@@ -5,11 +5,14 @@
 package tasks
 
 import (
+	"encoding/pem"
 	"intel/isecl/go-trust-agent/v4/config"
 	"intel/isecl/go-trust-agent/v4/constants"
 	"intel/isecl/lib/common/v4/setup"
 	"intel/isecl/lib/tpmprovider/v4"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/google/uuid"
@@ -170,3 +173,90 @@ func TestCreateHostExisting(t *testing.T) {
 	err := createHost.Run(context)
 	assert.Error(err)
 }
+
+func TestRenewAikNotProvisioned(t *testing.T) {
+	assert := assert.New(t)
+
+	// renew-aik must not replace the AIK when it was never provisioned
+	mockedTpmProvider := new(tpmprovider.MockedTpmProvider)
+	mockedTpmFactory := tpmprovider.MockedTpmFactory{TpmProvider: mockedTpmProvider}
+
+	renewAik := RenewAttestationIdentityKey{
+		tpmFactory:  mockedTpmFactory,
+		cfg:         &config.TrustAgentConfiguration{},
+		aikCertPath: filepath.Join(os.TempDir(), "does-not-exist", "aik.pem"),
+	}
+
+	err := renewAik.Run(setup.Context{})
+	assert.Error(err)
+	mockedTpmProvider.AssertNotCalled(t, "CreateAik", mock.Anything, mock.Anything)
+}
+
+func TestRenewAikNotForced(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "aik")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	aikCertPath := filepath.Join(dir, "aik.pem")
+	err = ioutil.WriteFile(aikCertPath, []byte("current"), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without TA_FORCE_AIK_RENEWAL=true the current AIK must not be evicted
+	for _, force := range []string{"", "false", "invalid"} {
+		os.Setenv(constants.EnvForceAikRenewal, force)
+
+		mockedTpmProvider := new(tpmprovider.MockedTpmProvider)
+		renewAik := RenewAttestationIdentityKey{
+			tpmFactory:  tpmprovider.MockedTpmFactory{TpmProvider: mockedTpmProvider},
+			cfg:         &config.TrustAgentConfiguration{},
+			aikCertPath: aikCertPath,
+		}
+
+		err = renewAik.Run(setup.Context{})
+		assert.Error(err, force)
+		mockedTpmProvider.AssertNotCalled(t, "CreateAik", mock.Anything)
+	}
+	os.Unsetenv(constants.EnvForceAikRenewal)
+
+	currentBytes, err := ioutil.ReadFile(aikCertPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal([]byte("current"), currentBytes)
+}
+
+func TestWriteAikCertificate(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "aik")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	aikCertPath := filepath.Join(dir, "aik.pem")
+	err = ioutil.WriteFile(aikCertPath, []byte("previous"), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writeAikCertificate(aikCertPath, []byte{0x30, 0x82})
+	assert.NoError(err)
+
+	data, err := ioutil.ReadFile(aikCertPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := pem.Decode(data)
+	if assert.NotNil(block) {
+		assert.Equal("CERTIFICATE", block.Type)
+		assert.Equal([]byte{0x30, 0x82}, block.Bytes)
+	}
+}
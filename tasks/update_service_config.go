@@ -81,6 +81,18 @@ func (task *UpdateServiceConfig) Run(c setup.Context) error {
 		}
 	}
 
+	//---------------------------------------------------------------------------------------------
+	// TA_SKIP_TAG_HARDWARE_UUID_CHECK
+	//---------------------------------------------------------------------------------------------
+	(*task.cfg).AssetTag.SkipHardwareUUIDCheck = false
+	skipTagHardwareUUIDCheck, err := c.GetenvString(constants.EnvSkipTagHardwareUUIDCheck, "Trustagent skip asset tag hardware uuid check")
+	if err == nil && skipTagHardwareUUIDCheck != "" {
+		(*task.cfg).AssetTag.SkipHardwareUUIDCheck, err = strconv.ParseBool(skipTagHardwareUUIDCheck)
+		if err != nil {
+			fmt.Println("Error while parsing the variable, ", constants.EnvSkipTagHardwareUUIDCheck, " setting to default value false")
+		}
+	}
+
 	//---------------------------------------------------------------------------------------------
 	// HTTP Server Settings
 	//---------------------------------------------------------------------------------------------
@@ -124,6 +136,143 @@ func (task *UpdateServiceConfig) Run(c setup.Context) error {
 		(*task.cfg).WebService.MaxHeaderBytes = maxHeaderBytes
 	}
 
+	requestTimeout, err := c.GetenvInt(constants.EnvTAServerRequestTimeout, "Trustagent Request Timeout")
+	if err != nil || requestTimeout <= 0 {
+		log.Debug("tasks/update_service_config:Run() could not parse the variable ", constants.EnvTAServerRequestTimeout, ", setting default value 30s")
+		(*task.cfg).WebService.RequestTimeout = constants.DefaultRequestTimeout
+	} else {
+		(*task.cfg).WebService.RequestTimeout = time.Duration(requestTimeout) * time.Second
+	}
+
+	authMode, err := c.GetenvString(constants.EnvAuthMode, "Trustagent Authentication Mode")
+	if err != nil || authMode == "" {
+		(*task.cfg).WebService.AuthMode = constants.DefaultAuthMode
+	} else if authMode == constants.AuthModeJWT || authMode == constants.AuthModeMTLS {
+		(*task.cfg).WebService.AuthMode = authMode
+	} else {
+		return errors.Errorf("Invalid value '%s' for %s (should be '%s' or '%s')", authMode, constants.EnvAuthMode, constants.AuthModeJWT, constants.AuthModeMTLS)
+	}
+
+	//---------------------------------------------------------------------------------------------
+	// NATS Settings
+	//---------------------------------------------------------------------------------------------
+	natsRequestTimeout, err := c.GetenvInt(constants.EnvNatsRequestTimeout, "Trustagent NATS Request Timeout")
+	if err != nil || natsRequestTimeout <= 0 {
+		log.Debug("tasks/update_service_config:Run() could not parse the variable ", constants.EnvNatsRequestTimeout, ", setting default value 30s")
+		(*task.cfg).Nats.RequestTimeout = constants.DefaultNatsRequestTimeout
+	} else {
+		(*task.cfg).Nats.RequestTimeout = time.Duration(natsRequestTimeout) * time.Second
+	}
+
+	//---------------------------------------------------------------------------------------------
+	// TA_METRICS_PORT
+	//---------------------------------------------------------------------------------------------
+	metricsPort, err := c.GetenvInt(constants.EnvMetricsPort, "Trustagent Metrics Port")
+	if err != nil || metricsPort <= 0 || metricsPort > 65535 {
+		// zero indicates that metrics are only available from /v2/metrics
+		(*task.cfg).Metrics.Port = 0
+	} else {
+		(*task.cfg).Metrics.Port = metricsPort
+	}
+
+	//---------------------------------------------------------------------------------------------
+	// Rate Limits (zero disables a limit)
+	//---------------------------------------------------------------------------------------------
+	defaultRateLimits := config.DefaultRateLimits()
+	rateLimits := []struct {
+		rateLimit                *config.RateLimit
+		envRateLimit             string
+		envRateBurst             string
+		envMaxInFlight           string
+		envMaxInFlightPerSubject string
+		defaults                 config.RateLimit
+	}{
+		{&(*task.cfg).RateLimits.Quote, constants.EnvQuoteRateLimit, constants.EnvQuoteRateBurst, constants.EnvQuoteMaxInFlight, constants.EnvQuoteMaxInFlightPerSubject,
+			defaultRateLimits.Quote},
+		{&(*task.cfg).RateLimits.ApplicationMeasurement, constants.EnvMeasurementRateLimit, constants.EnvMeasurementRateBurst, constants.EnvMeasurementMaxInFlight, constants.EnvMeasurementMaxInFlightPerSubject,
+			defaultRateLimits.ApplicationMeasurement},
+		{&(*task.cfg).RateLimits.Default, constants.EnvDefaultRateLimit, constants.EnvDefaultRateBurst, constants.EnvDefaultMaxInFlight, constants.EnvDefaultMaxInFlightPerSubject,
+			defaultRateLimits.Default},
+	}
+
+	for _, limit := range rateLimits {
+		*limit.rateLimit = limit.defaults
+
+		requestsPerSecond, err := c.GetenvString(limit.envRateLimit, "Trustagent Rate Limit (requests per second)")
+		if err == nil && requestsPerSecond != "" {
+			rate, err := strconv.ParseFloat(requestsPerSecond, 64)
+			if err != nil || rate < 0 {
+				return errors.Errorf("Invalid value '%s' for %s", requestsPerSecond, limit.envRateLimit)
+			}
+			limit.rateLimit.RequestsPerSecond = rate
+		}
+
+		for _, intLimit := range []struct {
+			env   string
+			value *int
+		}{
+			{limit.envRateBurst, &limit.rateLimit.Burst},
+			{limit.envMaxInFlight, &limit.rateLimit.MaxInFlight},
+			{limit.envMaxInFlightPerSubject, &limit.rateLimit.MaxInFlightPerSubject},
+		} {
+			value, err := c.GetenvInt(intLimit.env, "Trustagent Rate Limit")
+			if err == nil && value >= 0 {
+				*intLimit.value = value
+			} else {
+				log.Debug("tasks/update_service_config:Run() could not parse the variable ", intLimit.env, ", using the default value ", *intLimit.value)
+			}
+		}
+	}
+
+	//---------------------------------------------------------------------------------------------
+	// TPM Access Settings
+	//---------------------------------------------------------------------------------------------
+	tpmQueueSize, err := c.GetenvInt(constants.EnvTpmQueueSize, "Trustagent TPM Request Queue Size")
+	if err != nil || tpmQueueSize <= 0 {
+		log.Debug("tasks/update_service_config:Run() could not parse the variable ", constants.EnvTpmQueueSize, ", setting default value ", constants.DefaultTpmQueueSize)
+		(*task.cfg).Tpm.QueueSize = constants.DefaultTpmQueueSize
+	} else {
+		(*task.cfg).Tpm.QueueSize = tpmQueueSize
+	}
+
+	tpmOperationTimeout, err := c.GetenvInt(constants.EnvTpmOperationTimeout, "Trustagent TPM Operation Timeout")
+	if err != nil || tpmOperationTimeout <= 0 {
+		log.Debug("tasks/update_service_config:Run() could not parse the variable ", constants.EnvTpmOperationTimeout, ", setting default value 30s")
+		(*task.cfg).Tpm.OperationTimeout = constants.DefaultTpmOperationTimeout
+	} else {
+		(*task.cfg).Tpm.OperationTimeout = time.Duration(tpmOperationTimeout) * time.Second
+	}
+
+	//---------------------------------------------------------------------------------------------
+	// AIK Expiry Check
+	//---------------------------------------------------------------------------------------------
+	aikExpiryCheckInterval, err := c.GetenvInt(constants.EnvAikExpiryCheckInterval, "Trustagent AIK Expiry Check Interval")
+	if err != nil || aikExpiryCheckInterval < 0 {
+		log.Debug("tasks/update_service_config:Run() could not parse the variable ", constants.EnvAikExpiryCheckInterval, ", setting default value 24h")
+		(*task.cfg).Tpm.AikExpiryCheckInterval = constants.DefaultAikExpiryCheckInterval
+	} else {
+		(*task.cfg).Tpm.AikExpiryCheckInterval = time.Duration(aikExpiryCheckInterval) * time.Hour
+	}
+
+	aikExpiryWarningDays, err := c.GetenvInt(constants.EnvAikExpiryWarningDays, "Trustagent AIK Expiry Warning Days")
+	if err != nil || aikExpiryWarningDays <= 0 {
+		log.Debug("tasks/update_service_config:Run() could not parse the variable ", constants.EnvAikExpiryWarningDays, ", setting default value ", constants.DefaultAikExpiryWarningDays)
+		(*task.cfg).Tpm.AikExpiryWarningDays = constants.DefaultAikExpiryWarningDays
+	} else {
+		(*task.cfg).Tpm.AikExpiryWarningDays = aikExpiryWarningDays
+	}
+
+	//---------------------------------------------------------------------------------------------
+	// TA_TLS_CERT_RENEWAL_DAYS
+	//---------------------------------------------------------------------------------------------
+	tlsCertRenewalDays, err := c.GetenvInt(constants.EnvTLSCertRenewalDays, "Trustagent TLS Certificate Renewal Days")
+	if err != nil || tlsCertRenewalDays < 0 {
+		log.Debug("tasks/update_service_config:Run() could not parse the variable ", constants.EnvTLSCertRenewalDays, ", setting default value ", constants.DefaultTLSCertRenewalDays)
+		(*task.cfg).TLS.RenewalDays = constants.DefaultTLSCertRenewalDays
+	} else {
+		(*task.cfg).TLS.RenewalDays = tlsCertRenewalDays
+	}
+
 	return nil
 }
 
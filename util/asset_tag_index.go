@@ -0,0 +1,120 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package util
+
+import (
+	"encoding/binary"
+	"intel/isecl/go-trust-agent/v4/constants"
+
+	"github.com/pkg/errors"
+)
+
+// The asset tag nv index (constants.TagIndexSize bytes) is laid out as...
+//
+//	version   (1 byte, constants.TagIndexVersion)
+//	algorithm (2 bytes, big endian TPM_ALG_ID of the digest)
+//	digest    (64 bytes, zero padded for SHA256/SHA384)
+//
+// An index filled with zeros does not contain a tag.  Indexes created by previous
+// versions of the Trust-Agent (constants.LegacyTagIndexSize bytes) contain the raw
+// SHA384 digest and are migrated by 'define-tag-index'.
+const (
+	tagIndexHeaderSize = 3
+
+	tpmAlgSHA256 uint16 = 0x000B
+	tpmAlgSHA384 uint16 = 0x000C
+	tpmAlgSHA512 uint16 = 0x000D
+)
+
+var tagDigestAlgorithms = []struct {
+	algID     uint16
+	algorithm constants.SHAAlgorithm
+	size      int
+}{
+	{tpmAlgSHA256, constants.SHA256, 32},
+	{tpmAlgSHA384, constants.SHA384, 48},
+	{tpmAlgSHA512, constants.SHA512, 64},
+}
+
+// AssetTagIndex is the tag digest stored in the asset tag nv index.
+type AssetTagIndex struct {
+	Algorithm constants.SHAAlgorithm
+	Digest    []byte
+}
+
+// NewAssetTagIndex creates an AssetTagIndex for 'digest', determining the algorithm
+// (SHA256, SHA384 or SHA512) from its length.
+func NewAssetTagIndex(digest []byte) (*AssetTagIndex, error) {
+	for _, alg := range tagDigestAlgorithms {
+		if len(digest) == alg.size {
+			return &AssetTagIndex{Algorithm: alg.algorithm, Digest: digest}, nil
+		}
+	}
+
+	return nil, errors.Errorf("Invalid tag length %d (SHA256, SHA384 and SHA512 digests are supported)", len(digest))
+}
+
+// ParseAssetTagIndex parses the contents of the asset tag nv index (in either the
+// current or legacy layout).  nil is returned when the index does not contain a tag.
+func ParseAssetTagIndex(indexBytes []byte) (*AssetTagIndex, error) {
+	if isAllZeros(indexBytes) {
+		return nil, nil
+	}
+
+	if len(indexBytes) == constants.LegacyTagIndexSize {
+		return &AssetTagIndex{Algorithm: constants.SHA384, Digest: indexBytes}, nil
+	}
+
+	if len(indexBytes) != constants.TagIndexSize {
+		return nil, errors.Errorf("Invalid tag index length %d", len(indexBytes))
+	}
+
+	if indexBytes[0] != constants.TagIndexVersion {
+		return nil, errors.Errorf("Unsupported tag index version %d", indexBytes[0])
+	}
+
+	algID := binary.BigEndian.Uint16(indexBytes[1:tagIndexHeaderSize])
+	for _, alg := range tagDigestAlgorithms {
+		if algID == alg.algID {
+			return &AssetTagIndex{
+				Algorithm: alg.algorithm,
+				Digest:    indexBytes[tagIndexHeaderSize : tagIndexHeaderSize+alg.size],
+			}, nil
+		}
+	}
+
+	return nil, errors.Errorf("Unsupported tag algorithm 0x%04x", algID)
+}
+
+// Marshal returns the AssetTagIndex in the (current) layout of the asset tag nv index.
+func (tagIndex *AssetTagIndex) Marshal() ([]byte, error) {
+	for _, alg := range tagDigestAlgorithms {
+		if tagIndex.Algorithm != alg.algorithm {
+			continue
+		}
+
+		if len(tagIndex.Digest) != alg.size {
+			return nil, errors.Errorf("Invalid %s digest length %d", alg.algorithm, len(tagIndex.Digest))
+		}
+
+		indexBytes := make([]byte, constants.TagIndexSize)
+		indexBytes[0] = constants.TagIndexVersion
+		binary.BigEndian.PutUint16(indexBytes[1:tagIndexHeaderSize], alg.algID)
+		copy(indexBytes[tagIndexHeaderSize:], tagIndex.Digest)
+		return indexBytes, nil
+	}
+
+	return nil, errors.Errorf("Unsupported tag algorithm '%s'", tagIndex.Algorithm)
+}
+
+func isAllZeros(data []byte) bool {
+	for _, v := range data {
+		if v != 0 {
+			return false
+		}
+	}
+
+	return true
+}
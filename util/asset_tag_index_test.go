@@ -0,0 +1,89 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package util
+
+import (
+	"bytes"
+	"intel/isecl/go-trust-agent/v4/constants"
+	"testing"
+)
+
+func TestAssetTagIndexRoundTrip(t *testing.T) {
+	for _, alg := range tagDigestAlgorithms {
+		digest := bytes.Repeat([]byte{0xa5}, alg.size)
+
+		tagIndex, err := NewAssetTagIndex(digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tagIndex.Algorithm != alg.algorithm {
+			t.Fatalf("Expected algorithm %s, got %s", alg.algorithm, tagIndex.Algorithm)
+		}
+
+		indexBytes, err := tagIndex.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(indexBytes) != constants.TagIndexSize {
+			t.Fatalf("Expected index length %d, got %d", constants.TagIndexSize, len(indexBytes))
+		}
+
+		parsed, err := ParseAssetTagIndex(indexBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if parsed.Algorithm != alg.algorithm || !bytes.Equal(parsed.Digest, digest) {
+			t.Fatalf("The parsed %s tag did not match", alg.algorithm)
+		}
+	}
+}
+
+func TestAssetTagIndexLegacyAndEmpty(t *testing.T) {
+	legacyDigest := bytes.Repeat([]byte{0x01}, constants.LegacyTagIndexSize)
+	tagIndex, err := ParseAssetTagIndex(legacyDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tagIndex.Algorithm != constants.SHA384 || !bytes.Equal(tagIndex.Digest, legacyDigest) {
+		t.Fatal("The legacy tag was not parsed as a SHA384 digest")
+	}
+
+	for _, size := range []int{constants.LegacyTagIndexSize, constants.TagIndexSize} {
+		tagIndex, err = ParseAssetTagIndex(make([]byte, size))
+		if err != nil || tagIndex != nil {
+			t.Fatalf("Expected an empty %d byte index to contain no tag", size)
+		}
+	}
+}
+
+func TestAssetTagIndexInvalid(t *testing.T) {
+	_, err := NewAssetTagIndex(make([]byte, 20))
+	if err == nil {
+		t.Fatal("Expected an error for a SHA1 length tag")
+	}
+
+	indexBytes := make([]byte, constants.TagIndexSize)
+	indexBytes[0] = constants.TagIndexVersion + 1
+	_, err = ParseAssetTagIndex(indexBytes)
+	if err == nil {
+		t.Fatal("Expected an error for an unsupported version")
+	}
+
+	indexBytes[0] = constants.TagIndexVersion
+	indexBytes[2] = 0x04 // TPM_ALG_SHA1
+	_, err = ParseAssetTagIndex(indexBytes)
+	if err == nil {
+		t.Fatal("Expected an error for an unsupported algorithm")
+	}
+
+	_, err = ParseAssetTagIndex([]byte{1, 2, 3})
+	if err == nil {
+		t.Fatal("Expected an error for an invalid length")
+	}
+}
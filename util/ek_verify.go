@@ -0,0 +1,312 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package util
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"intel/isecl/lib/tpmprovider/v4"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// TCG EK Credential Profile attributes (in the EK certificate's subjectAltName directoryName)
+var (
+	oidSubjectAltName      = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidTpmManufacturer     = asn1.ObjectIdentifier{2, 23, 133, 2, 1}
+	oidTpmModel            = asn1.ObjectIdentifier{2, 23, 133, 2, 2}
+	oidTpmFirmwareVersion  = asn1.ObjectIdentifier{2, 23, 133, 2, 3}
+	directoryNameTag       = 4
+	manufacturerIDPrefix   = "id:"
+	endorsementCAFileTypes = []string{".pem", ".cer", ".crt", ".der"}
+)
+
+// TPM vendor ids from the TCG Vendor ID Registry
+var tpmVendorNames = map[string]string{
+	"414D4400": "AMD",
+	"41544D4C": "Atmel",
+	"4252434D": "Broadcom",
+	"48504500": "HPE",
+	"49424D00": "IBM",
+	"49465800": "Infineon",
+	"494E5443": "Intel",
+	"4C454E00": "Lenovo",
+	"4D534654": "Microsoft",
+	"4E534D20": "National Semiconductor",
+	"4E545A00": "Nationz",
+	"4E544300": "Nuvoton",
+	"51434F4D": "Qualcomm",
+	"534D5343": "SMSC",
+	"53544D20": "STMicroelectronics",
+	"534D534E": "Samsung",
+	"534E5300": "Sinosun",
+	"54584E00": "Texas Instruments",
+	"57454300": "Winbond",
+	"474F4F47": "Google",
+}
+
+// EndorsementKeyReport is the result of validating an EK certificate chain against the TPM
+// manufacturer CAs in constants.EndorsementCADir.
+type EndorsementKeyReport struct {
+	KeyType         string `json:"key_type"`
+	Manufacturer    string `json:"manufacturer"`
+	ManufacturerID  string `json:"manufacturer_id"`
+	Model           string `json:"model"`
+	FirmwareVersion string `json:"firmware_version"`
+	ChainLength     int    `json:"chain_length"`
+	Trusted         bool   `json:"trusted"`
+	TrustAnchor     string `json:"trust_anchor,omitempty"`
+	Status          string `json:"status"`
+}
+
+// LoadEndorsementCAs reads the (pem or der encoded) TPM manufacturer CA certificates in
+// 'caDir'.  The number of certificates that were loaded is also returned.
+func LoadEndorsementCAs(caDir string) (*x509.CertPool, int, error) {
+	files, err := ioutil.ReadDir(caDir)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "Error reading the endorsement CA directory %s", caDir)
+	}
+
+	roots := x509.NewCertPool()
+	count := 0
+
+	for _, file := range files {
+		if file.IsDir() || !hasEndorsementCAFileType(file.Name()) {
+			continue
+		}
+
+		certBytes, err := ioutil.ReadFile(filepath.Join(caDir, file.Name()))
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "Error reading %s", file.Name())
+		}
+
+		certs, err := parseCertificates(certBytes)
+		if err != nil {
+			log.WithError(err).Warnf("util/ek_verify:LoadEndorsementCAs() Skipping invalid endorsement CA file %s", file.Name())
+			continue
+		}
+
+		for _, cert := range certs {
+			roots.AddCert(cert)
+			count++
+		}
+	}
+
+	return roots, count, nil
+}
+
+// VerifyEndorsementKeyCertificateChain verifies 'chain' (EK certificate first, see
+// GetEndorsementKeyCertificateChain) against 'roots' and returns the trust anchor.
+func VerifyEndorsementKeyCertificateChain(chain []*x509.Certificate, roots *x509.CertPool) (*x509.Certificate, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("The EK certificate chain is empty")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	// EK certificates have an empty subject and a critical subjectAltName that only contains
+	// the TPM's directoryName, which the x509 package reports as unhandled.
+	ekCert := *chain[0]
+	ekCert.UnhandledCriticalExtensions = nil
+	for _, oid := range chain[0].UnhandledCriticalExtensions {
+		if !oid.Equal(oidSubjectAltName) {
+			ekCert.UnhandledCriticalExtensions = append(ekCert.UnhandledCriticalExtensions, oid)
+		}
+	}
+
+	verifiedChains, err := ekCert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	verifiedChain := verifiedChains[0]
+	return verifiedChain[len(verifiedChain)-1], nil
+}
+
+// GetTpmManufacturerInfo returns the TPM manufacturer id (ex. "4E544300"), model and firmware
+// version from the EK certificate's subjectAltName (TCG EK Credential Profile).
+func GetTpmManufacturerInfo(ekCert *x509.Certificate) (manufacturerID string, model string, firmwareVersion string) {
+	for _, extension := range ekCert.Extensions {
+		if !extension.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+
+		var generalNames asn1.RawValue
+		_, err := asn1.Unmarshal(extension.Value, &generalNames)
+		if err != nil {
+			log.WithError(err).Debug("util/ek_verify:GetTpmManufacturerInfo() Error parsing subjectAltName")
+			return
+		}
+
+		for rest := generalNames.Bytes; len(rest) > 0; {
+			var generalName asn1.RawValue
+			rest, err = asn1.Unmarshal(rest, &generalName)
+			if err != nil {
+				log.WithError(err).Debug("util/ek_verify:GetTpmManufacturerInfo() Error parsing subjectAltName")
+				return
+			}
+
+			if generalName.Class != asn1.ClassContextSpecific || generalName.Tag != directoryNameTag {
+				continue
+			}
+
+			var directoryName pkix.RDNSequence
+			_, err = asn1.Unmarshal(generalName.Bytes, &directoryName)
+			if err != nil {
+				log.WithError(err).Debug("util/ek_verify:GetTpmManufacturerInfo() Error parsing directoryName")
+				continue
+			}
+
+			for _, rdn := range directoryName {
+				for _, attribute := range rdn {
+					value, ok := attribute.Value.(string)
+					if !ok {
+						continue
+					}
+
+					switch {
+					case attribute.Type.Equal(oidTpmManufacturer):
+						manufacturerID = strings.ToUpper(strings.TrimPrefix(value, manufacturerIDPrefix))
+					case attribute.Type.Equal(oidTpmModel):
+						model = value
+					case attribute.Type.Equal(oidTpmFirmwareVersion):
+						firmwareVersion = strings.TrimPrefix(value, manufacturerIDPrefix)
+					}
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// GetTpmVendorName returns the vendor name of a TCG manufacturer id (or the ascii value of
+// the id when the vendor is not known).
+func GetTpmVendorName(manufacturerID string) string {
+	if name, ok := tpmVendorNames[manufacturerID]; ok {
+		return name
+	}
+
+	idBytes, err := hex.DecodeString(manufacturerID)
+	if err != nil || manufacturerID == "" {
+		return "unknown"
+	}
+
+	return strings.TrimRight(string(idBytes), "\x00 ")
+}
+
+// CreateEndorsementKeyReports validates the RSA and ECC EK certificate chains in the TPM
+// against the TPM manufacturer CAs in 'caDir'.
+func CreateEndorsementKeyReports(tpm tpmprovider.TpmProvider, ownerSecretKey string, caDir string) ([]EndorsementKeyReport, error) {
+	log.Trace("util/ek_verify:CreateEndorsementKeyReports() Entering")
+	defer log.Trace("util/ek_verify:CreateEndorsementKeyReports() Leaving")
+
+	roots, caCount, err := LoadEndorsementCAs(caDir)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := []EndorsementKeyReport{}
+	for _, ekCertIndex := range EndorsementKeyCertificateIndexes {
+		chain, err := GetEndorsementKeyCertificateChain(tpm, ownerSecretKey, ekCertIndex.NvIndex)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error reading the %s EK certificate", ekCertIndex.KeyType)
+		}
+
+		if chain == nil {
+			continue
+		}
+
+		reports = append(reports, createEndorsementKeyReport(ekCertIndex.KeyType, chain, roots, caCount, caDir))
+	}
+
+	if len(reports) == 0 {
+		return nil, errors.New("The TPM does not have an EK certificate")
+	}
+
+	return reports, nil
+}
+
+func createEndorsementKeyReport(keyType string, chain []*x509.Certificate, roots *x509.CertPool, caCount int, caDir string) EndorsementKeyReport {
+	report := EndorsementKeyReport{
+		KeyType:     keyType,
+		ChainLength: len(chain),
+	}
+
+	report.ManufacturerID, report.Model, report.FirmwareVersion = GetTpmManufacturerInfo(chain[0])
+	report.Manufacturer = GetTpmVendorName(report.ManufacturerID)
+
+	if caCount == 0 {
+		report.Status = "No TPM manufacturer CAs are installed in " + caDir
+		return report
+	}
+
+	trustAnchor, err := VerifyEndorsementKeyCertificateChain(chain, roots)
+	if err != nil {
+		report.Status = "The EK certificate chain is not trusted: " + err.Error()
+		return report
+	}
+
+	report.Trusted = true
+	report.TrustAnchor = trustAnchor.Subject.String()
+	report.Status = "The EK certificate chain is trusted"
+	return report
+}
+
+func hasEndorsementCAFileType(fileName string) bool {
+	for _, fileType := range endorsementCAFileTypes {
+		if strings.HasSuffix(strings.ToLower(fileName), fileType) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCertificates parses one or more pem encoded certificates, or a der encoded certificate.
+func parseCertificates(certBytes []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+
+	for rest := certBytes; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) > 0 {
+		return certs, nil
+	}
+
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*x509.Certificate{cert}, nil
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// createTestEkCertificate creates an EK certificate as described by the TCG EK Credential
+// Profile (empty subject, critical subjectAltName with the TPM's directoryName).
+func createTestEkCertificate(t *testing.T, issuer *x509.Certificate, issuerKey *ecdsa.PrivateKey) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	directoryName, err := asn1.Marshal(pkix.RDNSequence{
+		{
+			{Type: oidTpmManufacturer, Value: "id:4E544300"},
+			{Type: oidTpmModel, Value: "NPCT75x"},
+			{Type: oidTpmFirmwareVersion, Value: "id:00070002"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	generalNames, err := asn1.Marshal([]asn1.RawValue{
+		{Class: asn1.ClassContextSpecific, Tag: directoryNameTag, IsCompound: true, Bytes: directoryName},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(10),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageKeyAgreement,
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidSubjectAltName, Critical: true, Value: generalNames},
+		},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func TestVerifyEndorsementKeyCertificateChain(t *testing.T) {
+	root, rootKey := createTestCertificate(t, "root", 1, nil, nil)
+	intermediate, intermediateKey := createTestCertificate(t, "intermediate", 2, root, rootKey)
+	ek := createTestEkCertificate(t, intermediate, intermediateKey)
+	otherRoot, _ := createTestCertificate(t, "other root", 3, nil, nil)
+
+	caDir, err := ioutil.TempDir("", "endorsement-cas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(caDir)
+
+	err = ioutil.WriteFile(filepath.Join(caDir, "root.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(caDir, "other-root.cer"), otherRoot.Raw, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(caDir, "README"), []byte("not a certificate"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots, caCount, err := LoadEndorsementCAs(caDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if caCount != 2 {
+		t.Fatalf("Expected 2 endorsement CAs, got %d", caCount)
+	}
+
+	chain := []*x509.Certificate{ek, intermediate}
+	trustAnchor, err := VerifyEndorsementKeyCertificateChain(chain, roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if trustAnchor.Subject.CommonName != "root" {
+		t.Fatalf("Expected the trust anchor to be 'root', got '%s'", trustAnchor.Subject.CommonName)
+	}
+
+	// without the intermediate certificate, the chain cannot be built
+	report := createEndorsementKeyReport("ECC", []*x509.Certificate{ek}, roots, caCount, caDir)
+	if report.Trusted {
+		t.Fatal("Expected the EK certificate to be untrusted without its issuing certificate")
+	}
+
+	report = createEndorsementKeyReport("ECC", chain, roots, caCount, caDir)
+	if !report.Trusted {
+		t.Fatalf("Expected the EK certificate chain to be trusted: %s", report.Status)
+	}
+
+	if report.Manufacturer != "Nuvoton" || report.ManufacturerID != "4E544300" || report.Model != "NPCT75x" || report.FirmwareVersion != "00070002" {
+		t.Fatalf("Unexpected TPM manufacturer info: %+v", report)
+	}
+}
+
+func TestGetTpmVendorName(t *testing.T) {
+	if name := GetTpmVendorName("49465800"); name != "Infineon" {
+		t.Fatalf("Expected 'Infineon', got '%s'", name)
+	}
+
+	if name := GetTpmVendorName("41424300"); name != "ABC" {
+		t.Fatalf("Expected 'ABC', got '%s'", name)
+	}
+
+	if name := GetTpmVendorName(""); name != "unknown" {
+		t.Fatalf("Expected 'unknown', got '%s'", name)
+	}
+}
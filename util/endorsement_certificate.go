@@ -6,6 +6,7 @@
 package util
 
 import (
+	"bytes"
 	"crypto/x509"
 	"encoding/asn1"
 	"intel/isecl/lib/tpmprovider/v4"
@@ -42,38 +43,164 @@ func GetEndorsementKeyCertificateBytes(ownerSecretKey string) ([]byte, error) {
 		return nil, errors.Errorf("The TPM does not have an RSA EK Certificate at the default index 0x%x", tpmprovider.NV_IDX_RSA_ENDORSEMENT_CERTIFICATE)
 	}
 
-	ekCertBytes, err := tpm.NvRead(ownerSecretKey, tpmprovider.TPM2_RH_OWNER, tpmprovider.NV_IDX_RSA_ENDORSEMENT_CERTIFICATE)
+	ekCertBytes, err := readEndorsementKeyCertificate(tpm, ownerSecretKey, tpmprovider.NV_IDX_RSA_ENDORSEMENT_CERTIFICATE)
 	if err != nil {
-		return nil, errors.Wrap(err, "util/endorsement_certificate:GetEndorsementKeyCertificateBytes() Error while performing tpm Nv read operation for getting endorsement certificate in bytes")
+		return nil, err
 	}
 
-	ekCertBytes, err = trimEkCertForTrailingData(ekCertBytes)
+	issuingCertChainBytes, err := readEndorsementKeyIssuingChain(tpm, ownerSecretKey)
 	if err != nil {
 		return nil, err
 	}
 
-	// check if the multi-level EK issuer cert chain is provisioned
-	// check to see if the EK Certificate exists...
-	eccOnDieCaCertChainExists, err := tpm.NvIndexExists(tpmprovider.NV_IDX_X509_P384_EK_CERTCHAIN)
+	// assemble the full EC chain with the issuing certificates first
+	if issuingCertChainBytes != nil {
+		var fullChainBytes []byte
+		fullChainBytes = append(fullChainBytes, issuingCertChainBytes...)
+		fullChainBytes = append(fullChainBytes, ekCertBytes...)
+		ekCertBytes = fullChainBytes
+	}
+	return ekCertBytes, nil
+}
+
+// EndorsementKeyCertificateIndexes are the nv indexes of the RSA and ECC EK certificates
+// (TCG EK Credential Profile), keyed by the EK's algorithm.
+var EndorsementKeyCertificateIndexes = []struct {
+	KeyType string
+	NvIndex uint32
+}{
+	{"RSA", tpmprovider.NV_IDX_RSA_ENDORSEMENT_CERTIFICATE},
+	{"ECC", tpmprovider.NV_IDX_ECC_ENDORSEMENT_CERTIFICATE},
+}
+
+// GetEndorsementKeyCertificateChain returns the EK certificate at 'ekCertIndex' followed by
+// its issuing certificates (when the on-die CA chain is provisioned at
+// tpmprovider.NV_IDX_X509_P384_EK_CERTCHAIN).  nil is returned when the TPM does not have an
+// EK certificate at 'ekCertIndex'.
+func GetEndorsementKeyCertificateChain(tpm tpmprovider.TpmProvider, ownerSecretKey string, ekCertIndex uint32) ([]*x509.Certificate, error) {
+	log.Trace("util/endorsement_certificate:GetEndorsementKeyCertificateChain() Entering")
+	defer log.Trace("util/endorsement_certificate:GetEndorsementKeyCertificateChain() Leaving")
+
+	ekCertificateExists, err := tpm.NvIndexExists(ekCertIndex)
 	if err != nil {
-		return nil, errors.Wrap(err, "Error checking if the EK Issuing Cert Chain is present")
+		return nil, errors.Wrapf(err, "Error checking if the EK Certificate at 0x%x is present", ekCertIndex)
 	}
 
-	// cert chain exists - proceed to retrieve
-	if eccOnDieCaCertChainExists {
-		issuingCertChainBytes, err := tpm.NvRead(ownerSecretKey, tpmprovider.TPM2_RH_OWNER, tpmprovider.NV_IDX_X509_P384_EK_CERTCHAIN)
+	if !ekCertificateExists {
+		return nil, nil
+	}
+
+	ekCertBytes, err := readEndorsementKeyCertificate(tpm, ownerSecretKey, ekCertIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	ekCert, err := x509.ParseCertificate(ekCertBytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error while parsing the EK Certificate at 0x%x", ekCertIndex)
+	}
+
+	issuingCertChainBytes, err := readEndorsementKeyIssuingChain(tpm, ownerSecretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if issuingCertChainBytes == nil {
+		return []*x509.Certificate{ekCert}, nil
+	}
+
+	issuingCerts, err := parseCertificateChain(issuingCertChainBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while parsing the EK Issuing Cert Chain")
+	}
+
+	return orderCertificateChain(ekCert, issuingCerts), nil
+}
+
+// parseCertificateChain parses concatenated der certificates, ignoring padding at the end
+// of the nv index (see trimEkCertForTrailingData).
+func parseCertificateChain(chainBytes []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+
+	for len(chainBytes) > 0 && chainBytes[0] == 0x30 { // asn1 SEQUENCE
+		var cert asn1.RawValue
+		rest, err := asn1.Unmarshal(chainBytes, &cert)
 		if err != nil {
-			return nil, errors.Wrap(err, "util/endorsement_certificate:GetEndorsementKeyCertificateBytes() Error "+
-				"while performing tpm Nv read operation for getting endorsement certificate chain in bytes")
+			return nil, errors.Wrap(err, "Error while asn1 unmarshalling certificate chain")
 		}
 
-		// assemble the full EC chain with the issuing certificates first
-		var fullChainBytes []byte
-		fullChainBytes = append(fullChainBytes, issuingCertChainBytes...)
-		fullChainBytes = append(fullChainBytes, ekCertBytes...)
-		ekCertBytes = fullChainBytes
+		parsedCert, err := x509.ParseCertificate(cert.FullBytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error while parsing certificate chain")
+		}
+
+		certs = append(certs, parsedCert)
+		chainBytes = rest
 	}
-	return ekCertBytes, nil
+
+	return certs, nil
+}
+
+// orderCertificateChain returns 'leaf' followed by its issuer, the issuer's issuer, etc.
+// Certificates that are not part of the path are appended in their original order.
+func orderCertificateChain(leaf *x509.Certificate, certs []*x509.Certificate) []*x509.Certificate {
+	chain := []*x509.Certificate{leaf}
+	used := make([]bool, len(certs))
+
+	for current := leaf; ; {
+		found := false
+		for i, cert := range certs {
+			if !used[i] && bytes.Equal(cert.RawSubject, current.RawIssuer) {
+				used[i] = true
+				chain = append(chain, cert)
+				current = cert
+				found = true
+				break
+			}
+		}
+
+		if !found || bytes.Equal(current.RawSubject, current.RawIssuer) {
+			break
+		}
+	}
+
+	for i, cert := range certs {
+		if !used[i] {
+			chain = append(chain, cert)
+		}
+	}
+
+	return chain
+}
+
+func readEndorsementKeyCertificate(tpm tpmprovider.TpmProvider, ownerSecretKey string, ekCertIndex uint32) ([]byte, error) {
+	ekCertBytes, err := tpm.NvRead(ownerSecretKey, tpmprovider.TPM2_RH_OWNER, ekCertIndex)
+	if err != nil {
+		return nil, errors.Wrap(err, "util/endorsement_certificate:readEndorsementKeyCertificate() Error while performing tpm Nv read operation for getting endorsement certificate in bytes")
+	}
+
+	return trimEkCertForTrailingData(ekCertBytes)
+}
+
+// readEndorsementKeyIssuingChain returns the (der encoded) certificates of the multi-level EK
+// issuer cert chain, or nil when the chain is not provisioned.
+func readEndorsementKeyIssuingChain(tpm tpmprovider.TpmProvider, ownerSecretKey string) ([]byte, error) {
+	eccOnDieCaCertChainExists, err := tpm.NvIndexExists(tpmprovider.NV_IDX_X509_P384_EK_CERTCHAIN)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error checking if the EK Issuing Cert Chain is present")
+	}
+
+	if !eccOnDieCaCertChainExists {
+		return nil, nil
+	}
+
+	issuingCertChainBytes, err := tpm.NvRead(ownerSecretKey, tpmprovider.TPM2_RH_OWNER, tpmprovider.NV_IDX_X509_P384_EK_CERTCHAIN)
+	if err != nil {
+		return nil, errors.Wrap(err, "util/endorsement_certificate:readEndorsementKeyIssuingChain() Error "+
+			"while performing tpm Nv read operation for getting endorsement certificate chain in bytes")
+	}
+
+	return issuingCertChainBytes, nil
 }
 
 // ISECL-12285: Trims the trailing data if there are any at the end of tpm endorsement certificate.
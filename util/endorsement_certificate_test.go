@@ -0,0 +1,81 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func createTestCertificate(t *testing.T, commonName string, serial int64, issuer *x509.Certificate, issuerKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  issuer == nil || commonName != "ek",
+		BasicConstraintsValid: true,
+	}
+
+	if issuer == nil {
+		issuer, issuerKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+func TestEndorsementKeyCertificateChain(t *testing.T) {
+	root, rootKey := createTestCertificate(t, "root", 1, nil, nil)
+	intermediate, intermediateKey := createTestCertificate(t, "intermediate", 2, root, rootKey)
+	ek, _ := createTestCertificate(t, "ek", 3, intermediate, intermediateKey)
+
+	// issuing chain as stored in the nv index (root first, zero padded)
+	var chainBytes []byte
+	chainBytes = append(chainBytes, root.Raw...)
+	chainBytes = append(chainBytes, intermediate.Raw...)
+	chainBytes = append(chainBytes, make([]byte, 32)...)
+
+	issuingCerts, err := parseCertificateChain(chainBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(issuingCerts) != 2 {
+		t.Fatalf("Expected 2 issuing certificates, got %d", len(issuingCerts))
+	}
+
+	chain := orderCertificateChain(ek, issuingCerts)
+	expected := []string{"ek", "intermediate", "root"}
+	if len(chain) != len(expected) {
+		t.Fatalf("Expected %d certificates, got %d", len(expected), len(chain))
+	}
+
+	for i, cert := range chain {
+		if cert.Subject.CommonName != expected[i] {
+			t.Fatalf("Expected certificate %d to be '%s', got '%s'", i, expected[i], cert.Subject.CommonName)
+		}
+	}
+}
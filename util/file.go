@@ -0,0 +1,100 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package util
+
+import (
+	"intel/isecl/go-trust-agent/v4/constants"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+
+	"github.com/intel-secl/intel-secl/v4/pkg/lib/common/utils"
+	"github.com/pkg/errors"
+)
+
+// serviceUserName is the user that runs the trust-agent service (see tagent.service).
+var serviceUserName = constants.TagentUserName
+
+// ChownToServiceUser gives the service user ownership of 'path' when the current process runs
+// as root (ex. 'tagent setup' or 'tagent tag clear'), so that the service can still read and
+// update files that were created by root.  It does nothing when not running as root or when
+// running in a container (where the service runs as root).
+func ChownToServiceUser(path string) error {
+	if os.Geteuid() != 0 || utils.IsContainerEnv() {
+		return nil
+	}
+
+	serviceUser, err := user.Lookup(serviceUserName)
+	if _, ok := err.(user.UnknownUserError); ok {
+		// the trust-agent has not been installed (ex. development/test environments)
+		log.Warnf("util/file:ChownToServiceUser() User '%s' does not exist, %s is owned by root", serviceUserName, path)
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "Could not find user '%s'", serviceUserName)
+	}
+
+	uid, err := strconv.Atoi(serviceUser.Uid)
+	if err != nil {
+		return errors.Wrapf(err, "Could not parse the uid of user '%s'", serviceUserName)
+	}
+
+	gid, err := strconv.Atoi(serviceUser.Gid)
+	if err != nil {
+		return errors.Wrapf(err, "Could not parse the gid of user '%s'", serviceUserName)
+	}
+
+	err = os.Chown(path, uid, gid)
+	if err != nil {
+		return errors.Wrapf(err, "Could not change the owner of %s to '%s'", path, serviceUserName)
+	}
+
+	return nil
+}
+
+// WriteFileAtomic writes 'data' to a temporary file in the directory of 'path' (owned by the
+// service user, see ChownToServiceUser) and renames it to 'path', so that readers see either
+// the previous or the new contents, never a partially written file.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return errors.Wrapf(err, "Could not create a temporary file for %s", path)
+	}
+
+	tmpPath := tmpFile.Name()
+	defer func() {
+		// removes the temporary file when it was not renamed
+		_ = os.Remove(tmpPath)
+	}()
+
+	_, err = tmpFile.Write(data)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Could not write %s", tmpPath)
+	}
+
+	err = os.Chmod(tmpPath, perm)
+	if err != nil {
+		return errors.Wrapf(err, "Could not change the permissions of %s", tmpPath)
+	}
+
+	err = ChownToServiceUser(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return errors.Wrapf(err, "Could not replace %s", path)
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package util
+
+import (
+	"intel/isecl/go-trust-agent/v4/constants"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	// the tests may run as root on hosts without the 'tagent' user
+	if os.Geteuid() == 0 {
+		currentUser, err := user.Current()
+		if err != nil {
+			t.Fatal(err)
+		}
+		serviceUserName = currentUser.Username
+		defer func() { serviceUserName = constants.TagentUserName }()
+	}
+
+	dir, err := ioutil.TempDir("", "write-file-atomic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "aik.pem")
+	err = ioutil.WriteFile(path, []byte("previous"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = WriteFileAtomic(path, []byte("renewed"), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "renewed" {
+		t.Errorf("Expected 'renewed', got '%s'", string(data))
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("Expected permissions 0640, got %o", info.Mode().Perm())
+	}
+
+	serviceUser, err := user.Lookup(serviceUserName)
+	if err == nil && os.Geteuid() == 0 {
+		uid, _ := strconv.Atoi(serviceUser.Uid)
+		if int(info.Sys().(*syscall.Stat_t).Uid) != uid {
+			t.Errorf("Expected %s to be owned by '%s'", path, serviceUserName)
+		}
+	}
+
+	// the temporary file must not be left behind
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 {
+		t.Errorf("Expected only %s in %s, found %d files", path, dir, len(files))
+	}
+}
+
+func TestWriteFileAtomicMissingDirectory(t *testing.T) {
+	err := WriteFileAtomic(filepath.Join(os.TempDir(), "does-not-exist", "aik.pem"), []byte("renewed"), 0640)
+	if err == nil {
+		t.Fatal("Expected an error when the directory does not exist")
+	}
+}